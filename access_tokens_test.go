@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/handlers/comments"
+	"forum/handlers/posts"
+)
+
+// newTokenServer serves the write routes tokens may reach, wrapped as
+// main.go wraps them.
+func newTokenServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/delete-post", auth.Require(auth.PermEditPost, posts.DeletePostHandler))
+	mux.HandleFunc("/create-comment", auth.Require(auth.PermComment, comments.CreateCommentHandler))
+	mux.HandleFunc("/delete-comment", auth.Require(auth.PermEditComment, comments.DeleteCommentHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newAccessToken stores a token for userID with the given scopes.
+func newAccessToken(t *testing.T, userID int, scopes ...string) string {
+	t.Helper()
+	token := "fpat_test-" + strconv.Itoa(userID) + "-" + strings.Join(scopes, "-")
+	sum := sha256.Sum256([]byte(token))
+	_, err := database.DB.Exec(
+		"INSERT INTO access_tokens (user_id, name, token_hash, scopes) VALUES (?, 'test', ?, ?)",
+		userID, hex.EncodeToString(sum[:]), strings.Join(scopes, ","),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newPost inserts a post by userID and returns its ID.
+func newPost(t *testing.T, userID int) int {
+	t.Helper()
+	res, err := database.DB.Exec(
+		"INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", userID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// postWithToken sends form as a script would and returns the status.
+func postWithToken(t *testing.T, srv *httptest.Server, path, token string, form url.Values) int {
+	t.Helper()
+	req, err := http.NewRequest("POST", srv.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := newClient(t).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPostScopedTokenCannotEditOrDelete(t *testing.T) {
+	srv := newTokenServer(t)
+	authorID := newUser(t, "token-author@example.com", "tokenauthor", "Zebra-Orbit-991", true)
+	modID := newUser(t, "token-mod@example.com", "tokenmod", "Zebra-Orbit-991", true)
+	if _, err := database.DB.Exec("UPDATE users SET role = ? WHERE id = ?", auth.RoleModerator, modID); err != nil {
+		t.Fatal(err)
+	}
+	postID := newPost(t, authorID)
+
+	// The scope covers writing new content...
+	author := newAccessToken(t, authorID, auth.ScopePost)
+	code := postWithToken(t, srv, "/create-comment", author, url.Values{
+		"post_id": {strconv.Itoa(postID)}, "content": {"From a script"},
+	})
+	if code != http.StatusSeeOther {
+		t.Fatalf("/create-comment with a post token: status %d, want %d", code, http.StatusSeeOther)
+	}
+	var commentID int
+	database.DB.QueryRow("SELECT id FROM comments WHERE post_id = ?", postID).Scan(&commentID)
+
+	// ...but not changing or removing it, the author's own or, for a
+	// moderator, anyone's
+	mod := newAccessToken(t, modID, auth.ScopeRead, auth.ScopePost, auth.ScopeVote)
+	for _, token := range []string{author, mod} {
+		if code := postWithToken(t, srv, "/delete-post", token, url.Values{"id": {strconv.Itoa(postID)}}); code != http.StatusForbidden {
+			t.Errorf("/delete-post with a token: status %d, want %d", code, http.StatusForbidden)
+		}
+		if code := postWithToken(t, srv, "/delete-comment", token, url.Values{"id": {strconv.Itoa(commentID)}}); code != http.StatusForbidden {
+			t.Errorf("/delete-comment with a token: status %d, want %d", code, http.StatusForbidden)
+		}
+	}
+
+	var deleted int
+	database.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM posts WHERE id = ? AND deleted_at IS NOT NULL)
+		     + (SELECT COUNT(*) FROM comments WHERE id = ? AND deleted_at IS NOT NULL)
+	`, postID, commentID).Scan(&deleted)
+	if deleted != 0 {
+		t.Errorf("tokens deleted %d items", deleted)
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are read from environment variables so they can be set in
+// docker-compose.yml without rebuilding. Every getter falls back to a
+// default when the variable is unset or malformed.
+
+// String returns the value of key, or fallback if unset.
+func String(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Int returns the integer value of key, or fallback.
+func Int(key string, fallback int) int {
+	n, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// Bool returns the boolean value of key ("1", "true", "yes"...), or fallback.
+func Bool(key string, fallback bool) bool {
+	switch strings.ToLower(String(key, "")) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return fallback
+}
+
+// Duration returns the duration value of key (e.g. "15m"), or fallback.
+func Duration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(String(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// List returns the comma-separated values of key, trimmed, or nil.
+func List(key string) []string {
+	var out []string
+	for _, v := range strings.Split(String(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// BaseURL is the public address of the forum, used to build links in emails.
+func BaseURL() string {
+	return strings.TrimRight(String("FORUM_BASE_URL", "http://localhost:8080"), "/")
+}
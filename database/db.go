@@ -10,6 +10,13 @@ import (
 
 var DB *sql.DB
 
+// Placeholder account that keeps the posts and comments of anonymised users.
+// It has no password, so nobody can log in as it.
+const (
+	DeletedUserEmail    = "deleted-user@forum.invalid"
+	DeletedUserUsername = "[deleted]"
+)
+
 func InitDB() {
 	var err error
 
@@ -47,11 +54,16 @@ func InitDB() {
 		}
 
 		SeedCategories()
+		SeedDeletedUser()
+		markSchemaCurrent()
 		log.Println("📦 Fresh database created successfully.")
 
 	} else {
 		log.Println("📦 Database exists — skipping schema creation.")
+		runMigrations()
 	}
+
+	initSearch()
 }
 
 func SeedCategories() {
@@ -75,3 +87,15 @@ func SeedCategories() {
 		}
 	}
 }
+
+// SeedDeletedUser creates the "deleted user" placeholder account.
+func SeedDeletedUser() {
+	_, err := DB.Exec(`
+		INSERT OR IGNORE INTO users (email, username, password, email_verified)
+		VALUES (?, ?, '', 1)
+	`, DeletedUserEmail, DeletedUserUsername)
+
+	if err != nil {
+		log.Println("Error inserting deleted user placeholder:", err)
+	}
+}
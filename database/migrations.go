@@ -0,0 +1,516 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// migration upgrades an existing database by one schema step.
+// schema.sql always describes the latest schema, so fresh databases skip
+// every migration; older databases (like a forum.db created before a
+// feature existed) run the steps they are missing, in order.
+type migration struct {
+	name string
+	up   func() error
+}
+
+var migrations = []migration{
+	{"sessions device metadata", migrateSessionMetadata},
+	{"password reset tokens", migrateResetTokens},
+	{"email verification", migrateEmailVerification},
+	{"two-factor authentication", migrateTwoFactor},
+	{"external login identities", migrateUserIdentities},
+	{"login throttling", migrateAuthThrottle},
+	{"session CSRF tokens", migrateSessionCSRF},
+	{"user roles", migrateUserRoles},
+	{"account history", migrateAccountHistory},
+	{"account deletion", migrateAccountDeletion},
+	{"personal access tokens", migrateAccessTokens},
+	{"invites", migrateInvites},
+	{"magic links", migrateMagicLinks},
+	{"passkeys", migratePasskeys},
+	{"post revisions", migratePostRevisions},
+	{"comment revisions", migrateCommentRevisions},
+	{"threaded comments", migrateCommentThreads},
+	{"rendered markdown", migrateRenderedContent},
+	{"post feed index", migratePostFeedIndex},
+	{"post scores", migratePostScores},
+	{"post images", migratePostImages},
+	{"invite email binding", migrateInviteEmail},
+}
+
+// runMigrations applies every migration newer than PRAGMA user_version.
+func runMigrations() {
+	var version int
+	if err := DB.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		log.Fatalf("Error reading schema version: %v", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		log.Printf("📌 Applying migration %d: %s", i+1, m.name)
+		if err := m.up(); err != nil {
+			log.Fatalf("Migration %q failed: %v", m.name, err)
+		}
+		setSchemaVersion(i + 1)
+	}
+}
+
+// markSchemaCurrent records that a freshly created schema needs no migrations.
+func markSchemaCurrent() {
+	setSchemaVersion(len(migrations))
+}
+
+func setSchemaVersion(v int) {
+	// PRAGMA does not accept bound parameters.
+	if _, err := DB.Exec(fmt.Sprintf("PRAGMA user_version = %d", v)); err != nil {
+		log.Fatalf("Error writing schema version: %v", err)
+	}
+}
+
+// columnExists reports whether table has the given column.
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+
+	exists := false
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue any
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if strings.EqualFold(name, column) {
+			exists = true
+		}
+	}
+	rows.Close()
+
+	return exists, nil
+}
+
+// addColumn adds a column unless the table already has it.
+func addColumn(table, column, definition string) error {
+	exists, err := columnExists(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// execAll runs a list of statements, stopping at the first error.
+func execAll(stmts ...string) error {
+	for _, s := range stmts {
+		if _, err := DB.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+// MIGRATIONS
+// ------------------------------------------------------------
+
+func migrateSessionMetadata() error {
+	// ALTER TABLE cannot add a column with a non-constant default,
+	// so created_at is backfilled instead.
+	for _, c := range [][2]string{
+		{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+		{"ip_address", "TEXT NOT NULL DEFAULT ''"},
+		{"created_at", "DATETIME"},
+		{"last_seen_at", "DATETIME"},
+	} {
+		if err := addColumn("sessions", c[0], c[1]); err != nil {
+			return err
+		}
+	}
+
+	return execAll(
+		`UPDATE sessions SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL`,
+		`UPDATE sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+	)
+}
+
+func migrateResetTokens() error {
+	return execAll(`
+		CREATE TABLE IF NOT EXISTS reset_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+}
+
+func migrateEmailVerification() error {
+	// Accounts created before verification existed are trusted as-is.
+	exists, err := columnExists("users", "email_verified")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := addColumn("users", "email_verified", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if _, err := DB.Exec("UPDATE users SET email_verified = 1"); err != nil {
+			return err
+		}
+	}
+
+	return execAll(`
+		CREATE TABLE IF NOT EXISTS email_verifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			email TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+}
+
+func migrateTwoFactor() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS totp_secrets (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_used_step INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS login_challenges (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+	)
+}
+
+func migrateUserIdentities() error {
+	return execAll(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(provider, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+}
+
+func migrateAuthThrottle() error {
+	return execAll(`
+		CREATE TABLE IF NOT EXISTS auth_throttle (
+			key TEXT PRIMARY KEY,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_attempt_at DATETIME NOT NULL,
+			locked_until DATETIME
+		)
+	`)
+}
+
+func migrateSessionCSRF() error {
+	if err := addColumn("sessions", "csrf_token", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Give sessions that already exist a token of their own
+	return execAll(`UPDATE sessions SET csrf_token = lower(hex(randomblob(32))) WHERE csrf_token = ''`)
+}
+
+func migrateUserRoles() error {
+	return addColumn("users", "role",
+		"TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'moderator', 'admin'))")
+}
+
+func migrateAccountHistory() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS account_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			old_value TEXT NOT NULL DEFAULT '',
+			new_value TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_account_history_user_id ON account_history(user_id)`,
+	)
+}
+
+func migrateAccountDeletion() error {
+	err := execAll(`
+		CREATE TABLE IF NOT EXISTS account_deletions (
+			user_id INTEGER PRIMARY KEY,
+			mode TEXT NOT NULL CHECK (mode IN ('delete', 'anonymise')),
+			requested_at DATETIME NOT NULL,
+			purge_after DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	SeedDeletedUser()
+	return nil
+}
+
+func migrateAccessTokens() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS access_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			expires_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id)`,
+	)
+}
+
+func migrateInvites() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS invites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code_hash TEXT NOT NULL UNIQUE,
+			hint TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			created_by INTEGER,
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			uses INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			revoked_at DATETIME,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS invite_uses (
+			invite_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL UNIQUE,
+			used_at DATETIME NOT NULL,
+			FOREIGN KEY (invite_id) REFERENCES invites(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+	)
+}
+
+func migrateMagicLinks() error {
+	return execAll(`
+		CREATE TABLE IF NOT EXISTS magic_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			email TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			browser_hash TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+}
+
+func migratePasskeys() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			credential_id TEXT NOT NULL UNIQUE,
+			public_key BLOB NOT NULL,
+			sign_count INTEGER NOT NULL DEFAULT 0,
+			name TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id)`,
+		`CREATE TABLE IF NOT EXISTS webauthn_challenges (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER,
+			kind TEXT NOT NULL CHECK (kind IN ('register', 'login')),
+			challenge TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+	)
+}
+
+func migratePostRevisions() error {
+	for _, c := range [][2]string{
+		{"edited_at", "DATETIME"},
+		{"deleted_at", "DATETIME"},
+		{"deleted_by", "INTEGER REFERENCES users(id) ON DELETE SET NULL"},
+	} {
+		if err := addColumn("posts", c[0], c[1]); err != nil {
+			return err
+		}
+	}
+
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS post_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			editor_id INTEGER,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+			FOREIGN KEY (editor_id) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions(post_id)`,
+	)
+}
+
+func migrateCommentRevisions() error {
+	for _, c := range [][2]string{
+		{"edited_at", "DATETIME"},
+		{"deleted_at", "DATETIME"},
+		{"deleted_by", "INTEGER REFERENCES users(id) ON DELETE SET NULL"},
+	} {
+		if err := addColumn("comments", c[0], c[1]); err != nil {
+			return err
+		}
+	}
+
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS comment_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			comment_id INTEGER NOT NULL,
+			editor_id INTEGER,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+			FOREIGN KEY (editor_id) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment_id ON comment_revisions(comment_id)`,
+	)
+}
+
+func migrateCommentThreads() error {
+	// Replies to a comment that is removed for good move up to the top level
+	err := addColumn("comments", "parent_comment_id", "INTEGER REFERENCES comments(id) ON DELETE SET NULL")
+	if err != nil {
+		return err
+	}
+	return execAll(
+		`CREATE INDEX IF NOT EXISTS idx_comments_parent_comment_id ON comments(parent_comment_id)`,
+	)
+}
+
+func migrateRenderedContent() error {
+	// Existing rows get version 0 and are rendered the first time they're shown
+	for _, table := range []string{"posts", "comments"} {
+		if err := addColumn(table, "content_html", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if err := addColumn(table, "content_html_version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migratePostFeedIndex() error {
+	// Feeds page through posts newest first (see package pagination)
+	return execAll(
+		`CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts(created_at)`,
+	)
+}
+
+func migratePostScores() error {
+	columns := []struct{ name, definition string }{
+		{"upvotes", "INTEGER NOT NULL DEFAULT 0"},
+		{"downvotes", "INTEGER NOT NULL DEFAULT 0"},
+		{"vote_score", "INTEGER NOT NULL DEFAULT 0"},
+		{"hot_score", "REAL NOT NULL DEFAULT 0"},
+		{"controversy_score", "REAL NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumn("posts", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	err := execAll(
+		`CREATE INDEX IF NOT EXISTS idx_posts_vote_score ON posts(vote_score)`,
+		`CREATE INDEX IF NOT EXISTS idx_posts_hot_score ON posts(hot_score)`,
+		`CREATE INDEX IF NOT EXISTS idx_posts_controversy_score ON posts(controversy_score)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Score the existing posts
+	rows, err := DB.Query("SELECT id FROM posts")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	return RefreshPostScores(ids...)
+}
+
+func migratePostImages() error {
+	return execAll(
+		`CREATE TABLE IF NOT EXISTS post_images (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			format TEXT NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			thumbnail_format TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_post_images_post_id ON post_images(post_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_post_images_hash ON post_images(hash)`,
+	)
+}
+
+func migrateInviteEmail() error {
+	return addColumn("invites", "email", "TEXT NOT NULL DEFAULT ''")
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/ranking"
+)
+
+// RefreshPostScores recounts the votes of the given posts and stores the
+// scores the feeds sort by. It must be called whenever post likes change.
+func RefreshPostScores(postIDs ...int) error {
+	for _, id := range postIDs {
+		var up, down int
+		var created int64
+		err := DB.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM likes
+			        WHERE likes.post_id = posts.id AND likes.comment_id IS NULL AND likes.value = 1),
+			       (SELECT COUNT(*) FROM likes
+			        WHERE likes.post_id = posts.id AND likes.comment_id IS NULL AND likes.value = -1),
+			       CAST(strftime('%s', posts.created_at) AS INTEGER)
+			FROM posts WHERE posts.id = ?
+		`, id).Scan(&up, &down, &created)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = DB.Exec(`
+			UPDATE posts
+			SET upvotes = ?, downvotes = ?, vote_score = ?, hot_score = ?, controversy_score = ?
+			WHERE id = ?
+		`, up, down, up-down, ranking.Hot(up, down, time.Unix(created, 0)), ranking.Controversy(up, down), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import "log"
+
+// Full-text search uses SQLite's FTS5 module, which go-sqlite3 only
+// includes when built with -tags sqlite_fts5 (the Dockerfile does).
+// The indexes are therefore set up here at every start rather than in
+// schema.sql and the migrations:
+//
+//   - with FTS5, posts_fts and comments_fts are created if missing, and
+//     triggers keep them in step with posts and comments. If the
+//     triggers were missing the indexes are rebuilt from the tables;
+//   - without it, the triggers are dropped (they would make every write
+//     to posts and comments fail) and search reports itself unavailable.
+//     The next start with FTS5 notices and rebuilds the indexes.
+
+// SearchAvailable reports whether full-text search works (set by InitDB).
+var SearchAvailable bool
+
+var searchTables = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+		title, content,
+		content='posts', content_rowid='id',
+		tokenize='porter unicode61 remove_diacritics 2'
+	)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+		content,
+		content='comments', content_rowid='id',
+		tokenize='porter unicode61 remove_diacritics 2'
+	)`,
+}
+
+// searchTriggers maps each trigger to its definition. The indexes are
+// "external content" tables, so an update has to remove the old text
+// before adding the new one. Only changes to the text itself touch the
+// index (not votes, edit timestamps or soft deletion).
+var searchTriggers = []struct{ name, sql string }{
+	{"posts_fts_insert", `CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END`},
+	{"posts_fts_delete", `CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+	END`},
+	{"posts_fts_update", `CREATE TRIGGER posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+		INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END`},
+	{"comments_fts_insert", `CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+		INSERT INTO comments_fts(rowid, content) VALUES (new.id, new.content);
+	END`},
+	{"comments_fts_delete", `CREATE TRIGGER comments_fts_delete AFTER DELETE ON comments BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END`},
+	{"comments_fts_update", `CREATE TRIGGER comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO comments_fts(rowid, content) VALUES (new.id, new.content);
+	END`},
+}
+
+// initSearch sets up (or disables) full-text search, see above.
+func initSearch() {
+	var fts5 bool
+	if err := DB.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		log.Fatalf("Error checking for FTS5: %v", err)
+	}
+
+	if !fts5 {
+		for _, t := range searchTriggers {
+			if _, err := DB.Exec("DROP TRIGGER IF EXISTS " + t.name); err != nil {
+				log.Fatalf("Error dropping search trigger: %v", err)
+			}
+		}
+		log.Println("⚠️  SQLite was built without FTS5 (go build -tags sqlite_fts5) — search is disabled.")
+		return
+	}
+
+	if err := execAll(searchTables...); err != nil {
+		log.Fatalf("Error creating search tables: %v", err)
+	}
+
+	rebuild := false
+	for _, t := range searchTriggers {
+		var n int
+		err := DB.QueryRow(
+			"SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", t.name,
+		).Scan(&n)
+		if err != nil {
+			log.Fatalf("Error checking search triggers: %v", err)
+		}
+		if n == 0 {
+			if _, err := DB.Exec(t.sql); err != nil {
+				log.Fatalf("Error creating search trigger: %v", err)
+			}
+			rebuild = true
+		}
+	}
+
+	if rebuild {
+		log.Println("📌 Building the search index...")
+		err := execAll(
+			`INSERT INTO posts_fts(posts_fts) VALUES ('rebuild')`,
+			`INSERT INTO comments_fts(comments_fts) VALUES ('rebuild')`,
+		)
+		if err != nil {
+			log.Fatalf("Error building search index: %v", err)
+		}
+	}
+
+	SearchAvailable = true
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/database"
+)
+
+// Personal access tokens
+//
+// Scripts authenticate with "Authorization: Bearer <token>" instead of the
+// session cookie. Tokens are stored hashed, carry a set of scopes and may
+// expire. A token never grants more than its owner's role allows:
+//   - read: GET requests to the routes wrapped in AllowTokenReads (feeds,
+//     posts, search) are made as the token's owner
+//   - post: create posts and comments
+//   - vote: like / dislike
+// Tokens are opt-in per route: everywhere else (editing and deleting,
+// moderation, admin, account settings, sessions...) a token is ignored,
+// as if no one was logged in.
+
+const (
+	ScopeRead = "read"
+	ScopePost = "post"
+	ScopeVote = "vote"
+)
+
+// Scopes lists every scope, in the order the settings page shows them.
+var Scopes = []string{ScopeRead, ScopePost, ScopeVote}
+
+// accessTokenPrefix makes tokens easy to recognise (e.g. by secret scanners).
+const accessTokenPrefix = "fpat_"
+
+// permissionScopes maps the permissions a token may use to the scope it
+// needs. Permissions missing here (PermEditPost, PermModeratePosts...)
+// are refused to every token.
+var permissionScopes = map[Permission]string{
+	PermCreatePost: ScopePost,
+	PermComment:    ScopePost,
+	PermVote:       ScopeVote,
+}
+
+// tokenExpiryOptions are the lifetimes offered when creating a token (0 = never).
+var tokenExpiryOptions = []int{7, 30, 90, 365, 0}
+
+// HasScope reports whether an access-token user was given scope.
+func (u *SessionUser) HasScope(scope string) bool {
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenReadsKey struct{}
+
+// AllowTokenReads lets access tokens with the "read" scope load a route
+// as their owner. Only routes showing forum content should use it.
+func AllowTokenReads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenReadsKey{}, true)))
+	}
+}
+
+func tokenReadsAllowed(r *http.Request) bool {
+	allowed, _ := r.Context().Value(tokenReadsKey{}).(bool)
+	return allowed
+}
+
+// bearerToken returns the token from the Authorization header, or "".
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// userFromAccessToken loads the owner of the request's bearer token.
+// Read requests need the "read" scope; other methods are only accepted
+// when writes is set (Require checks the matching scope afterwards).
+func userFromAccessToken(r *http.Request, writes bool) (*SessionUser, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	var (
+		tokenID    int
+		scopes     string
+		expiresAt  sql.NullTime
+		lastUsedAt sql.NullTime
+		user       SessionUser
+	)
+	err := database.DB.QueryRow(`
+		SELECT access_tokens.id, access_tokens.scopes, access_tokens.expires_at, access_tokens.last_used_at,
+		       users.id, users.username, users.email, users.email_verified, users.role
+		FROM access_tokens
+		JOIN users ON access_tokens.user_id = users.id
+		WHERE access_tokens.token_hash = ?
+	`, hashToken(token)).Scan(&tokenID, &scopes, &expiresAt, &lastUsedAt,
+		&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Role)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if expiresAt.Valid && now.After(expiresAt.Time) {
+		return nil, nil
+	}
+
+	user.Scopes = strings.Split(scopes, ",")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !user.HasScope(ScopeRead) {
+			return nil, nil
+		}
+	default:
+		if !writes {
+			return nil, nil
+		}
+	}
+
+	// Same throttling as the session "last seen" column
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) > lastSeenInterval {
+		_, err := database.DB.Exec(
+			"UPDATE access_tokens SET last_used_at = ? WHERE id = ?", now, tokenID,
+		)
+		if err != nil {
+			log.Println("Error updating token last used:", err)
+		}
+	}
+
+	return &user, nil
+}
+
+// AccessTokenView is one token as listed on the settings page.
+type AccessTokenView struct {
+	ID        int
+	Name      string
+	Scopes    string
+	CreatedAt string
+	LastUsed  string
+	Expires   string
+	Expired   bool
+}
+
+// AccessTokensPageData is passed to settings_tokens.html.
+type AccessTokensPageData struct {
+	User          *SessionUser
+	Tokens        []AccessTokenView
+	Scopes        []string
+	ExpiryOptions []int
+	NewToken      string
+	Error         string
+	Message       string
+}
+
+// ---------------------------------------------------------------------------
+// AccessTokensHandler handles GET + POST /settings/tokens
+// ---------------------------------------------------------------------------
+func AccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if user.Scopes != nil {
+		// A token must not be able to list or mint tokens
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := AccessTokensPageData{
+		User:          user,
+		Scopes:        Scopes,
+		ExpiryOptions: tokenExpiryOptions,
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "create":
+			data.NewToken, data.Error = createAccessToken(r, user)
+			if data.Error == "" {
+				data.Message = "Token created. Copy it now — it won't be shown again."
+			}
+		case "revoke":
+			data.Error = revokeAccessToken(r, user)
+			if data.Error == "" {
+				data.Message = "Token revoked."
+			}
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadAccessTokens(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "settings_tokens.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// createAccessToken stores a new token and returns its plaintext value.
+func createAccessToken(r *http.Request, user *SessionUser) (string, string) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return "", "Give the token a name."
+	}
+
+	var scopes []string
+	for _, s := range Scopes {
+		for _, chosen := range r.Form["scopes"] {
+			if chosen == s {
+				scopes = append(scopes, s)
+				break
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return "", "Choose at least one scope."
+	}
+
+	var expiresAt sql.NullTime
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || days < 0 {
+		return "", "Choose when the token expires."
+	}
+	if days > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, days), Valid: true}
+	}
+
+	random, _, err := newToken()
+	if err != nil {
+		log.Println("Error generating access token:", err)
+		return "", "Could not create the token."
+	}
+	token := accessTokenPrefix + random
+
+	_, err = database.DB.Exec(`
+		INSERT INTO access_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, user.ID, name, hashToken(token), strings.Join(scopes, ","), expiresAt)
+	if err != nil {
+		log.Println("Error storing access token:", err)
+		return "", "Could not create the token."
+	}
+
+	recordAccountChange(r, user.ID, "access token created", "", name)
+	return token, ""
+}
+
+func revokeAccessToken(r *http.Request, user *SessionUser) string {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		return "Token not found."
+	}
+
+	var name string
+	err = database.DB.QueryRow(
+		"SELECT name FROM access_tokens WHERE id = ? AND user_id = ?", id, user.ID,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "Token not found."
+	}
+	if err == nil {
+		_, err = database.DB.Exec("DELETE FROM access_tokens WHERE id = ? AND user_id = ?", id, user.ID)
+	}
+	if err != nil {
+		log.Println("Error revoking access token:", err)
+		return "Could not revoke the token."
+	}
+
+	recordAccountChange(r, user.ID, "access token revoked", name, "")
+	return ""
+}
+
+func loadAccessTokens(user *SessionUser, data *AccessTokensPageData) error {
+	rows, err := database.DB.Query(`
+		SELECT id, name, scopes, strftime('%Y-%m-%d %H:%M:%S', created_at), last_used_at, expires_at
+		FROM access_tokens
+		WHERE user_id = ?
+		ORDER BY id DESC
+	`, user.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var (
+			t                   AccessTokenView
+			lastUsed, expiresAt sql.NullTime
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scopes, &t.CreatedAt, &lastUsed, &expiresAt); err != nil {
+			return err
+		}
+
+		t.Scopes = strings.ReplaceAll(t.Scopes, ",", ", ")
+		t.LastUsed = "never"
+		if lastUsed.Valid {
+			t.LastUsed = lastUsed.Time.Format("2006-01-02 15:04")
+		}
+		t.Expires = "never"
+		if expiresAt.Valid {
+			t.Expires = expiresAt.Time.Format("2006-01-02 15:04")
+			t.Expired = now.After(expiresAt.Time)
+		}
+		data.Tokens = append(data.Tokens, t)
+	}
+	return rows.Err()
+}
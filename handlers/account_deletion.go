@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/images"
+	"forum/mailer"
+)
+
+// Deletion modes offered on /settings/delete.
+const (
+	// DeleteHard removes the account together with its posts, comments and likes.
+	DeleteHard = "delete"
+	// DeleteAnonymise removes the account but keeps its posts, comments and
+	// likes, reassigned to the "deleted user" placeholder.
+	DeleteAnonymise = "anonymise"
+)
+
+// deletionGracePeriod is how long a deletion request can still be cancelled.
+// 0 deletes the account immediately.
+func deletionGracePeriod() time.Duration {
+	return config.Duration("FORUM_DELETION_GRACE_PERIOD", 14*24*time.Hour)
+}
+
+// DeletionPageData is passed to settings_delete.html.
+type DeletionPageData struct {
+	User        *SessionUser
+	HasPassword bool
+	Pending     bool
+	Mode        string
+	PurgeAfter  string
+	GraceDays   int
+	Error       string
+	Message     string
+}
+
+// ---------------------------------------------------------------------------
+// DeleteAccountHandler handles GET + POST /settings/delete
+// ---------------------------------------------------------------------------
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := DeletionPageData{User: user}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "request":
+			var deleted bool
+			deleted, data.Message, data.Error = requestDeletion(r, user)
+			if deleted {
+				// No grace period: the account is already gone
+				clearSessionCookie(w)
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		case "cancel":
+			data.Message, data.Error = cancelDeletion(r, user)
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadDeletionState(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "settings_delete.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// requestDeletion schedules the account for deletion after the grace period,
+// or deletes it right away when there is none.
+func requestDeletion(r *http.Request, user *SessionUser) (deleted bool, msg, errMsg string) {
+	mode := r.FormValue("mode")
+	if mode != DeleteHard && mode != DeleteAnonymise {
+		return false, "", "Choose what should happen to your posts and comments."
+	}
+	if r.FormValue("confirm") != user.Username {
+		return false, "", "Type your username to confirm."
+	}
+
+	if msg, err := confirmIdentity(r, user, r.FormValue("password")); err != nil {
+		log.Println("Error confirming password:", err)
+		return false, "", "Could not delete your account."
+	} else if msg != "" {
+		return false, "", msg
+	}
+
+	if last, err := isLastAdmin(user); err != nil {
+		log.Println("Error counting admins:", err)
+		return false, "", "Could not delete your account."
+	} else if last {
+		return false, "", "The forum needs at least one admin. Promote someone else first."
+	}
+
+	grace := deletionGracePeriod()
+	if grace <= 0 {
+		if err := deleteAccount(user.ID, mode); err != nil {
+			log.Println("Error deleting account:", err)
+			return false, "", "Could not delete your account."
+		}
+		return true, "", ""
+	}
+
+	now := time.Now()
+	purgeAfter := now.Add(grace)
+	_, err := database.DB.Exec(`
+		INSERT INTO account_deletions (user_id, mode, requested_at, purge_after)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			mode = excluded.mode,
+			requested_at = excluded.requested_at,
+			purge_after = excluded.purge_after
+	`, user.ID, mode, now, purgeAfter)
+	if err != nil {
+		log.Println("Error scheduling account deletion:", err)
+		return false, "", "Could not delete your account."
+	}
+
+	recordAccountChange(r, user.ID, "deletion requested", "", mode)
+
+	body := "Your forum account is scheduled for deletion on " + purgeAfter.Format("2006-01-02 15:04") + ".\n\n" +
+		"Until then you can cancel it by logging in and opening:\n" +
+		config.BaseURL() + "/settings/delete"
+	if err := mailer.Default().Send(user.Email, "Your forum account will be deleted", body); err != nil {
+		log.Println("Error sending deletion notice:", err)
+	}
+
+	return false, "Your account will be deleted on " + purgeAfter.Format("2006-01-02 15:04") + ".", ""
+}
+
+func cancelDeletion(r *http.Request, user *SessionUser) (string, string) {
+	res, err := database.DB.Exec("DELETE FROM account_deletions WHERE user_id = ?", user.ID)
+	if err != nil {
+		log.Println("Error cancelling account deletion:", err)
+		return "", "Could not cancel the deletion."
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "Your account is not scheduled for deletion."
+	}
+
+	recordAccountChange(r, user.ID, "deletion cancelled", "", "")
+	return "The deletion has been cancelled. Your account stays as it is.", ""
+}
+
+// isLastAdmin reports whether removing user would leave the forum without an admin.
+func isLastAdmin(user *SessionUser) (bool, error) {
+	if user.Role != RoleAdmin {
+		return false, nil
+	}
+	var others int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE role = ? AND id != ?", RoleAdmin, user.ID,
+	).Scan(&others)
+	return others == 0, err
+}
+
+func loadDeletionState(user *SessionUser, data *DeletionPageData) error {
+	var hash string
+	err := database.DB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+	data.HasPassword = hash != ""
+	data.GraceDays = int(deletionGracePeriod() / (24 * time.Hour))
+
+	var purgeAfter time.Time
+	err = database.DB.QueryRow(
+		"SELECT mode, purge_after FROM account_deletions WHERE user_id = ?", user.ID,
+	).Scan(&data.Mode, &purgeAfter)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data.Pending = true
+	data.PurgeAfter = purgeAfter.Format("2006-01-02 15:04")
+	return nil
+}
+
+// deleteAccount removes a user. In anonymise mode their posts, comments and
+// likes are first handed to the placeholder account so threads stay intact;
+// in delete mode they go with the user, together with the replies under
+// their posts.
+func deleteAccount(userID int, mode string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return err
+	}
+	if email == database.DeletedUserEmail {
+		return errors.New("the deleted user placeholder cannot be deleted")
+	}
+
+	if mode == DeleteAnonymise {
+		// Looked up inside tx: the pool has a single connection
+		var placeholder int
+		err := tx.QueryRow(
+			"SELECT id FROM users WHERE email = ?", database.DeletedUserEmail,
+		).Scan(&placeholder)
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"posts", "comments", "likes"} {
+			_, err := tx.Exec("UPDATE "+table+" SET user_id = ? WHERE user_id = ?", placeholder, userID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The votes removed below change other posts' scores
+	var voted []int
+	rows, err := tx.Query(
+		"SELECT DISTINCT post_id FROM likes WHERE user_id = ? AND post_id IS NOT NULL", userID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		voted = append(voted, id)
+	}
+	rows.Close()
+
+	// ...and the files of the images on their posts may no longer be used
+	var hashes []string
+	rows, err = tx.Query(`
+		SELECT DISTINCT hash FROM post_images
+		WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)
+	`, userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+
+	// Databases created before ON DELETE CASCADE was added to the original
+	// tables can't rely on it, so their rows are removed explicitly, children
+	// first. Newer tables (tokens, identities, history...) cascade from users.
+	stmts := []string{
+		`DELETE FROM likes WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)
+			OR comment_id IN (SELECT id FROM comments WHERE user_id = ?1
+				OR post_id IN (SELECT id FROM posts WHERE user_id = ?1))`,
+		`DELETE FROM comments WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		`DELETE FROM post_categories WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		`DELETE FROM post_images WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		`DELETE FROM posts WHERE user_id = ?1`,
+		`DELETE FROM sessions WHERE user_id = ?1`,
+		`DELETE FROM users WHERE id = ?1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+
+	// Lockout state is keyed by email, not by user id
+	if _, err := tx.Exec("DELETE FROM auth_throttle WHERE key = ?", loginEmailKey(email)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := database.RefreshPostScores(voted...); err != nil {
+		log.Println("Error rescoring posts after account deletion:", err)
+	}
+	images.RemoveUnused(hashes...)
+	return nil
+}
+
+// PurgeDueDeletions deletes every account whose grace period is over.
+func PurgeDueDeletions() {
+	rows, err := database.DB.Query(
+		"SELECT user_id, mode FROM account_deletions WHERE purge_after <= ?", time.Now(),
+	)
+	if err != nil {
+		log.Println("Error loading due account deletions:", err)
+		return
+	}
+
+	type due struct {
+		userID int
+		mode   string
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userID, &d.mode); err != nil {
+			log.Println("Error scanning account deletion:", err)
+			continue
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+
+	for _, d := range list {
+		if err := deleteAccount(d.userID, d.mode); err != nil {
+			log.Printf("Error deleting account %d: %v", d.userID, err)
+			continue
+		}
+		log.Printf("🗑️ Deleted account %d (%s)", d.userID, d.mode)
+	}
+}
+
+// StartDeletionSweeper runs PurgeDueDeletions now and then periodically,
+// also removing image files left without a post (see images.RemoveOrphans).
+func StartDeletionSweeper() {
+	interval := config.Duration("FORUM_DELETION_SWEEP_INTERVAL", time.Hour)
+
+	go func() {
+		for {
+			PurgeDueDeletions()
+			images.RemoveOrphans()
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/database"
+)
+
+type LockoutView struct {
+	Key         string
+	Attempts    int
+	LastAttempt string
+	LockedUntil string
+}
+
+// AdminLockoutsHandler handles GET + POST for /admin/lockouts:
+// lists locked accounts / IPs and lets an admin clear them.
+// Requires PermManageLockouts (see main.go).
+func AdminLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := GetUserFromRequest(r)
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		key := r.FormValue("key")
+		if key == "" {
+			http.Error(w, "Missing key", http.StatusBadRequest)
+			return
+		}
+		if err := clearThrottle(key); err != nil {
+			log.Println("Error clearing lockout:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/lockouts", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT key, attempts, last_attempt_at, locked_until
+		FROM auth_throttle
+		WHERE locked_until > ?
+		ORDER BY locked_until DESC
+	`, time.Now())
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	defer rows.Close()
+
+	var lockouts []LockoutView
+	for rows.Next() {
+		var (
+			lv          LockoutView
+			last, until time.Time
+		)
+		if err := rows.Scan(&lv.Key, &lv.Attempts, &last, &until); err != nil {
+			log.Println("Error scanning lockout:", err)
+			continue
+		}
+		lv.LastAttempt = last.Format("2006-01-02 15:04:05")
+		lv.LockedUntil = until.Format("2006-01-02 15:04:05")
+		lockouts = append(lockouts, lv)
+	}
+
+	data := struct {
+		User     *SessionUser
+		Lockouts []LockoutView
+	}{
+		User:     user,
+		Lockouts: lockouts,
+	}
+
+	if err := Render(w, r, "admin_lockouts.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// ------------------------------------------------------------
+// USERS & ROLES
+// ------------------------------------------------------------
+
+type UserView struct {
+	ID        int
+	Username  string
+	Email     string
+	Role      string
+	CreatedAt string
+	InvitedBy string
+}
+
+// AdminUsersHandler handles GET + POST for /admin/users:
+// lists accounts and lets an admin change their role.
+// Requires PermManageUsers (see main.go).
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := GetUserFromRequest(r)
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		role := r.FormValue("role")
+		if err != nil || !ValidRole(role) {
+			http.Error(w, "Invalid user or role", http.StatusBadRequest)
+			return
+		}
+		if msg := setRole(userID, role); msg != "" {
+			http.Error(w, msg, http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT users.id, users.username, users.email, users.role,
+		       strftime('%Y-%m-%d %H:%M:%S', users.created_at),
+		       COALESCE(inviter.username, '')
+		FROM users
+		LEFT JOIN invite_uses ON invite_uses.user_id = users.id
+		LEFT JOIN invites ON invites.id = invite_uses.invite_id
+		LEFT JOIN users AS inviter ON inviter.id = invites.created_by
+		WHERE users.email != ?
+		ORDER BY users.id ASC
+	`, database.DeletedUserEmail)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	defer rows.Close()
+
+	var users []UserView
+	for rows.Next() {
+		var uv UserView
+		if err := rows.Scan(&uv.ID, &uv.Username, &uv.Email, &uv.Role, &uv.CreatedAt, &uv.InvitedBy); err != nil {
+			log.Println("Error scanning user:", err)
+			continue
+		}
+		users = append(users, uv)
+	}
+
+	data := struct {
+		User  *SessionUser
+		Users []UserView
+		Roles []string
+	}{
+		User:  user,
+		Users: users,
+		Roles: Roles,
+	}
+
+	if err := Render(w, r, "admin_users.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// setRole changes a user's role, keeping at least one admin.
+func setRole(userID int, role string) string {
+	if role != RoleAdmin {
+		var others int
+		err := database.DB.QueryRow(
+			"SELECT COUNT(*) FROM users WHERE role = ? AND id != ?", RoleAdmin, userID,
+		).Scan(&others)
+		if err != nil {
+			log.Println("Error counting admins:", err)
+			return "Server error"
+		}
+		if others == 0 {
+			return "The forum needs at least one admin."
+		}
+	}
+
+	if _, err := database.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID); err != nil {
+		log.Println("Error changing role:", err)
+		return "Server error"
+	}
+	return ""
+}
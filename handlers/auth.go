@@ -2,22 +2,19 @@ package auth
 
 import (
 	"database/sql"
-	"html/template"
 	"log"
 	"net/http"
+	"strings"
 
 	"forum/database"
-
-	"golang.org/x/crypto/bcrypt"
+	pw "forum/password"
 )
 
-var tmpl = template.Must(template.ParseGlob("templates/*.html"))
-
 // RegisterHandler handles GET + POST for user registration
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		tmpl.ExecuteTemplate(w, "register.html", nil)
+		renderRegister(w, r, "")
 	case "POST":
 		handleRegisterPost(w, r)
 	default:
@@ -33,13 +30,46 @@ func handleRegisterPost(w http.ResponseWriter, r *http.Request) {
 
 	// BASIC VALIDATION
 	if email == "" || username == "" || password == "" {
-		tmpl.ExecuteTemplate(w, "register.html", "All fields are required.")
+		renderRegister(w, r, "All fields are required.")
+		return
+	}
+
+	// REGISTRATION POLICY (open / invite-only / domain allowlist / closed)
+	inviteID, policyErr, err := checkRegistrationPolicy(email, strings.TrimSpace(r.FormValue("invite")))
+	if err != nil {
+		log.Println("Error checking invite:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if policyErr != "" {
+		w.WriteHeader(http.StatusForbidden)
+		renderRegister(w, r, policyErr)
+		return
+	}
+
+	// PASSWORD POLICY
+	if err := pw.Validate(password); err != nil {
+		renderRegister(w, r, err.Error())
+		return
+	}
+
+	// THROTTLE: limit account creation per IP
+	ipKey := registerIPKey(ClientIP(r))
+	wait, err := lockedFor(ipKey)
+	if err != nil {
+		log.Println("Error checking registration lockout:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderRegister(w, r, lockoutMessage(wait))
 		return
 	}
 
 	// CHECK EMAIL EXISTS
 	var exists int
-	err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&exists)
+	err = database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&exists)
 	if err != nil && err != sql.ErrNoRows {
 		log.Println("Error checking email:", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
@@ -47,7 +77,7 @@ func handleRegisterPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if exists > 0 {
-		tmpl.ExecuteTemplate(w, "register.html", "Email is already registered.")
+		renderRegister(w, r, "Email is already registered.")
 		return
 	}
 
@@ -60,30 +90,73 @@ func handleRegisterPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if exists > 0 {
-		tmpl.ExecuteTemplate(w, "register.html", "Username is already taken.")
+		renderRegister(w, r, "Username is already taken.")
 		return
 	}
 
 	// HASH PASSWORD
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := pw.Hash(password)
 	if err != nil {
 		log.Println("Error hashing password:", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
-	// INSERT USER
-	_, err = database.DB.Exec(
-		"INSERT INTO users (email, username, password) VALUES (?, ?, ?)",
-		email, username, hashed,
-	)
-
+	// INSERT USER (and use up the invite in the same transaction)
+	userID, inviteOK, err := insertUser(email, username, hashed, inviteID)
 	if err != nil {
 		log.Println("Insert user error:", err)
 		http.Error(w, "Could not create user", http.StatusInternalServerError)
 		return
 	}
+	if !inviteOK {
+		w.WriteHeader(http.StatusForbidden)
+		renderRegister(w, r, "This invite code is invalid, used up or expired.")
+		return
+	}
+
+	// Every created account counts towards the per-IP limit
+	if err := recordAttempt(ipKey, registerIPRule()); err != nil {
+		log.Println("Error recording registration:", err)
+	}
+
+	// SEND VERIFICATION LINK (account can log in, but not post until verified)
+	if err := SendVerificationEmail(userID, email); err != nil {
+		log.Println("Error sending verification email:", err)
+	}
 
 	// REDIRECT TO LOGIN
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
+
+// insertUser creates the account. With an invite, the invite is consumed in
+// the same transaction; inviteOK is false (and nothing is stored) when it
+// was used up in the meantime.
+func insertUser(email, username, hashed string, inviteID int) (userID int, inviteOK bool, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO users (email, username, password) VALUES (?, ?, ?)",
+		email, username, hashed,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+
+	if inviteID != 0 {
+		ok, err := consumeInvite(tx, inviteID, int(id))
+		if err != nil || !ok {
+			return 0, false, err
+		}
+	}
+
+	return int(id), true, tx.Commit()
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"forum/config"
+	"forum/database"
+	pw "forum/password"
+)
+
+// Login backends
+//
+// The login form's credentials are checked by the backends listed in
+// FORUM_LOGIN_BACKENDS, in order, until one accepts them:
+//   - local: the password hash stored in users.password (default)
+//   - ldap:  a bind against a directory server (see ldap_login.go)
+// e.g. "ldap,local" lets directory users in while keeping local accounts.
+
+// ErrInvalidLogin means a backend does not accept the login and password.
+var ErrInvalidLogin = errors.New("invalid email or password")
+
+// Authenticator checks the login (usually an email) and password typed
+// on the login form.
+type Authenticator interface {
+	Name() string
+
+	// Authenticate returns the local user ID, ErrInvalidLogin when the
+	// credentials don't match, or another error when they could not be
+	// checked (e.g. the directory is down).
+	Authenticate(login, password string) (int, error)
+}
+
+var (
+	backends     []Authenticator
+	backendsOnce sync.Once
+)
+
+// LoginBackends returns the configured backends.
+func LoginBackends() []Authenticator {
+	backendsOnce.Do(func() {
+		backends = loadLoginBackends()
+	})
+	return backends
+}
+
+// SetLoginBackends replaces the configured backends.
+func SetLoginBackends(bs []Authenticator) {
+	backendsOnce.Do(func() {})
+	backends = bs
+}
+
+func loadLoginBackends() []Authenticator {
+	var out []Authenticator
+	for _, name := range config.List("FORUM_LOGIN_BACKENDS") {
+		switch strings.ToLower(name) {
+		case "local":
+			out = append(out, LocalAuthenticator{})
+		case "ldap":
+			out = append(out, ldapFromConfig())
+		default:
+			log.Printf("Unknown login backend %q ignored", name)
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, LocalAuthenticator{})
+	}
+	return out
+}
+
+// usesBackend reports whether a backend with this name is configured.
+func usesBackend(name string) bool {
+	for _, b := range LoginBackends() {
+		if b.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate asks every backend in turn. When none accepts the
+// credentials and one of them failed, that failure is returned rather
+// than ErrInvalidLogin, so an outage isn't counted as a wrong password.
+func authenticate(login, password string) (int, error) {
+	result := ErrInvalidLogin
+	for _, b := range LoginBackends() {
+		userID, err := b.Authenticate(login, password)
+		if err == nil {
+			return userID, nil
+		}
+		if err != ErrInvalidLogin {
+			log.Printf("Login backend %s failed: %v", b.Name(), err)
+			result = err
+		}
+	}
+	return 0, result
+}
+
+// ------------------------------------------------------------
+// LOCAL PASSWORDS
+// ------------------------------------------------------------
+
+// LocalAuthenticator checks the password hash stored with the account.
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Name() string { return "local" }
+
+func (LocalAuthenticator) Authenticate(email, password string) (int, error) {
+	var (
+		userID int
+		hash   string
+	)
+	err := database.DB.QueryRow(
+		"SELECT id, password FROM users WHERE email = ?", email,
+	).Scan(&userID, &hash)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidLogin
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Compare hashed password with user input
+	ok, needsRehash, err := pw.Verify(password, hash)
+	if err != nil {
+		log.Println("Error verifying password:", err)
+	}
+	if !ok {
+		return 0, ErrInvalidLogin
+	}
+
+	// Upgrade old hashes (e.g. bcrypt → argon2id) now that we know the password
+	if needsRehash {
+		rehashPassword(userID, password)
+	}
+	return userID, nil
+}
@@ -1,7 +1,6 @@
 package categories
 
 import (
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,14 +9,15 @@ import (
 	auth "forum/handlers"
 	posts "forum/handlers/posts"
 	"forum/models"
+	"forum/pagination"
 )
 
-var categoryTmpl = template.Must(template.ParseGlob("templates/*.html"))
-
 type CategoryPageData struct {
 	User     *auth.SessionUser
 	Category models.Category
 	Posts    []models.Post
+	Page     pagination.Page
+	Sort     posts.Sort
 }
 
 func ViewCategoryHandler(w http.ResponseWriter, r *http.Request) {
@@ -54,96 +54,41 @@ func ViewCategoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ---------------------------------------------------------
-	// 3. Load all posts inside this category
+	// 3. Load one page of the posts inside this category
 	// ---------------------------------------------------------
-	rows, err := database.DB.Query(`
-		SELECT posts.id,
-		       posts.user_id,
-		       users.username,
-		       posts.title,
-		       posts.content,
-		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at)
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		JOIN post_categories ON post_categories.post_id = posts.id
-		WHERE post_categories.category_id = ?
-		ORDER BY posts.created_at DESC
-	`, catID)
-
+	sort, err := posts.SortFromRequest(r)
 	if err != nil {
-		http.Error(w, "Error loading posts", http.StatusInternalServerError)
+		http.Error(w, "Invalid sort order", http.StatusBadRequest)
 		return
 	}
-
-	var rawPosts []struct {
-		ID        int
-		UserID    int
-		Username  string
-		Title     string
-		Content   string
-		CreatedAt string
-	}
-
-	for rows.Next() {
-		var rp struct {
-			ID        int
-			UserID    int
-			Username  string
-			Title     string
-			Content   string
-			CreatedAt string
-		}
-
-		if err := rows.Scan(&rp.ID, &rp.UserID, &rp.Username, &rp.Title, &rp.Content, &rp.CreatedAt); err != nil {
-			log.Println("SCAN ERROR:", err)
-			continue
-		}
-
-		rawPosts = append(rawPosts, rp)
+	req, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid page cursor", http.StatusBadRequest)
+		return
 	}
-
-	rows.Close() // IMPORTANT for SQLite
-
-	// ---------------------------------------------------------
-	// 4. Convert raw posts → []models.Post (with categories)
-	// ---------------------------------------------------------
-	var postsList []models.Post
-
-	for _, rp := range rawPosts {
-		p := models.Post{
-			ID:        rp.ID,
-			UserID:    rp.UserID,
-			Username:  rp.Username,
-			Title:     rp.Title,
-			Content:   rp.Content,
-			CreatedAt: rp.CreatedAt,
-		}
-
-		// Load categories for each post (convert to models.Category)
-		cats, _ := posts.GetCategoriesForPost(p.ID)
-
-		var convertedCats []models.Category
-		for _, c := range cats {
-			convertedCats = append(convertedCats, models.Category{
-				ID:   c.ID,
-				Name: c.Name,
-			})
-		}
-		p.Categories = convertedCats
-
-		postsList = append(postsList, p)
+	feed, err := posts.LoadFeed(posts.FeedQuery{
+		Join:  "JOIN post_categories ON post_categories.post_id = posts.id",
+		Where: "post_categories.category_id = ?",
+		Args:  []any{catID},
+	}, sort, req)
+	if err != nil {
+		log.Println("Error loading posts:", err)
+		http.Error(w, "Error loading posts", http.StatusInternalServerError)
+		return
 	}
 
 	// ---------------------------------------------------------
-	// 5. Render template
+	// 4. Render template
 	// ---------------------------------------------------------
 	data := CategoryPageData{
 		User:     user,
 		Category: cat,
-		Posts:    postsList,
+		Posts:    feed.Posts,
+		Page:     feed.Page,
+		Sort:     feed.Sort,
 	}
 
-	if err := categoryTmpl.ExecuteTemplate(w, "category.html", data); err != nil {
+	if err := auth.Render(w, r, "category.html", data); err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
 	}
@@ -1,12 +1,15 @@
 package comments
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"strconv"
 
+	"forum/config"
 	"forum/database"
 	auth "forum/handlers"
+	"forum/markdown"
 )
 
 // CreateCommentHandler handles POST /create-comment
@@ -29,6 +32,9 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
 
 	// Read form values
 	postIDStr := r.FormValue("post_id")
@@ -47,17 +53,62 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The post must exist and not be deleted
+	var deleted bool
+	err = database.DB.QueryRow(
+		"SELECT deleted_at IS NOT NULL FROM posts WHERE id = ?", postID,
+	).Scan(&deleted)
+	if err == sql.ErrNoRows || deleted {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// Replies name the comment they answer, which must be under the same post
+	var parentID sql.NullInt64
+	if v := r.FormValue("parent_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+		var parentDeleted bool
+		err = database.DB.QueryRow(
+			"SELECT deleted_at IS NOT NULL FROM comments WHERE id = ? AND post_id = ?", id, postID,
+		).Scan(&parentDeleted)
+		if err == sql.ErrNoRows || parentDeleted {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+		parentID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
 	// Insert comment into DB
-	_, err = database.DB.Exec(`
-		INSERT INTO comments (post_id, user_id, content)
-		VALUES (?, ?, ?)
-	`, postID, user.ID, content)
+	result, err := database.DB.Exec(`
+		INSERT INTO comments (post_id, user_id, content, content_html, content_html_version, parent_comment_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, postID, user.ID, content, markdown.Render(content), markdown.Version, parentID)
 	if err != nil {
 		// Internal DB error → log + panic → main.go wrapper → 500.html
 		fmt.Println("Error inserting comment:", err)
 		panic(err)
 	}
+	commentID, _ := result.LastInsertId()
 
-	// Redirect back to the post page
-	http.Redirect(w, r, "/post?id="+postIDStr, http.StatusSeeOther)
+	// Redirect back to the new comment
+	http.Redirect(w, r, fmt.Sprintf("/post?id=%d#comment-%d", postID, commentID), http.StatusSeeOther)
+}
+
+// MaxDepth is how many levels of a comment tree the post page shows;
+// deeper replies are reached through "continue this thread" links.
+func MaxDepth() int {
+	if n := config.Int("FORUM_COMMENT_MAX_DEPTH", 5); n > 0 {
+		return n
+	}
+	return 1
 }
@@ -0,0 +1,245 @@
+package comments
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	auth "forum/handlers"
+	"forum/markdown"
+)
+
+// Comments can be edited by their author for FORUM_COMMENT_EDIT_WINDOW
+// after posting, and by moderators at any time. Every edit is kept in
+// comment_revisions (the first edit also stores the original). Deleted
+// comments stay in the thread as "[deleted]" placeholders and keep their
+// likes, which still count towards their author's reputation.
+
+// EditWindow is how long authors can edit their comments (0 = no limit).
+func EditWindow() time.Duration {
+	return config.Duration("FORUM_COMMENT_EDIT_WINDOW", 15*time.Minute)
+}
+
+// CanEdit reports whether user may edit a comment by authorID written
+// age ago.
+func CanEdit(user *auth.SessionUser, authorID int, age time.Duration) bool {
+	if user == nil {
+		return false
+	}
+	if user.Can(auth.PermModerateComments) {
+		return true
+	}
+	window := EditWindow()
+	return user.ID == authorID && (window <= 0 || age <= window)
+}
+
+// CanDelete reports whether user may delete a comment by authorID.
+func CanDelete(user *auth.SessionUser, authorID int) bool {
+	return user != nil && (user.ID == authorID || user.Can(auth.PermModerateComments))
+}
+
+type EditCommentPageData struct {
+	User      *auth.SessionUser
+	CommentID int
+	PostID    int
+	Content   string
+	Error     string
+}
+
+// commentRecord is what edits and deletes need to know about a comment.
+type commentRecord struct {
+	ID          int
+	PostID      int
+	UserID      int
+	Content     string
+	Age         time.Duration
+	Deleted     bool
+	PostDeleted bool
+}
+
+// loadComment returns the comment named by the "id" parameter, writing
+// the error response if it is missing or already deleted.
+func loadComment(w http.ResponseWriter, r *http.Request) *commentRecord {
+	commentID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return nil
+	}
+
+	c := commentRecord{ID: commentID}
+	var ageSeconds int64
+	err = database.DB.QueryRow(`
+		SELECT comments.post_id,
+		       comments.user_id,
+		       comments.content,
+		       CAST(strftime('%s', 'now') - strftime('%s', comments.created_at) AS INTEGER),
+		       comments.deleted_at IS NOT NULL,
+		       posts.deleted_at IS NOT NULL
+		FROM comments
+		JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = ?
+	`, commentID).Scan(&c.PostID, &c.UserID, &c.Content, &ageSeconds, &c.Deleted, &c.PostDeleted)
+	if err == sql.ErrNoRows || c.Deleted {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	c.Age = time.Duration(ageSeconds) * time.Second
+
+	return &c
+}
+
+// -----------------------------------------------------------
+// EditCommentHandler — GET shows the form, POST saves a revision
+// -----------------------------------------------------------
+func EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
+
+	c := loadComment(w, r)
+	if c == nil {
+		return
+	}
+	if c.PostDeleted {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if !CanEdit(user, c.UserID, c.Age) {
+		if user.ID == c.UserID {
+			http.Error(w, "This comment can no longer be edited", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := EditCommentPageData{User: user, CommentID: c.ID, PostID: c.PostID, Content: c.Content}
+	postURL := "/post?id=" + strconv.Itoa(c.PostID)
+
+	switch r.Method {
+	case "GET":
+		auth.Render(w, r, "edit_comment.html", data)
+
+	case "POST":
+		data.Content = r.FormValue("content")
+		if data.Content == "" {
+			data.Error = "The comment can't be empty."
+			auth.Render(w, r, "edit_comment.html", data)
+			return
+		}
+
+		// Saving without changes doesn't create a revision
+		if data.Content != c.Content {
+			if err := saveCommentRevision(c.ID, user.ID, data.Content); err != nil {
+				log.Println("Error saving comment revision:", err)
+				http.Error(w, "Error saving comment", http.StatusInternalServerError)
+				return
+			}
+			if user.ID != c.UserID {
+				log.Printf("Moderator %d edited comment %d", user.ID, c.ID)
+			}
+		}
+
+		http.Redirect(w, r, postURL, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveCommentRevision records a new version of a comment and makes it
+// current.
+func saveCommentRevision(commentID, editorID int, content string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The first edit also stores the original
+	_, err = tx.Exec(`
+		INSERT INTO comment_revisions (comment_id, editor_id, content, created_at)
+		SELECT id, user_id, content, created_at FROM comments
+		WHERE id = ?1 AND NOT EXISTS (SELECT 1 FROM comment_revisions WHERE comment_id = ?1)
+	`, commentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO comment_revisions (comment_id, editor_id, content)
+		VALUES (?, ?, ?)
+	`, commentID, editorID, content)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE comments
+		SET content = ?, content_html = ?, content_html_version = ?,
+		    edited_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, content, markdown.Render(content), markdown.Version, commentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// -----------------------------------------------------------
+// DeleteCommentHandler — POST replaces the comment with a
+// "[deleted]" placeholder
+// -----------------------------------------------------------
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := auth.GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
+
+	c := loadComment(w, r)
+	if c == nil {
+		return
+	}
+	if !CanDelete(user, c.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Content and revisions stay in the database, and likes are kept
+	_, err := database.DB.Exec(`
+		UPDATE comments SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, user.ID, c.ID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	if user.ID != c.UserID {
+		log.Printf("Moderator %d deleted comment %d", user.ID, c.ID)
+	}
+
+	http.Redirect(w, r, "/post?id="+strconv.Itoa(c.PostID), http.StatusSeeOther)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"forum/database"
+)
+
+// CSRF protection
+//
+// Logged-in requests use the token stored with their session row, so a
+// token is only valid together with the session it was issued for.
+// Anonymous requests (login, register, password reset...) use a random
+// token kept in the "csrf_token" cookie (double-submit).
+// Every request that is not GET/HEAD/OPTIONS must send the token back,
+// either as the "csrf_token" form field or the X-CSRF-Token header.
+// Scripts using an access token (and no cookie) are exempt.
+//
+// Multipart bodies (image uploads) are not parsed here: their size limit
+// belongs to the handler accepting them. Their token must be the first
+// field of the form, read with a small bound (see multipartToken).
+
+type csrfContextKey struct{}
+
+const csrfCookie = "csrf_token"
+
+// csrfPeekBytes bounds how much of a multipart body is read to find the
+// token.
+const csrfPeekBytes = 16 << 10
+
+// CSRFToken returns the token forms rendered for r must include.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// CSRFProtect rejects state-changing requests without a valid token
+// and makes the request's token available to Render.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := csrfTokenFor(w, r)
+		if err != nil {
+			log.Println("Error loading CSRF token:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+
+		switch {
+		case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		case currentSessionID(r) == "" && bearerToken(r) != "":
+			// Access-token requests: browsers never add the Authorization
+			// header on their own, so there is no ambient credential to forge.
+		default:
+			sent := r.Header.Get("X-CSRF-Token")
+			if sent == "" && isMultipart(r) {
+				sent = multipartToken(r)
+			} else if sent == "" {
+				sent = r.PostFormValue("csrf_token")
+			}
+			if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "Invalid or missing CSRF token. Please reload the page and try again.",
+					http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// multipartToken reads the token from the first field of a multipart
+// body ({{csrfField}} comes first in upload forms), looking at no more
+// than csrfPeekBytes. The bytes read are put back in front of the body,
+// so the handler still sees the whole form.
+func multipartToken(r *http.Request) string {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body := r.Body
+	var peeked bytes.Buffer
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&peeked, body), body}
+	}()
+
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, csrfPeekBytes), &peeked), params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != csrfCookie {
+		return ""
+	}
+	token, err := io.ReadAll(io.LimitReader(part, 256))
+	if err != nil {
+		return ""
+	}
+	return string(token)
+}
+
+// csrfTokenFor returns the session's token, or the anonymous cookie
+// token (setting a new cookie if the browser has none yet).
+func csrfTokenFor(w http.ResponseWriter, r *http.Request) (string, error) {
+	if sessionID := currentSessionID(r); sessionID != "" {
+		var token string
+		err := database.DB.QueryRow(
+			"SELECT csrf_token FROM sessions WHERE id = ? AND expires_at > ?", sessionID, time.Now(),
+		).Scan(&token)
+		if err == nil && token != "" {
+			return token, nil
+		}
+	}
+
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+
+	token, _, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
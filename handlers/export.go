@@ -0,0 +1,389 @@
+package auth
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/database"
+)
+
+// AccountExport is everything the forum stores about one user, as served by
+// /settings/export. Secrets (password hash, TOTP secret, session ids, token
+// hashes) are left out.
+type AccountExport struct {
+	ExportedAt time.Time           `json:"exported_at"`
+	Account    ExportAccount       `json:"account"`
+	Posts      []ExportPost        `json:"posts"`
+	Comments   []ExportComment     `json:"comments"`
+	Likes      []ExportLike        `json:"likes"`
+	Sessions   []ExportSession     `json:"sessions"`
+	Identities []ExportIdentity    `json:"identities"`
+	Tokens     []ExportAccessToken `json:"access_tokens"`
+	Passkeys   []ExportPasskey     `json:"passkeys"`
+	History    []ExportHistoryItem `json:"history"`
+}
+
+type ExportAccount struct {
+	ID               int        `json:"id"`
+	Email            string     `json:"email"`
+	Username         string     `json:"username"`
+	EmailVerified    bool       `json:"email_verified"`
+	Role             string     `json:"role"`
+	HasPassword      bool       `json:"has_password"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        *time.Time `json:"created_at"`
+}
+
+type ExportPost struct {
+	ID         int        `json:"id"`
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Categories []string   `json:"categories"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+type ExportComment struct {
+	ID        int        `json:"id"`
+	PostID    int        `json:"post_id"`
+	Content   string     `json:"content"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type ExportLike struct {
+	PostID    *int `json:"post_id,omitempty"`
+	CommentID *int `json:"comment_id,omitempty"`
+	Value     int  `json:"value"`
+}
+
+type ExportSession struct {
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  *time.Time `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type ExportIdentity struct {
+	Provider  string     `json:"provider"`
+	Subject   string     `json:"subject"`
+	Email     string     `json:"email"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type ExportAccessToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  *time.Time `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type ExportPasskey struct {
+	Name       string     `json:"name"`
+	CreatedAt  *time.Time `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type ExportHistoryItem struct {
+	Action    string     `json:"action"`
+	OldValue  string     `json:"old_value"`
+	NewValue  string     `json:"new_value"`
+	IPAddress string     `json:"ip_address"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// ---------------------------------------------------------------------------
+// ExportHandler handles GET /settings/export?format=json|zip
+// ---------------------------------------------------------------------------
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data, err := CollectAccountData(user.ID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	name := "forum-data-" + user.Username + "-" + data.ExportedAt.Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+safeFilename(name)+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data); err != nil {
+			log.Println("Error writing export:", err)
+		}
+
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+safeFilename(name)+`.zip"`)
+		if err := writeExportZip(w, data); err != nil {
+			log.Println("Error writing export:", err)
+		}
+
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+// writeExportZip writes one JSON file per section of the export.
+func writeExportZip(w http.ResponseWriter, data *AccountExport) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"account.json", data.Account},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"likes.json", data.Likes},
+		{"sessions.json", data.Sessions},
+		{"identities.json", data.Identities},
+		{"access_tokens.json", data.Tokens},
+		{"passkeys.json", data.Passkeys},
+		{"history.json", data.History},
+	}
+
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: data.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// safeFilename keeps a download name to characters every browser accepts.
+func safeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// CollectAccountData loads the export for one user.
+func CollectAccountData(userID int) (*AccountExport, error) {
+	data := &AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Posts:      []ExportPost{},
+		Comments:   []ExportComment{},
+		Likes:      []ExportLike{},
+		Sessions:   []ExportSession{},
+		Identities: []ExportIdentity{},
+		Tokens:     []ExportAccessToken{},
+		Passkeys:   []ExportPasskey{},
+		History:    []ExportHistoryItem{},
+	}
+
+	// ACCOUNT
+	var hash string
+	a := &data.Account
+	err := database.DB.QueryRow(`
+		SELECT id, email, username, email_verified, role, password, created_at
+		FROM users WHERE id = ?
+	`, userID).Scan(&a.ID, &a.Email, &a.Username, &a.EmailVerified, &a.Role, &hash, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.HasPassword = hash != ""
+	if a.TwoFactorEnabled, err = TwoFactorEnabled(userID); err != nil {
+		return nil, err
+	}
+
+	// POSTS (with their category names)
+	err = queryEach(`
+		SELECT posts.id, posts.title, posts.content,
+		       COALESCE(GROUP_CONCAT(categories.name, ','), ''), posts.created_at
+		FROM posts
+		LEFT JOIN post_categories ON post_categories.post_id = posts.id
+		LEFT JOIN categories ON categories.id = post_categories.category_id
+		WHERE posts.user_id = ?
+		GROUP BY posts.id
+		ORDER BY posts.id
+	`, userID, func(rows *sql.Rows) error {
+		var (
+			p    ExportPost
+			cats string
+		)
+		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &cats, &p.CreatedAt); err != nil {
+			return err
+		}
+		p.Categories = []string{}
+		if cats != "" {
+			p.Categories = strings.Split(cats, ",")
+		}
+		data.Posts = append(data.Posts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// COMMENTS
+	err = queryEach(`
+		SELECT id, post_id, content, created_at
+		FROM comments WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var c ExportComment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.Content, &c.CreatedAt); err != nil {
+			return err
+		}
+		data.Comments = append(data.Comments, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// LIKES (databases created before likes were timestamped have no created_at)
+	err = queryEach(`
+		SELECT post_id, comment_id, value
+		FROM likes WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var l ExportLike
+		if err := rows.Scan(&l.PostID, &l.CommentID, &l.Value); err != nil {
+			return err
+		}
+		data.Likes = append(data.Likes, l)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// SESSIONS
+	err = queryEach(`
+		SELECT user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ? ORDER BY created_at
+	`, userID, func(rows *sql.Rows) error {
+		var s ExportSession
+		if err := rows.Scan(&s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return err
+		}
+		data.Sessions = append(data.Sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// LINKED IDENTITIES
+	err = queryEach(`
+		SELECT provider, subject, email, created_at
+		FROM user_identities WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var i ExportIdentity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return err
+		}
+		data.Identities = append(data.Identities, i)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ACCESS TOKENS (never the token itself)
+	err = queryEach(`
+		SELECT name, scopes, created_at, last_used_at, expires_at
+		FROM access_tokens WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var (
+			t      ExportAccessToken
+			scopes string
+		)
+		if err := rows.Scan(&t.Name, &scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+			return err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		data.Tokens = append(data.Tokens, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// PASSKEYS (public keys only identify the authenticator, so they stay out)
+	err = queryEach(`
+		SELECT name, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var p ExportPasskey
+		if err := rows.Scan(&p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return err
+		}
+		data.Passkeys = append(data.Passkeys, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ACCOUNT HISTORY
+	err = queryEach(`
+		SELECT action, old_value, new_value, ip_address, created_at
+		FROM account_history WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var h ExportHistoryItem
+		if err := rows.Scan(&h.Action, &h.OldValue, &h.NewValue, &h.IPAddress, &h.CreatedAt); err != nil {
+			return err
+		}
+		data.History = append(data.History, h)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// queryEach runs query with one argument and calls fn for every row.
+func queryEach(query string, arg interface{}, fn func(*sql.Rows) error) error {
+	rows, err := database.DB.Query(query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/ldap"
+	"forum/oauth"
+)
+
+// LDAP login backend
+//
+// The directory checks the password (by binding as the user's entry); the
+// forum keeps a users row for everything else. That row is created on the
+// first login and linked through user_identities (provider "ldap",
+// subject = the entry's DN). An existing account with the directory's
+// email address is adopted, since the directory vouches for the address.
+//
+// When group mappings are configured the user's role follows the
+// directory on every login; otherwise roles are managed in the forum.
+
+const ldapProvider = "ldap"
+
+// LDAPAuthenticator authenticates against Directory and maps its groups
+// (DNs) to forum roles. With MemberGroups set, users outside every listed
+// group can't log in.
+type LDAPAuthenticator struct {
+	Directory       *ldap.Directory
+	AdminGroups     []string
+	ModeratorGroups []string
+	MemberGroups    []string
+}
+
+// ldapFromConfig reads FORUM_LDAP_* settings.
+func ldapFromConfig() *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		Directory: &ldap.Directory{
+			URL:          config.String("FORUM_LDAP_URL", "ldap://localhost:389"),
+			StartTLS:     config.Bool("FORUM_LDAP_STARTTLS", false),
+			BindDN:       config.String("FORUM_LDAP_BIND_DN", ""),
+			BindPassword: config.String("FORUM_LDAP_BIND_PASSWORD", ""),
+			BaseDN:       config.String("FORUM_LDAP_BASE_DN", ""),
+			UserFilter:   config.String("FORUM_LDAP_USER_FILTER", "(&(objectClass=person)(|(mail=%s)(uid=%s)))"),
+			EmailAttr:    config.String("FORUM_LDAP_EMAIL_ATTR", "mail"),
+			UsernameAttr: config.String("FORUM_LDAP_USERNAME_ATTR", "uid"),
+			GroupAttr:    config.String("FORUM_LDAP_GROUP_ATTR", "memberOf"),
+			GroupBaseDN:  config.String("FORUM_LDAP_GROUP_BASE_DN", ""),
+			GroupFilter:  config.String("FORUM_LDAP_GROUP_FILTER", ""),
+			Timeout:      config.Duration("FORUM_LDAP_TIMEOUT", 5*time.Second),
+		},
+		AdminGroups:     groupList("FORUM_LDAP_ADMIN_GROUPS"),
+		ModeratorGroups: groupList("FORUM_LDAP_MODERATOR_GROUPS"),
+		MemberGroups:    groupList("FORUM_LDAP_MEMBER_GROUPS"),
+	}
+}
+
+// groupList splits a ";"-separated list of DNs (DNs contain commas).
+func groupList(key string) []string {
+	var out []string
+	for _, dn := range strings.Split(config.String(key, ""), ";") {
+		if dn = strings.TrimSpace(dn); dn != "" {
+			out = append(out, dn)
+		}
+	}
+	return out
+}
+
+func (a *LDAPAuthenticator) Name() string { return ldapProvider }
+
+func (a *LDAPAuthenticator) Authenticate(login, password string) (int, error) {
+	u, err := a.Directory.Authenticate(login, password)
+	if err == ldap.ErrInvalidCredentials {
+		return 0, ErrInvalidLogin
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	role, allowed := a.role(u.Groups)
+	if !allowed {
+		log.Printf("LDAP user %s is not in any group allowed to log in", u.DN)
+		return 0, ErrInvalidLogin
+	}
+	if u.Email == "" {
+		log.Printf("LDAP user %s has no email address", u.DN)
+		return 0, ErrInvalidLogin
+	}
+
+	return provisionLDAPUser(u, role)
+}
+
+// role returns the highest role the groups map to, or "" when no mapping
+// is configured. allowed is false when MemberGroups is set and the user
+// is in none of the mapped groups.
+func (a *LDAPAuthenticator) role(groups []string) (role string, allowed bool) {
+	mapping := []struct {
+		role   string
+		groups []string
+	}{
+		{RoleAdmin, a.AdminGroups},
+		{RoleModerator, a.ModeratorGroups},
+		{RoleMember, a.MemberGroups},
+	}
+
+	configured := false
+	for _, m := range mapping {
+		configured = configured || len(m.groups) > 0
+		for _, want := range m.groups {
+			for _, g := range groups {
+				if ldap.SameDN(g, want) {
+					return m.role, true
+				}
+			}
+		}
+	}
+
+	if !configured {
+		return "", true
+	}
+	return RoleMember, len(a.MemberGroups) == 0
+}
+
+// provisionLDAPUser returns the forum account of a directory user,
+// creating or adopting it on first login, and applies role (if any).
+func provisionLDAPUser(u *ldap.User, role string) (int, error) {
+	subject := strings.ToLower(u.DN)
+
+	var userID int
+	err := database.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", ldapProvider, subject,
+	).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		err = database.DB.QueryRow("SELECT id FROM users WHERE email = ?", u.Email).Scan(&userID)
+		switch {
+		case err == sql.ErrNoRows:
+			// FIRST LOGIN → new account (the directory decides who may sign up)
+			userID, err = createExternalUser(ldapProvider, &oauth.Identity{
+				Subject:       subject,
+				Email:         u.Email,
+				EmailVerified: true,
+				Username:      u.Username,
+			})
+			if err != nil {
+				return 0, err
+			}
+			log.Printf("Created account %d for LDAP user %s", userID, u.DN)
+
+		case err == nil:
+			// Existing local account with the same address
+			_, err = database.DB.Exec(`
+				INSERT INTO user_identities (user_id, provider, subject, email)
+				VALUES (?, ?, ?, ?)
+			`, userID, ldapProvider, subject, u.Email)
+			if err != nil {
+				return 0, err
+			}
+			_, err = database.DB.Exec("UPDATE users SET email_verified = 1 WHERE id = ?", userID)
+			if err != nil {
+				return 0, err
+			}
+			log.Printf("Linked account %d to LDAP user %s", userID, u.DN)
+
+		default:
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	// FORUM_ADMIN_EMAILS still wins over the directory's groups
+	if isBootstrapAdmin(u.Email) {
+		role = RoleAdmin
+	}
+	if role != "" {
+		_, err = database.DB.Exec("UPDATE users SET role = ? WHERE id = ? AND role != ?", role, userID, role)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
@@ -1,6 +1,7 @@
 package likes
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
@@ -21,6 +22,9 @@ func LikeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
 
 	targetType := r.FormValue("type") // "post" or "comment"
 	targetID := r.FormValue("id")
@@ -52,6 +56,16 @@ func handlePostLike(w http.ResponseWriter, r *http.Request, userID int, targetID
 		return
 	}
 
+	// Deleted posts keep their votes but take no new ones
+	var deleted bool
+	err = database.DB.QueryRow(
+		"SELECT deleted_at IS NOT NULL FROM posts WHERE id = ?", postID,
+	).Scan(&deleted)
+	if err != nil || deleted {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
 	var existing int
 	err = database.DB.QueryRow(`
         SELECT value FROM likes
@@ -75,6 +89,11 @@ func handlePostLike(w http.ResponseWriter, r *http.Request, userID int, targetID
         `, userID, postID, val)
 	}
 
+	// Keep the feed rankings in step with the votes
+	if err := database.RefreshPostScores(postID); err != nil {
+		log.Println("Error scoring post:", err)
+	}
+
 	// Always redirect safely to post page (fixes Back button)
 	http.Redirect(w, r, "/post?id="+strconv.Itoa(postID), http.StatusSeeOther)
 }
@@ -89,6 +108,16 @@ func handleCommentLike(w http.ResponseWriter, r *http.Request, userID int, targe
 		return
 	}
 
+	// Deleted comments keep their votes but take no new ones
+	var deleted bool
+	err = database.DB.QueryRow(
+		"SELECT deleted_at IS NOT NULL FROM comments WHERE id = ?", commentID,
+	).Scan(&deleted)
+	if err != nil || deleted {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
 	var existing int
 	err = database.DB.QueryRow(`
         SELECT value FROM likes
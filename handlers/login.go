@@ -1,25 +1,37 @@
 package auth
 
 import (
-	"database/sql"
-	"html/template"
 	"log"
 	"net/http"
-	"time"
 
 	"forum/database"
-
-	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"forum/oauth"
+	pw "forum/password"
 )
 
-var loginTmpl = template.Must(template.ParseGlob("templates/*.html"))
+// LoginPageData is passed to login.html.
+type LoginPageData struct {
+	Error     string
+	Providers []*oauth.Provider
+
+	// DirectoryLogin: directory users may type their username instead of an email
+	DirectoryLogin bool
+}
+
+// renderLogin shows the login form with an optional error message.
+func renderLogin(w http.ResponseWriter, r *http.Request, errMsg string) {
+	Render(w, r, "login.html", LoginPageData{
+		Error:          errMsg,
+		Providers:      oauth.Providers(),
+		DirectoryLogin: usesBackend(ldapProvider),
+	})
+}
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Show the login form
-		loginTmpl.ExecuteTemplate(w, "login.html", nil)
+		renderLogin(w, r, "")
 
 	case "POST":
 		handleLoginPost(w, r)
@@ -35,75 +47,111 @@ func handleLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	// Basic validation
 	if email == "" || password == "" {
-		loginTmpl.ExecuteTemplate(w, "login.html", "All fields are required.")
+		renderLogin(w, r, "All fields are required.")
 		return
 	}
 
-	// Retrieve user by email
-	var (
-		userID   int
-		username string
-		hash     string
-	)
-
-	err := database.DB.QueryRow(
-		"SELECT id, username, password FROM users WHERE email = ?", email,
-	).Scan(&userID, &username, &hash)
-
-	if err == sql.ErrNoRows {
-		// Email not found
-		loginTmpl.ExecuteTemplate(w, "login.html", "Invalid email or password.")
+	// BRUTE-FORCE PROTECTION: refuse while the account or IP is locked
+	emailKey, ipKey := loginEmailKey(email), loginIPKey(ClientIP(r))
+	wait, err := lockedFor(emailKey, ipKey)
+	if err != nil {
+		log.Println("Error checking lockout:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderLogin(w, r, lockoutMessage(wait))
 		return
 	}
 
+	// Check the credentials with the configured backends (local, LDAP...)
+	userID, err := authenticate(email, password)
+	if err == ErrInvalidLogin {
+		// Unknown emails count too, so lockouts don't reveal which emails exist
+		recordLoginFailure(emailKey, ipKey)
+		renderLogin(w, r, "Invalid email or password.")
+		return
+	}
 	if err != nil {
-		log.Println("Login query error:", err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderLogin(w, r, "Login is temporarily unavailable. Please try again later.")
 		return
 	}
 
-	// Compare hashed password with user input
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	// Correct password: the failure count starts over in startSession,
+	// once the second factor (if any) has passed too
+	completeLogin(w, r, userID)
+}
+
+// rehashPassword stores a fresh hash made with the current default hasher.
+func rehashPassword(userID int, password string) {
+	hashed, err := pw.Hash(password)
 	if err != nil {
-		loginTmpl.ExecuteTemplate(w, "login.html", "Invalid email or password.")
+		log.Println("Error rehashing password:", err)
 		return
 	}
+	_, err = database.DB.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, userID)
+	if err != nil {
+		log.Println("Error storing rehashed password:", err)
+	}
+}
+
+func recordLoginFailure(emailKey, ipKey string) {
+	if err := recordAttempt(emailKey, loginAccountRule()); err != nil {
+		log.Println("Error recording login failure:", err)
+	}
+	if err := recordAttempt(ipKey, loginIPRule()); err != nil {
+		log.Println("Error recording login failure:", err)
+	}
+}
 
-	// ⭐ NEW: Only one active session per user
-	_, err = database.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+// completeLogin runs after the first factor succeeded (password, external
+// provider...): it asks for the second factor if the user enrolled one,
+// otherwise it creates the session and redirects home.
+func completeLogin(w http.ResponseWriter, r *http.Request, userID int) {
+	// Second factor: no session until the TOTP / recovery code is checked
+	enabled, err := TwoFactorEnabled(userID)
 	if err != nil {
-		log.Println("Error clearing old sessions:", err)
+		log.Println("Error checking 2FA:", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
+	if enabled {
+		if err := startTwoFactorChallenge(w, r, userID); err != nil {
+			log.Println("Error starting 2FA challenge:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+		}
+		return
+	}
 
-	// Create new session ID
-	sessionID := uuid.New().String()
-	expires := time.Now().Add(24 * time.Hour)
+	startSession(w, r, userID)
+}
 
-	_, err = database.DB.Exec(
-		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
-		sessionID, userID, expires,
-	)
+// startSession ends a login that passed every factor: the account's
+// failure count starts over, then the session is created.
+func startSession(w http.ResponseWriter, r *http.Request, userID int) {
+	if key, err := accountLoginKey(userID); err != nil {
+		log.Println("Error loading account for lockout:", err)
+	} else if err := clearThrottle(key); err != nil {
+		log.Println("Error clearing lockout:", err)
+	}
 
-	if err != nil {
+	// Create session (honours FORUM_SESSION_POLICY)
+	if err := CreateSession(w, r, userID); err != nil {
 		log.Println("Error creating session:", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Set session cookie
-	cookie := http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  expires,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Change to true if HTTPS enabled
-	}
-
-	http.SetCookie(w, &cookie)
-
 	// Redirect to homepage
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// accountLoginKey returns the throttle key of a user's email, the one
+// password failures count against when they log in by email.
+func accountLoginKey(userID int) (string, error) {
+	var email string
+	err := database.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
+	return loginEmailKey(email), err
+}
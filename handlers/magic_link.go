@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/mailer"
+)
+
+// Magic-link login
+//
+// The user enters an email and receives a single-use, short-lived link.
+// The browser that asked for it gets a random "magic_login" cookie whose
+// hash is stored with the link, so a forwarded (or intercepted) link does
+// not work anywhere else. Opening the link shows a confirmation button
+// instead of logging in on GET, so mail scanners that prefetch links
+// don't burn them.
+
+const magicCookie = "magic_login"
+
+func magicLinkTTL() time.Duration {
+	return config.Duration("FORUM_MAGIC_LINK_TTL", 15*time.Minute)
+}
+
+// Sending emails costs something and can annoy the recipient, so both the
+// address and the requesting IP are limited.
+func magicEmailRule() throttleRule {
+	return throttleRule{
+		freeAttempts: config.Int("FORUM_MAGIC_LINK_MAX_PER_EMAIL", 3),
+		baseDelay:    config.Duration("FORUM_MAGIC_LINK_LOCKOUT_BASE", 5*time.Minute),
+		maxDelay:     config.Duration("FORUM_LOCKOUT_MAX", time.Hour),
+		window:       time.Hour,
+	}
+}
+
+func magicIPRule() throttleRule {
+	r := magicEmailRule()
+	r.freeAttempts = config.Int("FORUM_MAGIC_LINK_MAX_PER_IP", 10)
+	return r
+}
+
+func magicEmailKey(email string) string { return "magic:email:" + strings.ToLower(email) }
+func magicIPKey(ip string) string       { return "magic:ip:" + ip }
+
+// MagicLinkPageData is passed to magic_link.html.
+type MagicLinkPageData struct {
+	Token   string
+	Error   string
+	Message string
+}
+
+// ---------------------------------------------------------------------------
+// MagicLinkHandler handles POST /login/magic (send a link) and
+// GET /login/magic?token=X (confirmation page).
+// ---------------------------------------------------------------------------
+func MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		Render(w, r, "magic_link.html", MagicLinkPageData{Token: token})
+
+	case "POST":
+		handleMagicLinkRequest(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		renderLogin(w, r, "Email is required.")
+		return
+	}
+
+	// RATE LIMIT: every request counts, whether or not the email exists
+	emailKey, ipKey := magicEmailKey(email), magicIPKey(ClientIP(r))
+	wait, err := lockedFor(emailKey, ipKey)
+	if err != nil {
+		log.Println("Error checking magic link lockout:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderLogin(w, r, lockoutMessage(wait))
+		return
+	}
+	if err := recordAttempt(emailKey, magicEmailRule()); err != nil {
+		log.Println("Error recording magic link request:", err)
+	}
+	if err := recordAttempt(ipKey, magicIPRule()); err != nil {
+		log.Println("Error recording magic link request:", err)
+	}
+
+	// Same answer whether or not the email exists
+	sent := MagicLinkPageData{
+		Message: "If that email is registered, a sign-in link is on its way. " +
+			"Open it in this browser within " + magicLinkTTL().String() + ".",
+	}
+
+	var userID int
+	err = database.DB.QueryRow(
+		"SELECT id FROM users WHERE email = ? AND email != ?", email, database.DeletedUserEmail,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		Render(w, r, "magic_link.html", sent)
+		return
+	}
+	if err != nil {
+		log.Println("Error looking up user for magic link:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// BROWSER BINDING: reuse this browser's nonce so earlier links keep working
+	nonce := ""
+	if c, err := r.Cookie(magicCookie); err == nil && c.Value != "" {
+		nonce = c.Value
+	} else if nonce, _, err = newToken(); err != nil {
+		log.Println("Error generating magic link nonce:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		log.Println("Error generating magic link:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	// Drop expired links while we are here
+	_, err = database.DB.Exec("DELETE FROM magic_links WHERE expires_at < ?", now)
+	if err != nil {
+		log.Println("Error cleaning magic links:", err)
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO magic_links (user_id, email, token_hash, browser_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, email, hash, hashToken(nonce), now.Add(magicLinkTTL()), now)
+	if err != nil {
+		log.Println("Error storing magic link:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicCookie,
+		Value:    nonce,
+		Path:     "/login/magic",
+		Expires:  now.Add(magicLinkTTL()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	link := config.BaseURL() + "/login/magic?token=" + url.QueryEscape(token)
+	body := "Someone asked for a sign-in link for your forum account.\n\n" +
+		"Open this link in the same browser to log in (valid for " + magicLinkTTL().String() + ", works once):\n" +
+		link + "\n\n" +
+		"If this wasn't you, you can ignore this email."
+
+	if err := mailer.Default().Send(email, "Your forum sign-in link", body); err != nil {
+		log.Println("Error sending magic link:", err)
+	}
+
+	Render(w, r, "magic_link.html", sent)
+}
+
+// ---------------------------------------------------------------------------
+// MagicLinkConfirmHandler handles POST /login/magic/confirm
+// ---------------------------------------------------------------------------
+func MagicLinkConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invalid := MagicLinkPageData{
+		Error: "This sign-in link is invalid, expired or already used. Please request a new one.",
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		Render(w, r, "magic_link.html", invalid)
+		return
+	}
+
+	var (
+		linkID      int
+		userID      int
+		email       string
+		browserHash string
+		expiresAt   time.Time
+	)
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, email, browser_hash, expires_at
+		FROM magic_links
+		WHERE token_hash = ? AND used_at IS NULL
+	`, hashToken(token)).Scan(&linkID, &userID, &email, &browserHash, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && time.Now().After(expiresAt)) {
+		Render(w, r, "magic_link.html", invalid)
+		return
+	}
+	if err != nil {
+		log.Println("Error looking up magic link:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The link only works in the browser that asked for it
+	nonce := ""
+	if c, err := r.Cookie(magicCookie); err == nil {
+		nonce = c.Value
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(hashToken(nonce)), []byte(browserHash)) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+		Render(w, r, "magic_link.html", MagicLinkPageData{
+			Error: "This sign-in link was requested from another browser. " +
+				"Open it in the browser where you asked for it, or request a new link here.",
+		})
+		return
+	}
+
+	// Single use: only one request can flip used_at
+	res, err := database.DB.Exec(
+		"UPDATE magic_links SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now(), linkID,
+	)
+	if err != nil {
+		log.Println("Error using magic link:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		Render(w, r, "magic_link.html", invalid)
+		return
+	}
+
+	// Opening the link proves the address, if it is still the account's
+	_, err = database.DB.Exec(
+		"UPDATE users SET email_verified = 1 WHERE id = ? AND email = ?", userID, email,
+	)
+	if err != nil {
+		log.Println("Error verifying email from magic link:", err)
+	}
+	BootstrapAdmins()
+
+	if err := clearThrottle(magicEmailKey(email)); err != nil {
+		log.Println("Error clearing magic link lockout:", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicCookie,
+		Value:    "",
+		Path:     "/login/magic",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Same as a password login: second factor if enrolled, then the session
+	completeLogin(w, r, userID)
+}
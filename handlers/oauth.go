@@ -0,0 +1,438 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/database"
+	"forum/oauth"
+)
+
+// oauthCookie carries "provider|mode|state|verifier|nonce" between
+// /oauth/start and /oauth/callback, binding the flow to the browser that
+// started it.
+const oauthCookie = "oauth_flow"
+
+// ------------------------------------------------------------
+// START
+// ------------------------------------------------------------
+
+// OAuthStartHandler handles GET /oauth/start?provider=X[&mode=link].
+func OAuthStartHandler(w http.ResponseWriter, r *http.Request) {
+	p := oauth.Get(r.URL.Query().Get("provider"))
+	if p == nil {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	mode := "login"
+	if r.URL.Query().Get("mode") == "link" {
+		user, _ := GetUserFromRequest(r)
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		mode = "link"
+	}
+
+	state, err := oauth.RandomString()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oauth.RandomString()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oauth.RandomString()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    strings.Join([]string{p.Name, mode, state, verifier, nonce}, "|"),
+		Path:     "/oauth",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		// Lax: the cookie must come back on the provider's top-level redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, p.AuthCodeURL(state, verifier, nonce), http.StatusFound)
+}
+
+// ------------------------------------------------------------
+// CALLBACK
+// ------------------------------------------------------------
+
+// OAuthCallbackHandler handles GET /oauth/callback from every provider.
+func OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oauthCookie)
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Value: "", Path: "/oauth", MaxAge: -1, HttpOnly: true})
+	if err != nil {
+		renderLogin(w, r, "Your sign-in attempt expired. Please try again.")
+		return
+	}
+
+	parts := strings.Split(cookie.Value, "|")
+	if len(parts) != 5 || parts[2] != r.URL.Query().Get("state") {
+		renderLogin(w, r, "Your sign-in attempt could not be verified. Please try again.")
+		return
+	}
+	providerName, mode, verifier, nonce := parts[0], parts[1], parts[3], parts[4]
+
+	p := oauth.Get(providerName)
+	if p == nil {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		renderLogin(w, r, p.DisplayName+" sign-in was cancelled.")
+		return
+	}
+
+	token, err := p.Exchange(r.Context(), r.URL.Query().Get("code"), verifier)
+	if err != nil {
+		log.Println("OAuth token exchange failed:", err)
+		renderLogin(w, r, "Could not sign in with "+p.DisplayName+".")
+		return
+	}
+
+	identity, err := p.FetchIdentity(r.Context(), token, nonce)
+	if err != nil {
+		log.Println("OAuth profile request failed:", err)
+		renderLogin(w, r, "Could not sign in with "+p.DisplayName+".")
+		return
+	}
+
+	if mode == "link" {
+		linkIdentity(w, r, p, identity)
+		return
+	}
+	loginWithIdentity(w, r, p, identity)
+}
+
+// linkIdentity attaches an external account to the logged-in user.
+func linkIdentity(w http.ResponseWriter, r *http.Request, p *oauth.Provider, id *oauth.Identity) {
+	user, _ := GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var owner int
+	err := database.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", p.Name, id.Subject,
+	).Scan(&owner)
+	switch {
+	case err == nil && owner == user.ID:
+		http.Redirect(w, r, "/settings/identities", http.StatusSeeOther)
+		return
+	case err == nil:
+		renderIdentities(w, r, user, "That "+p.DisplayName+" account is already linked to another user.")
+		return
+	case err != sql.ErrNoRows:
+		log.Println("Error checking identity:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES (?, ?, ?, ?)
+	`, user.ID, p.Name, id.Subject, id.Email)
+	if err != nil {
+		log.Println("Error linking identity:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/identities", http.StatusSeeOther)
+}
+
+// loginWithIdentity logs in the user linked to an external account. The
+// first time, it joins the account with the same verified email, or
+// creates a new local account.
+func loginWithIdentity(w http.ResponseWriter, r *http.Request, p *oauth.Provider, id *oauth.Identity) {
+	var userID int
+	err := database.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", p.Name, id.Subject,
+	).Scan(&userID)
+
+	if err == nil {
+		completeLogin(w, r, userID)
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Println("Error looking up identity:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// FIRST LOGIN → new account
+	if id.Email == "" {
+		renderLogin(w, r, p.DisplayName+" did not share an email address with us.")
+		return
+	}
+
+	// EXISTING ACCOUNT: join it only when both sides have proven the
+	// address. An unverified claim from the provider could name anyone's
+	// email, and an unverified local account may have been registered by
+	// someone squatting on it; either way the owner links from settings.
+	var (
+		existingID    int
+		localVerified bool
+	)
+	err = database.DB.QueryRow(
+		"SELECT id, email_verified FROM users WHERE email = ?", id.Email,
+	).Scan(&existingID, &localVerified)
+	switch {
+	case err == nil && id.EmailVerified && localVerified:
+		_, err = database.DB.Exec(`
+			INSERT INTO user_identities (user_id, provider, subject, email)
+			VALUES (?, ?, ?, ?)
+		`, existingID, p.Name, id.Subject, id.Email)
+		if err != nil {
+			log.Println("Error linking identity:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		completeLogin(w, r, existingID)
+		return
+	case err == nil:
+		renderLogin(w, r, "An account with this email already exists. Log in with your password, then link "+
+			p.DisplayName+" from your settings.")
+		return
+	case err != sql.ErrNoRows:
+		log.Println("Error checking email:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// REGISTRATION POLICY: providers can't bypass closed / invite-only signups
+	if msg := externalSignupMessage(id.Email); msg != "" {
+		renderLogin(w, r, msg)
+		return
+	}
+
+	userID, err = createExternalUser(p.Name, id)
+	if err != nil {
+		log.Println("Error creating user from identity:", err)
+		http.Error(w, "Could not create user", http.StatusInternalServerError)
+		return
+	}
+
+	if !id.EmailVerified {
+		if err := SendVerificationEmail(userID, id.Email); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	}
+
+	completeLogin(w, r, userID)
+}
+
+var usernameCleaner = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// createExternalUser inserts a users row (with no password) and its identity.
+func createExternalUser(provider string, id *oauth.Identity) (int, error) {
+	base := usernameCleaner.ReplaceAllString(id.Username, "")
+	if base == "" {
+		base = usernameCleaner.ReplaceAllString(strings.Split(id.Email, "@")[0], "")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username, err := uniqueUsername(base)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Empty password: password.Verify never matches it, so only the provider
+	// (or a password reset) can be used to log in.
+	res, err := tx.Exec(
+		"INSERT INTO users (email, username, password, email_verified) VALUES (?, ?, '', ?)",
+		id.Email, username, id.EmailVerified,
+	)
+	if err != nil {
+		return 0, err
+	}
+	userID, _ := res.LastInsertId()
+
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES (?, ?, ?, ?)
+	`, userID, provider, id.Subject, id.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(userID), tx.Commit()
+}
+
+// uniqueUsername returns base, or base2, base3... whichever is free.
+func uniqueUsername(base string) (string, error) {
+	for i := 1; ; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+
+		var exists int
+		err := database.DB.QueryRow(
+			"SELECT COUNT(*) FROM users WHERE username = ?", candidate,
+		).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if exists == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// ------------------------------------------------------------
+// SETTINGS — LINKED ACCOUNTS
+// ------------------------------------------------------------
+
+type IdentityView struct {
+	ID          int
+	Provider    string
+	DisplayName string
+	Email       string
+	LinkedAt    string
+}
+
+type IdentitiesPageData struct {
+	User       *SessionUser
+	Identities []IdentityView
+	Available  []*oauth.Provider // configured but not linked yet
+	Error      string
+}
+
+// IdentitiesHandler handles GET + POST for /settings/identities.
+func IdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		renderIdentities(w, r, user, "")
+	case "POST":
+		// UNLINK
+		identityID, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid identity", http.StatusBadRequest)
+			return
+		}
+		if msg := unlinkIdentity(user.ID, identityID); msg != "" {
+			renderIdentities(w, r, user, msg)
+			return
+		}
+		http.Redirect(w, r, "/settings/identities", http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// unlinkIdentity removes an identity, refusing to remove the
+// last way the user has to log in.
+func unlinkIdentity(userID, identityID int) string {
+	methods, err := signInMethods(userID)
+	if err != nil {
+		log.Println("Error loading user for unlink:", err)
+		return "Could not unlink the account."
+	}
+
+	if methods <= 1 {
+		return "Set a password (via \"Forgot your password?\") before unlinking your only sign-in method."
+	}
+
+	_, err = database.DB.Exec(
+		"DELETE FROM user_identities WHERE id = ? AND user_id = ?", identityID, userID,
+	)
+	if err != nil {
+		log.Println("Error unlinking identity:", err)
+		return "Could not unlink the account."
+	}
+	return ""
+}
+
+// signInMethods counts the ways a user can log in: a password, linked
+// identities and passkeys.
+func signInMethods(userID int) (int, error) {
+	var count int
+	err := database.DB.QueryRow(`
+		SELECT (users.password != '')
+		       + (SELECT COUNT(*) FROM user_identities WHERE user_id = users.id)
+		       + (SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = users.id)
+		FROM users WHERE users.id = ?
+	`, userID).Scan(&count)
+	return count, err
+}
+
+func renderIdentities(w http.ResponseWriter, r *http.Request, user *SessionUser, errMsg string) {
+	rows, err := database.DB.Query(`
+		SELECT id, provider, email, strftime('%Y-%m-%d %H:%M:%S', created_at)
+		FROM user_identities
+		WHERE user_id = ?
+		ORDER BY created_at ASC
+	`, user.ID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	data := IdentitiesPageData{User: user, Error: errMsg}
+	linked := map[string]bool{}
+
+	for rows.Next() {
+		var iv IdentityView
+		if err := rows.Scan(&iv.ID, &iv.Provider, &iv.Email, &iv.LinkedAt); err != nil {
+			log.Println("Error scanning identity:", err)
+			continue
+		}
+		iv.DisplayName = iv.Provider
+		if p := oauth.Get(iv.Provider); p != nil {
+			iv.DisplayName = p.DisplayName
+		} else if iv.Provider == ldapProvider {
+			iv.DisplayName = "Company directory (LDAP)"
+		}
+		linked[iv.Provider] = true
+		data.Identities = append(data.Identities, iv)
+	}
+	rows.Close()
+
+	for _, p := range oauth.Providers() {
+		if !linked[p.Name] {
+			data.Available = append(data.Available, p)
+		}
+	}
+
+	if err := Render(w, r, "settings_identities.html", data); err != nil {
+		panic(err)
+	}
+}
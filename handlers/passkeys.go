@@ -0,0 +1,605 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/webauthn"
+)
+
+// Passkeys (WebAuthn)
+//
+// A logged-in user registers one or more passkeys from /settings/passkeys;
+// anyone can then sign in with one from the login page. Both ceremonies
+// are a begin/finish pair of JSON endpoints called by static/webauthn.js:
+// "begin" stores a random challenge under a "webauthn_ceremony" cookie,
+// "finish" consumes it (once) and checks the authenticator's response.
+//
+// Logins use discoverable credentials, so the user doesn't type an email.
+// A passkey that verified the user (PIN, biometrics) is enough on its
+// own; otherwise users with an authenticator app still get the 2FA step.
+
+const (
+	ceremonyCookie = "webauthn_ceremony"
+	ceremonyTTL    = 5 * time.Minute
+
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// relyingParty describes the forum to authenticators. The ID must be the
+// site's domain (or a parent of it) and the origin exactly what the
+// browser shows, both derived from FORUM_BASE_URL unless set.
+func relyingParty() *webauthn.RelyingParty {
+	origin := config.String("FORUM_WEBAUTHN_ORIGIN", config.BaseURL())
+	id := ""
+	if u, err := url.Parse(origin); err == nil {
+		id = u.Hostname()
+	}
+	return &webauthn.RelyingParty{
+		ID:     config.String("FORUM_WEBAUTHN_RP_ID", id),
+		Name:   config.String("FORUM_WEBAUTHN_RP_NAME", "Forum"),
+		Origin: strings.TrimRight(origin, "/"),
+	}
+}
+
+// userHandle identifies the account inside a passkey. It is opaque to
+// the authenticator, so the user id is enough.
+func userHandle(userID int) string {
+	return webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(userID)))
+}
+
+// writeJSON sends v as the response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing JSON:", err)
+	}
+}
+
+func jsonError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// ------------------------------------------------------------
+// CEREMONY STATE
+// ------------------------------------------------------------
+
+// beginCeremony stores a fresh challenge for this browser and returns it.
+// userID is 0 for logins, where the user is not known yet.
+func beginCeremony(w http.ResponseWriter, kind string, userID int) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = database.DB.Exec("DELETE FROM webauthn_challenges WHERE expires_at < ?", now)
+	if err != nil {
+		log.Println("Error cleaning WebAuthn challenges:", err)
+	}
+
+	owner := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	_, err = database.DB.Exec(`
+		INSERT INTO webauthn_challenges (token_hash, user_id, kind, challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, hash, owner, kind, challenge, now.Add(ceremonyTTL))
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    token,
+		Path:     "/webauthn",
+		Expires:  now.Add(ceremonyTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return challenge, nil
+}
+
+// finishCeremony consumes this browser's pending challenge of the given
+// kind. It returns "" if there is none (missing, expired or already used).
+func finishCeremony(w http.ResponseWriter, r *http.Request, kind string, userID int) (string, error) {
+	cookie, err := r.Cookie(ceremonyCookie)
+	http.SetCookie(w, &http.Cookie{Name: ceremonyCookie, Value: "", Path: "/webauthn", MaxAge: -1, HttpOnly: true})
+	if err != nil || cookie.Value == "" {
+		return "", nil
+	}
+	hash := hashToken(cookie.Value)
+
+	var (
+		owner     sql.NullInt64
+		challenge string
+		expiresAt time.Time
+	)
+	err = database.DB.QueryRow(`
+		SELECT user_id, challenge, expires_at FROM webauthn_challenges
+		WHERE token_hash = ? AND kind = ?
+	`, hash, kind).Scan(&owner, &challenge, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Single use, whatever the outcome
+	if _, err := database.DB.Exec("DELETE FROM webauthn_challenges WHERE token_hash = ?", hash); err != nil {
+		return "", err
+	}
+
+	if time.Now().After(expiresAt) || int(owner.Int64) != userID {
+		return "", nil
+	}
+	return challenge, nil
+}
+
+// decodeField decodes a base64url value sent by webauthn.js.
+func decodeField(s string) []byte {
+	b, err := webauthn.Encoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// ------------------------------------------------------------
+// REGISTRATION
+// ------------------------------------------------------------
+
+// passkeyUser returns the cookie-session user allowed to manage passkeys,
+// or writes the JSON error and returns nil.
+func passkeyUser(w http.ResponseWriter, r *http.Request) *SessionUser {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return nil
+	}
+	if user == nil {
+		jsonError(w, http.StatusUnauthorized, "Please log in again.")
+		return nil
+	}
+	if user.Scopes != nil {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return nil
+	}
+	return user
+}
+
+// PasskeyRegisterBeginHandler handles POST /webauthn/register/begin and
+// returns the options for navigator.credentials.create().
+func PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := passkeyUser(w, r)
+	if user == nil {
+		return
+	}
+
+	// Ask the authenticator not to create a second passkey for this account
+	var exclude []map[string]string
+	rows, err := database.DB.Query(
+		"SELECT credential_id FROM webauthn_credentials WHERE user_id = ?", user.ID,
+	)
+	if err != nil {
+		log.Println("Error loading passkeys:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			exclude = append(exclude, map[string]string{"type": "public-key", "id": id})
+		}
+	}
+	rows.Close()
+
+	challenge, err := beginCeremony(w, ceremonyRegister, user.ID)
+	if err != nil {
+		log.Println("Error starting passkey registration:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	rp := relyingParty()
+	var params []map[string]interface{}
+	for _, alg := range webauthn.Algorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": rp.ID, "name": rp.Name},
+		"user": map[string]string{
+			"id":          userHandle(user.ID),
+			"name":        user.Email,
+			"displayName": user.Username,
+		},
+		"pubKeyCredParams":   params,
+		"excludeCredentials": exclude,
+		"timeout":            ceremonyTTL.Milliseconds(),
+		"attestation":        "none",
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "required",
+			"userVerification": "preferred",
+		},
+	})
+}
+
+// passkeyRegistration is the body webauthn.js posts to /webauthn/register/finish.
+type passkeyRegistration struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// PasskeyRegisterFinishHandler handles POST /webauthn/register/finish.
+func PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := passkeyUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var body passkeyRegistration
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request.")
+		return
+	}
+
+	challenge, err := finishCeremony(w, r, ceremonyRegister, user.ID)
+	if err != nil {
+		log.Println("Error loading passkey challenge:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if challenge == "" {
+		jsonError(w, http.StatusBadRequest, "The request expired. Please try again.")
+		return
+	}
+
+	cred, err := relyingParty().VerifyRegistration(challenge,
+		decodeField(body.ClientDataJSON), decodeField(body.AttestationObject))
+	if err != nil {
+		log.Println("Passkey registration rejected:", err)
+		jsonError(w, http.StatusBadRequest, "The passkey could not be verified.")
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name)
+		VALUES (?, ?, ?, ?, ?)
+	`, user.ID, webauthn.Encoding.EncodeToString(cred.ID), cred.PublicKey, cred.SignCount, name)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		jsonError(w, http.StatusConflict, "This passkey is already registered.")
+		return
+	}
+	if err != nil {
+		log.Println("Error storing passkey:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	recordAccountChange(r, user.ID, "passkey added", "", name)
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": "/settings/passkeys"})
+}
+
+// ------------------------------------------------------------
+// LOGIN
+// ------------------------------------------------------------
+
+// PasskeyLoginBeginHandler handles POST /webauthn/login/begin and returns
+// the options for navigator.credentials.get().
+func PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	challenge, err := beginCeremony(w, ceremonyLogin, 0)
+	if err != nil {
+		log.Println("Error starting passkey login:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             relyingParty().ID,
+		"timeout":          ceremonyTTL.Milliseconds(),
+		"userVerification": "preferred",
+	})
+}
+
+// passkeyAssertion is the body webauthn.js posts to /webauthn/login/finish.
+type passkeyAssertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// PasskeyLoginFinishHandler handles POST /webauthn/login/finish.
+func PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Failures count against the IP like failed password logins
+	ipKey := loginIPKey(ClientIP(r))
+	wait, err := lockedFor(ipKey)
+	if err != nil {
+		log.Println("Error checking lockout:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if wait > 0 {
+		jsonError(w, http.StatusTooManyRequests, lockoutMessage(wait))
+		return
+	}
+
+	var body passkeyAssertion
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request.")
+		return
+	}
+
+	challenge, err := finishCeremony(w, r, ceremonyLogin, 0)
+	if err != nil {
+		log.Println("Error loading passkey challenge:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if challenge == "" {
+		jsonError(w, http.StatusBadRequest, "The request expired. Please try again.")
+		return
+	}
+
+	failed := func(reason error) {
+		log.Println("Passkey login rejected:", reason)
+		if err := recordAttempt(ipKey, loginIPRule()); err != nil {
+			log.Println("Error recording login failure:", err)
+		}
+		jsonError(w, http.StatusUnauthorized, "This passkey is not recognised.")
+	}
+
+	var (
+		credID    int
+		userID    int
+		publicKey []byte
+		signCount uint32
+	)
+	err = database.DB.QueryRow(`
+		SELECT id, user_id, public_key, sign_count
+		FROM webauthn_credentials
+		WHERE credential_id = ?
+	`, strings.TrimRight(body.ID, "=")).Scan(&credID, &userID, &publicKey, &signCount)
+	if err == sql.ErrNoRows {
+		failed(err)
+		return
+	}
+	if err != nil {
+		log.Println("Error loading passkey:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	// Discoverable credentials name their account; it must be the owner
+	if body.UserHandle != "" && strings.TrimRight(body.UserHandle, "=") != userHandle(userID) {
+		failed(errors.New("user handle does not match the passkey's owner"))
+		return
+	}
+
+	assertion, err := relyingParty().VerifyAssertion(challenge, publicKey, signCount,
+		decodeField(body.ClientDataJSON), decodeField(body.AuthenticatorData), decodeField(body.Signature))
+	if err != nil {
+		if err == webauthn.ErrCloned {
+			log.Printf("Passkey %d of user %d: signature counter went backwards (cloned authenticator?)", credID, userID)
+		}
+		failed(err)
+		return
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ? WHERE id = ?",
+		assertion.SignCount, time.Now(), credID,
+	)
+	if err != nil {
+		log.Println("Error updating passkey:", err)
+	}
+
+	// Without user verification the passkey is only "something you have"
+	if !assertion.UserVerified {
+		enabled, err := TwoFactorEnabled(userID)
+		if err != nil {
+			log.Println("Error checking 2FA:", err)
+			jsonError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+		if enabled {
+			if err := issueTwoFactorChallenge(w, userID); err != nil {
+				log.Println("Error starting 2FA challenge:", err)
+				jsonError(w, http.StatusInternalServerError, "Server error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"redirect": "/login/2fa"})
+			return
+		}
+	}
+
+	// Create session (honours FORUM_SESSION_POLICY)
+	if err := CreateSession(w, r, userID); err != nil {
+		log.Println("Error creating session:", err)
+		jsonError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+}
+
+// ------------------------------------------------------------
+// SETTINGS
+// ------------------------------------------------------------
+
+// PasskeyView is one passkey on the settings page.
+type PasskeyView struct {
+	ID        int
+	Name      string
+	CreatedAt string
+	LastUsed  string
+}
+
+// PasskeysPageData is passed to settings_passkeys.html.
+type PasskeysPageData struct {
+	User     *SessionUser
+	Passkeys []PasskeyView
+	Error    string
+	Message  string
+}
+
+// ---------------------------------------------------------------------------
+// PasskeysHandler handles GET + POST /settings/passkeys
+// ---------------------------------------------------------------------------
+func PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if user.Scopes != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := PasskeysPageData{User: user}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "delete":
+			data.Error = deletePasskey(r, user)
+			if data.Error == "" {
+				data.Message = "Passkey removed."
+			}
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadPasskeys(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "settings_passkeys.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// deletePasskey removes a passkey, refusing to remove the last way the
+// user has to log in.
+func deletePasskey(r *http.Request, user *SessionUser) string {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		return "Passkey not found."
+	}
+
+	var name string
+	err = database.DB.QueryRow(
+		"SELECT name FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, user.ID,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "Passkey not found."
+	}
+	if err != nil {
+		log.Println("Error loading passkey:", err)
+		return "Could not remove the passkey."
+	}
+
+	methods, err := signInMethods(user.ID)
+	if err != nil {
+		log.Println("Error counting sign-in methods:", err)
+		return "Could not remove the passkey."
+	}
+	if methods <= 1 {
+		return "Set a password (via \"Forgot your password?\") before removing your only sign-in method."
+	}
+
+	_, err = database.DB.Exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, user.ID)
+	if err != nil {
+		log.Println("Error removing passkey:", err)
+		return "Could not remove the passkey."
+	}
+
+	recordAccountChange(r, user.ID, "passkey removed", name, "")
+	return ""
+}
+
+func loadPasskeys(user *SessionUser, data *PasskeysPageData) error {
+	rows, err := database.DB.Query(`
+		SELECT id, name, strftime('%Y-%m-%d %H:%M:%S', created_at), last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = ?
+		ORDER BY id ASC
+	`, user.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p        PasskeyView
+			lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &lastUsed); err != nil {
+			return err
+		}
+		p.LastUsed = "never"
+		if lastUsed.Valid {
+			p.LastUsed = lastUsed.Time.Format("2006-01-02 15:04")
+		}
+		data.Passkeys = append(data.Passkeys, p)
+	}
+	return rows.Err()
+}
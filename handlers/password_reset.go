@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/mailer"
+	pw "forum/password"
+)
+
+func resetTokenTTL() time.Duration {
+	return config.Duration("FORUM_RESET_TOKEN_TTL", time.Hour)
+}
+
+// Every request may send an email, so both the address and the
+// requesting IP are limited (like sign-in links).
+func resetEmailRule() throttleRule {
+	return throttleRule{
+		freeAttempts: config.Int("FORUM_RESET_MAX_PER_EMAIL", 3),
+		baseDelay:    config.Duration("FORUM_RESET_LOCKOUT_BASE", 5*time.Minute),
+		maxDelay:     config.Duration("FORUM_LOCKOUT_MAX", time.Hour),
+		window:       time.Hour,
+	}
+}
+
+func resetIPRule() throttleRule {
+	r := resetEmailRule()
+	r.freeAttempts = config.Int("FORUM_RESET_MAX_PER_IP", 10)
+	return r
+}
+
+func resetEmailKey(email string) string {
+	return "reset:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+func resetIPKey(ip string) string { return "reset:ip:" + ip }
+
+// ForgotPasswordHandler handles GET + POST for /forgot-password.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		Render(w, r, "forgot_password.html", nil)
+	case "POST":
+		handleForgotPasswordPost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+	if email == "" {
+		Render(w, r, "forgot_password.html",
+			map[string]string{"Error": "Email is required."})
+		return
+	}
+
+	// RATE LIMIT: every request counts, whether or not the email exists
+	emailKey, ipKey := resetEmailKey(email), resetIPKey(ClientIP(r))
+	wait, err := lockedFor(emailKey, ipKey)
+	if err != nil {
+		log.Println("Error checking reset lockout:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		Render(w, r, "forgot_password.html", map[string]string{"Error": lockoutMessage(wait)})
+		return
+	}
+	if err := recordAttempt(emailKey, resetEmailRule()); err != nil {
+		log.Println("Error recording reset request:", err)
+	}
+	if err := recordAttempt(ipKey, resetIPRule()); err != nil {
+		log.Println("Error recording reset request:", err)
+	}
+
+	// Same answer whether or not the email exists, so the form
+	// cannot be used to find out who has an account.
+	sent := map[string]string{
+		"Message": "If that email is registered, a reset link is on its way.",
+	}
+
+	var userID int
+	err = database.DB.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		Render(w, r, "forgot_password.html", sent)
+		return
+	}
+	if err != nil {
+		log.Println("Error looking up user for reset:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		log.Println("Error generating reset token:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	// Drop expired tokens while we are here
+	_, err = database.DB.Exec("DELETE FROM reset_tokens WHERE expires_at < ?", now)
+	if err != nil {
+		log.Println("Error cleaning reset tokens:", err)
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO reset_tokens (user_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+	`, userID, hash, now.Add(resetTokenTTL()), now)
+	if err != nil {
+		log.Println("Error storing reset token:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	link := config.BaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	body := "Someone asked to reset the password for your forum account.\n\n" +
+		"Open this link to choose a new password (valid for " + resetTokenTTL().String() + "):\n" +
+		link + "\n\n" +
+		"If this wasn't you, you can ignore this email."
+
+	if err := mailer.Default().Send(email, "Reset your forum password", body); err != nil {
+		log.Println("Error sending reset email:", err)
+	}
+
+	Render(w, r, "forgot_password.html", sent)
+}
+
+// lookupResetToken returns the token row ID and user for a valid, unused token.
+func lookupResetToken(token string) (tokenID, userID int, ok bool) {
+	if token == "" {
+		return 0, 0, false
+	}
+
+	var expiresAt time.Time
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, expires_at
+		FROM reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL
+	`, hashToken(token)).Scan(&tokenID, &userID, &expiresAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Error looking up reset token:", err)
+		}
+		return 0, 0, false
+	}
+
+	if time.Now().After(expiresAt) {
+		return 0, 0, false
+	}
+	return tokenID, userID, true
+}
+
+// ResetPasswordHandler handles GET + POST for /reset-password.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		token := r.URL.Query().Get("token")
+		if _, _, ok := lookupResetToken(token); !ok {
+			Render(w, r, "reset_password.html",
+				map[string]string{"Error": "This reset link is invalid or has expired."})
+			return
+		}
+		Render(w, r, "reset_password.html",
+			map[string]string{"Token": token})
+	case "POST":
+		handleResetPasswordPost(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	confirm := r.FormValue("confirm_password")
+
+	tokenID, userID, ok := lookupResetToken(token)
+	if !ok {
+		Render(w, r, "reset_password.html",
+			map[string]string{"Error": "This reset link is invalid or has expired."})
+		return
+	}
+
+	if password == "" || password != confirm {
+		Render(w, r, "reset_password.html", map[string]string{
+			"Token": token,
+			"Error": "Passwords must be filled in and match.",
+		})
+		return
+	}
+
+	if err := pw.Validate(password); err != nil {
+		Render(w, r, "reset_password.html", map[string]string{
+			"Token": token,
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	hashed, err := pw.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Println("Error starting reset transaction:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Claim the token — the used_at check makes it single-use even
+	// if the form is submitted twice.
+	res, err := tx.Exec(
+		"UPDATE reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+		time.Now(), tokenID,
+	)
+	if err != nil {
+		log.Println("Error claiming reset token:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		Render(w, r, "reset_password.html",
+			map[string]string{"Error": "This reset link has already been used."})
+		return
+	}
+
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{"UPDATE users SET password = ? WHERE id = ?", []any{hashed, userID}},
+		// Any other outstanding links for this user are no longer needed
+		{"DELETE FROM reset_tokens WHERE user_id = ? AND used_at IS NULL", []any{userID}},
+		// Log the user out everywhere
+		{"DELETE FROM sessions WHERE user_id = ?", []any{userID}},
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			log.Println("Error resetting password:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing password reset:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
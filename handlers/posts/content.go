@@ -0,0 +1,38 @@
+package posts
+
+import (
+	"html/template"
+	"log"
+
+	"forum/database"
+	"forum/markdown"
+)
+
+// Post and comment content is Markdown. The rendered (sanitised) HTML is
+// stored next to it whenever it is written, and rebuilt on display when
+// it was cached by an older version of the renderer or predates it.
+
+// PostHTML returns a post's rendered content.
+func PostHTML(postID int, source, cached string, version int) template.HTML {
+	return cachedHTML("posts", postID, source, cached, version)
+}
+
+func commentHTML(commentID int, source, cached string, version int) template.HTML {
+	return cachedHTML("comments", commentID, source, cached, version)
+}
+
+func cachedHTML(table string, id int, source, cached string, version int) template.HTML {
+	if version == markdown.Version {
+		return template.HTML(cached)
+	}
+
+	rendered := markdown.Render(source)
+	_, err := database.DB.Exec(
+		"UPDATE "+table+" SET content_html = ?, content_html_version = ? WHERE id = ?",
+		rendered, markdown.Version, id,
+	)
+	if err != nil {
+		log.Println("Error caching rendered content:", err)
+	}
+	return template.HTML(rendered)
+}
@@ -1,11 +1,14 @@
 package posts
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
 	"forum/database"
 	auth "forum/handlers"
+	"forum/images"
+	"forum/markdown"
 )
 
 // For passing category list to the template
@@ -25,6 +28,9 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
 
 	switch r.Method {
 
@@ -49,29 +55,55 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 
 		data := map[string]interface{}{
 			"Categories": cats,
+			"MaxImages":  images.MaxPerPost(),
+			"MaxSize":    sizeLabel(images.MaxBytes()),
 		}
 
-		postTmpl.ExecuteTemplate(w, "create_post.html", data)
+		auth.Render(w, r, "create_post.html", data)
 
 	// -------------------------
 	// POST — Create post
 	// -------------------------
 	case "POST":
 
+		// Uploads are bounded here, before the form is read
+		r.Body = http.MaxBytesReader(w, r.Body, images.MaxRequestBytes())
+		if err := r.ParseMultipartForm(multipartMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+
 		title := r.FormValue("title")
 		content := r.FormValue("content")
-		categoryIDs := r.Form["category_ids"]
+		// Parsed like the feed filter: a category named twice is stored once
+		catIDs, err := categoryIDs(r.Form["category_ids"])
+		if err != nil {
+			http.Error(w, "Invalid category", http.StatusBadRequest)
+			return
+		}
 
 		if title == "" || content == "" {
-			postTmpl.ExecuteTemplate(w, "create_post.html",
+			auth.Render(w, r, "create_post.html",
 				map[string]string{"Error": "All fields are required."})
 			return
 		}
 
+		// Checked before anything is saved, so a refused file leaves no post behind
+		uploads, msg := readUploads(r)
+		if msg != "" {
+			auth.Render(w, r, "create_post.html", map[string]string{"Error": msg})
+			return
+		}
+
 		result, err := database.DB.Exec(`
-			INSERT INTO posts (user_id, title, content)
-			VALUES (?, ?, ?)
-		`, user.ID, title, content)
+			INSERT INTO posts (user_id, title, content, content_html, content_html_version)
+			VALUES (?, ?, ?, ?, ?)
+		`, user.ID, title, content, markdown.Render(content), markdown.Version)
 
 		if err != nil {
 			log.Println("Insert post error:", err)
@@ -81,7 +113,12 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 
 		postID, _ := result.LastInsertId()
 
-		for _, catID := range categoryIDs {
+		// New posts start at the top of the "hot" feed
+		if err := database.RefreshPostScores(int(postID)); err != nil {
+			log.Println("Error scoring post:", err)
+		}
+
+		for _, catID := range catIDs {
 			_, err := database.DB.Exec(`
 				INSERT INTO post_categories (post_id, category_id)
 				VALUES (?, ?)
@@ -92,6 +129,8 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		attachImages(int(postID), uploads)
+
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 
 	default:
@@ -0,0 +1,112 @@
+package posts
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DiffPart is a run of text that is unchanged ("="), inserted ("+") or
+// deleted ("-") between two revisions.
+type DiffPart struct {
+	Op   string
+	Text string
+}
+
+// maxDiffCells bounds the LCS table (4 bytes per cell). Texts too long
+// to compare word by word are compared line by line, and past that the
+// changed middle is shown as replaced.
+const maxDiffCells = 4_000_000
+
+// diffText compares two texts word by word, keeping whitespace.
+func diffText(a, b string) []DiffPart {
+	x, y := splitWords(a), splitWords(b)
+	if len(x)*len(y) > maxDiffCells {
+		x, y = strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	}
+	return diffTokens(x, y)
+}
+
+// splitWords splits s into alternating runs of space and non-space.
+func splitWords(s string) []string {
+	var tokens []string
+	start, space := 0, false
+	for i, c := range s {
+		if i > start && unicode.IsSpace(c) != space {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(c)
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func diffTokens(x, y []string) []DiffPart {
+	var parts []DiffPart
+	add := func(op string, tokens ...string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		}
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, DiffPart{Op: op, Text: text})
+	}
+
+	// Edits are usually local: skip the common prefix and suffix
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	add("=", x[:pre]...)
+	mx, my := x[pre:len(x)-suf], y[pre:len(y)-suf]
+
+	n, m := len(mx), len(my)
+	if n*m > maxDiffCells {
+		add("-", mx...)
+		add("+", my...)
+		add("=", x[len(x)-suf:]...)
+		return parts
+	}
+
+	// lcs[i*(m+1)+j] = length of the longest common subsequence of mx[i:] and my[j:]
+	lcs := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) int32 { return lcs[i*(m+1)+j] }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i*(m+1)+j] = at(i+1, j+1) + 1
+			} else {
+				lcs[i*(m+1)+j] = max(at(i+1, j), at(i, j+1))
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case mx[i] == my[j]:
+			add("=", mx[i])
+			i++
+			j++
+		case at(i+1, j) >= at(i, j+1):
+			add("-", mx[i])
+			i++
+		default:
+			add("+", my[j])
+			j++
+		}
+	}
+	add("-", mx[i:]...)
+	add("+", my[j:]...)
+	add("=", x[len(x)-suf:]...)
+	return parts
+}
@@ -0,0 +1,216 @@
+package posts
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/markdown"
+)
+
+// Posts can be edited and deleted by their author or a moderator.
+// Every edit is kept in post_revisions (the first edit also stores the
+// original), so the history page can diff any two versions. Deleting
+// only marks the post: it stays as a "[deleted]" tombstone above its
+// comments, which would otherwise lose their context.
+
+type EditPostPageData struct {
+	User    *auth.SessionUser
+	PostID  int
+	Title   string
+	Content string
+	Error   string
+}
+
+// postRecord is what edits and deletes need to know about a post.
+type postRecord struct {
+	ID      int
+	UserID  int
+	Title   string
+	Content string
+	Deleted bool
+}
+
+// loadPostRecord returns the post, or nil if it does not exist.
+func loadPostRecord(postID int) (*postRecord, error) {
+	p := postRecord{ID: postID}
+	err := database.DB.QueryRow(`
+		SELECT user_id, title, content, deleted_at IS NOT NULL
+		FROM posts WHERE id = ?
+	`, postID).Scan(&p.UserID, &p.Title, &p.Content, &p.Deleted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// canModify reports whether user may edit or delete a post by authorID.
+func canModify(user *auth.SessionUser, authorID int) bool {
+	return user != nil && (user.ID == authorID || user.Can(auth.PermModeratePosts))
+}
+
+// postForChange loads the post named by the "id" parameter and checks
+// that user may change it, writing the error response if not.
+func postForChange(w http.ResponseWriter, r *http.Request, user *auth.SessionUser) *postRecord {
+	postID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return nil
+	}
+
+	p, err := loadPostRecord(postID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	if p == nil || p.Deleted {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return nil
+	}
+	if !canModify(user, p.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	return p
+}
+
+// -----------------------------------------------------------
+// EditPostHandler — GET shows the form, POST saves a revision
+// -----------------------------------------------------------
+func EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
+
+	p := postForChange(w, r, user)
+	if p == nil {
+		return
+	}
+
+	data := EditPostPageData{User: user, PostID: p.ID, Title: p.Title, Content: p.Content}
+
+	switch r.Method {
+	case "GET":
+		auth.Render(w, r, "edit_post.html", data)
+
+	case "POST":
+		data.Title = r.FormValue("title")
+		data.Content = r.FormValue("content")
+
+		if data.Title == "" || data.Content == "" {
+			data.Error = "All fields are required."
+			auth.Render(w, r, "edit_post.html", data)
+			return
+		}
+
+		// Saving without changes doesn't create a revision
+		if data.Title != p.Title || data.Content != p.Content {
+			if err := savePostRevision(p.ID, user.ID, data.Title, data.Content); err != nil {
+				log.Println("Error saving post revision:", err)
+				http.Error(w, "Error saving post", http.StatusInternalServerError)
+				return
+			}
+			if user.ID != p.UserID {
+				log.Printf("Moderator %d edited post %d", user.ID, p.ID)
+			}
+		}
+
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(p.ID), http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// savePostRevision records a new version of a post and makes it current.
+func savePostRevision(postID, editorID int, title, content string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The first edit also stores the original, so it can be diffed against
+	_, err = tx.Exec(`
+		INSERT INTO post_revisions (post_id, editor_id, title, content, created_at)
+		SELECT id, user_id, title, content, created_at FROM posts
+		WHERE id = ?1 AND NOT EXISTS (SELECT 1 FROM post_revisions WHERE post_id = ?1)
+	`, postID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO post_revisions (post_id, editor_id, title, content)
+		VALUES (?, ?, ?, ?)
+	`, postID, editorID, title, content)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE posts
+		SET title = ?, content = ?, content_html = ?, content_html_version = ?,
+		    edited_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, title, content, markdown.Render(content), markdown.Version, postID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// -----------------------------------------------------------
+// DeletePostHandler — POST turns the post into a tombstone
+// -----------------------------------------------------------
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := auth.GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !auth.RequireVerified(w, r, user) {
+		return
+	}
+
+	p := postForChange(w, r, user)
+	if p == nil {
+		return
+	}
+
+	// Title, content and revisions stay in the database; pages show the
+	// tombstone instead. Comments and likes are left alone, but images go.
+	_, err := database.DB.Exec(`
+		UPDATE posts SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, user.ID, p.ID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	if err := releasePostImages(p.ID); err != nil {
+		log.Println("Error removing post images:", err)
+	}
+	if user.ID != p.UserID {
+		log.Printf("Moderator %d deleted post %d", user.ID, p.ID)
+	}
+
+	http.Redirect(w, r, "/post?id="+strconv.Itoa(p.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,113 @@
+package posts
+
+import (
+	"strings"
+
+	"forum/database"
+	likes "forum/handlers/likes"
+	"forum/models"
+	"forum/pagination"
+)
+
+// FeedQuery narrows the post feed down (the home page shows every post).
+type FeedQuery struct {
+	Join  string // extra JOIN clauses
+	Where string // extra condition, "" for none
+	Args  []any  // arguments of Where
+}
+
+// Feed is one page of a post feed.
+type Feed struct {
+	Posts []models.Post
+	Page  pagination.Page
+	Sort  Sort
+}
+
+// LoadFeed returns the requested page of the posts matching q in the
+// given order (newest first for the zero Sort), with their categories,
+// like counts and rendered content. Deleted posts are left out.
+func LoadFeed(q FeedQuery, sort Sort, req pagination.Request) (Feed, error) {
+	key := sort.column()
+	if key == "" {
+		key = "posts.created_at"
+	}
+	cond, keyArgs, orderLimit := req.Keyset(key, "posts.id")
+
+	where := []string{"posts.deleted_at IS NULL"}
+	if q.Where != "" {
+		where = append(where, "("+q.Where+")")
+	}
+	if since := sort.since(); since != "" {
+		where = append(where, since)
+	}
+	where = append(where, cond)
+	args := append(append([]any{}, q.Args...), keyArgs...)
+
+	rows, err := database.DB.Query(`
+		SELECT posts.id,
+		       posts.user_id,
+		       users.username,
+		       posts.title,
+		       posts.content,
+		       posts.content_html,
+		       posts.content_html_version,
+		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at),
+		       `+key+`
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		`+q.Join+`
+		WHERE `+strings.Join(where, " AND ")+`
+		`+orderLimit, args...)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	type rawPost struct {
+		models.Post
+		HTML        string
+		HTMLVersion int
+		Key         any
+	}
+
+	var raw []rawPost
+	for rows.Next() {
+		var rp rawPost
+		err := rows.Scan(&rp.ID, &rp.UserID, &rp.Username, &rp.Title, &rp.Content,
+			&rp.HTML, &rp.HTMLVersion, &rp.CreatedAt, &rp.Key)
+		if err != nil {
+			rows.Close()
+			return Feed{}, err
+		}
+		raw = append(raw, rp)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return Feed{}, err
+	}
+	rows.Close() // IMPORTANT for SQLite: the queries below need the connection
+
+	raw, page := pagination.Finish(req, raw, func(rp rawPost) pagination.Cursor {
+		return pagination.NewCursor(rp.Key, rp.ID)
+	})
+
+	feed := Feed{Page: page, Sort: sort}
+	for _, rp := range raw {
+		p := rp.Post
+		p.ContentHTML = PostHTML(p.ID, p.Content, rp.HTML, rp.HTMLVersion)
+
+		// Categories (convert handler type → models.Category)
+		cats, _ := GetCategoriesForPost(p.ID)
+		for _, c := range cats {
+			p.Categories = append(p.Categories, models.Category{
+				ID:   c.ID,
+				Name: c.Name,
+			})
+		}
+
+		p.Likes, p.Dislikes = likes.CountPostLikes(p.ID)
+
+		feed.Posts = append(feed.Posts, p)
+	}
+
+	return feed, nil
+}
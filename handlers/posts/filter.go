@@ -0,0 +1,150 @@
+package posts
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedFilter narrows a feed down by category, author and date. It is
+// read from the query string so filtered views can be bookmarked:
+//
+//	?cat=1&cat=2        posts in category 1 or 2
+//	&match=all          ...in both of them instead
+//	&exclude=3          and not in category 3
+//	&author=alice       by alice
+//	&from=2024-01-01    posted on or after that day
+//	&to=2024-01-31      ...and on or before that one
+type FeedFilter struct {
+	Categories []int
+	MatchAll   bool
+	Exclude    []int
+	Author     string
+	From, To   string // YYYY-MM-DD
+}
+
+// ErrInvalidFilter is returned for malformed filter parameters.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// maxFilterCategories bounds how many categories a filter names.
+const maxFilterCategories = 50
+
+// FilterFromRequest reads the filter parameters of r.
+func FilterFromRequest(r *http.Request) (FeedFilter, error) {
+	q := r.URL.Query()
+	f := FeedFilter{
+		MatchAll: q.Get("match") == "all",
+		Author:   strings.TrimSpace(q.Get("author")),
+		From:     q.Get("from"),
+		To:       q.Get("to"),
+	}
+
+	var err error
+	if f.Categories, err = categoryIDs(q["cat"]); err != nil {
+		return FeedFilter{}, err
+	}
+	if f.Exclude, err = categoryIDs(q["exclude"]); err != nil {
+		return FeedFilter{}, err
+	}
+	for _, d := range []string{f.From, f.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return FeedFilter{}, ErrInvalidFilter
+		}
+	}
+	return f, nil
+}
+
+// categoryIDs parses, sorts and de-duplicates category ids.
+func categoryIDs(values []string) ([]int, error) {
+	var ids []int
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, ErrInvalidFilter
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if len(ids) > maxFilterCategories {
+		return nil, ErrInvalidFilter
+	}
+	return ids, nil
+}
+
+// Active reports whether the filter leaves any post out.
+func (f FeedFilter) Active() bool {
+	return len(f.Categories) > 0 || len(f.Exclude) > 0 || f.Author != "" || f.From != "" || f.To != ""
+}
+
+// Includes reports whether category id is one of those asked for.
+func (f FeedFilter) Includes(id int) bool {
+	return slices.Contains(f.Categories, id)
+}
+
+// Excludes reports whether category id is excluded.
+func (f FeedFilter) Excludes(id int) bool {
+	return slices.Contains(f.Exclude, id)
+}
+
+// Query returns the feed query selecting the filtered posts.
+func (f FeedFilter) Query() FeedQuery {
+	var (
+		where []string
+		args  []any
+	)
+
+	if len(f.Categories) > 0 {
+		in := placeholders(len(f.Categories))
+		if f.MatchAll {
+			// DISTINCT: databases older than UNIQUE(post_id, category_id) may
+			// hold a pair twice
+			where = append(where, `(SELECT COUNT(DISTINCT post_categories.category_id) FROM post_categories
+				WHERE post_categories.post_id = posts.id AND post_categories.category_id IN (`+in+`)) = ?`)
+		} else {
+			where = append(where, `EXISTS (SELECT 1 FROM post_categories
+				WHERE post_categories.post_id = posts.id AND post_categories.category_id IN (`+in+`))`)
+		}
+		for _, id := range f.Categories {
+			args = append(args, id)
+		}
+		if f.MatchAll {
+			args = append(args, len(f.Categories))
+		}
+	}
+	if len(f.Exclude) > 0 {
+		where = append(where, `NOT EXISTS (SELECT 1 FROM post_categories
+			WHERE post_categories.post_id = posts.id AND post_categories.category_id IN (`+placeholders(len(f.Exclude))+`))`)
+		for _, id := range f.Exclude {
+			args = append(args, id)
+		}
+	}
+	if f.Author != "" {
+		where = append(where, "users.username = ? COLLATE NOCASE")
+		args = append(args, f.Author)
+	}
+	if f.From != "" {
+		where = append(where, "posts.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		where = append(where, "posts.created_at < date(?, '+1 day')")
+		args = append(args, f.To)
+	}
+
+	return FeedQuery{Where: strings.Join(where, " AND "), Args: args}
+}
+
+// placeholders returns "?, ?, ..." for n values.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,120 @@
+package posts
+
+import (
+	"net/http"
+	"strconv"
+
+	"forum/database"
+	auth "forum/handlers"
+)
+
+type RevisionView struct {
+	Number    int // 1 = the original
+	Editor    string
+	CreatedAt string
+	title     string
+	content   string
+}
+
+type PostHistoryPageData struct {
+	User        *auth.SessionUser
+	PostID      int
+	Title       string
+	Revisions   []RevisionView
+	From        int
+	To          int
+	TitleDiff   []DiffPart
+	ContentDiff []DiffPart
+}
+
+// -----------------------------------------------------------
+// PostHistoryHandler — lists a post's revisions and diffs two
+// of them (?from=&to=, the latest edit by default)
+// -----------------------------------------------------------
+func PostHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromRequest(r)
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	p, err := loadPostRecord(postID)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	if p == nil || p.Deleted {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT COALESCE(users.username, '[deleted]'),
+		       strftime('%Y-%m-%d %H:%M:%S', post_revisions.created_at),
+		       post_revisions.title,
+		       post_revisions.content
+		FROM post_revisions
+		LEFT JOIN users ON post_revisions.editor_id = users.id
+		WHERE post_revisions.post_id = ?
+		ORDER BY post_revisions.id
+	`, postID)
+	if err != nil {
+		panic(err)
+	}
+
+	var revisions []RevisionView
+	for rows.Next() {
+		rv := RevisionView{Number: len(revisions) + 1}
+		if err := rows.Scan(&rv.Editor, &rv.CreatedAt, &rv.title, &rv.content); err != nil {
+			rows.Close()
+			panic(err)
+		}
+		revisions = append(revisions, rv)
+	}
+	rows.Close()
+
+	// Never edited → nothing to compare
+	if len(revisions) < 2 {
+		http.Redirect(w, r, "/post?id="+strconv.Itoa(postID), http.StatusSeeOther)
+		return
+	}
+
+	from := revisionParam(r, "from", len(revisions)-1)
+	to := revisionParam(r, "to", len(revisions))
+	if from < 1 || to < 1 || from > len(revisions) || to > len(revisions) {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	a, b := revisions[from-1], revisions[to-1]
+	data := PostHistoryPageData{
+		User:        user,
+		PostID:      postID,
+		Title:       p.Title,
+		Revisions:   revisions,
+		From:        from,
+		To:          to,
+		TitleDiff:   diffText(a.title, b.title),
+		ContentDiff: diffText(a.content, b.content),
+	}
+
+	if err := auth.Render(w, r, "post_history.html", data); err != nil {
+		// Template failure → panic → main.go wrapper → 500.html
+		panic(err)
+	}
+}
+
+// revisionParam reads a revision number, or returns def if it is absent.
+func revisionParam(r *http.Request, key string, def int) int {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+package posts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"forum/database"
+	"forum/images"
+	"forum/models"
+)
+
+// multipartMemory is how much of an upload is kept in memory; the rest
+// goes to temporary files until the request ends.
+const multipartMemory = 8 << 20
+
+// readUploads checks the files sent in the "images" field of a post
+// form. It returns a message for the user if one of them is refused.
+func readUploads(r *http.Request) ([]*images.Image, string) {
+	if r.MultipartForm == nil {
+		return nil, ""
+	}
+
+	var files []*multipart.FileHeader
+	for _, fh := range r.MultipartForm.File["images"] {
+		// Browsers send an empty part when no file was chosen
+		if fh.Filename != "" || fh.Size > 0 {
+			files = append(files, fh)
+		}
+	}
+	if len(files) == 0 {
+		return nil, ""
+	}
+	switch limit := images.MaxPerPost(); {
+	case limit == 0:
+		return nil, "Image uploads are turned off."
+	case len(files) > limit:
+		return nil, fmt.Sprintf("You can attach at most %d images.", limit)
+	}
+
+	var list []*images.Image
+	for _, fh := range files {
+		img, err := readUpload(fh)
+		if errors.Is(err, images.ErrTooLarge) {
+			return nil, fmt.Sprintf("%s: %v (%s).", fh.Filename, err, sizeLabel(images.MaxBytes()))
+		}
+		if err != nil {
+			return nil, fmt.Sprintf("%s: %v.", fh.Filename, err)
+		}
+		list = append(list, img)
+	}
+	return list, ""
+}
+
+func readUpload(fh *multipart.FileHeader) (*images.Image, error) {
+	if fh.Size > int64(images.MaxBytes()) {
+		return nil, images.ErrTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(images.MaxBytes())+1))
+	if err != nil {
+		return nil, err
+	}
+	return images.Process(data)
+}
+
+// sizeLabel formats a byte count for people ("5 MB", "800 KB").
+func sizeLabel(n int) string {
+	if n >= 1<<20 && n%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d KB", (n+1<<10-1)>>10)
+}
+
+// attachImages records imgs as the pictures of a post and stores their
+// files. The row comes first, so the file is never seen unused.
+func attachImages(postID int, imgs []*images.Image) {
+	for _, img := range imgs {
+		res, err := database.DB.Exec(`
+			INSERT INTO post_images (post_id, hash, format, width, height, size, thumbnail_format)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, postID, img.Hash, img.Format, img.Width, img.Height, len(img.Data), img.ThumbnailFormat)
+		if err != nil {
+			log.Println("Error saving post image:", err)
+			continue
+		}
+
+		if err := images.Save(img); err != nil {
+			log.Println("Error storing image file:", err)
+			id, _ := res.LastInsertId()
+			if _, err := database.DB.Exec("DELETE FROM post_images WHERE id = ?", id); err != nil {
+				log.Println("Error removing post image:", err)
+			}
+		}
+	}
+}
+
+// loadPostImages returns the pictures of a post, in upload order.
+func loadPostImages(postID int) ([]models.PostImage, error) {
+	rows, err := database.DB.Query(`
+		SELECT hash, format, width, height, thumbnail_format
+		FROM post_images
+		WHERE post_id = ?
+		ORDER BY id
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.PostImage
+	for rows.Next() {
+		var (
+			img                  models.PostImage
+			hash, format, thumbs string
+		)
+		if err := rows.Scan(&hash, &format, &img.Width, &img.Height, &thumbs); err != nil {
+			return nil, err
+		}
+		img.URL = "/images/" + images.FileName(hash, format, false)
+		img.ThumbnailURL = img.URL
+		if thumbs != "" {
+			img.ThumbnailURL = "/images/" + images.FileName(hash, thumbs, true)
+		}
+		list = append(list, img)
+	}
+	return list, rows.Err()
+}
+
+// releasePostImages detaches every picture from a post and deletes the
+// files no other post uses.
+func releasePostImages(postID int) error {
+	rows, err := database.DB.Query("SELECT hash FROM post_images WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+
+	if _, err := database.DB.Exec("DELETE FROM post_images WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+	images.RemoveUnused(hashes...)
+	return nil
+}
+
+// -----------------------------------------------------------
+// ImageHandler — GET /images/HASH.EXT (or HASH.thumb.EXT)
+// serves a picture attached to a post
+// -----------------------------------------------------------
+func ImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/images/")
+	hash, rest, _ := strings.Cut(name, ".")
+	thumbnail := strings.HasPrefix(rest, "thumb.")
+	if !images.ValidHash(hash) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only files attached to a post are served
+	var format, thumbFormat string
+	err := database.DB.QueryRow(
+		"SELECT format, thumbnail_format FROM post_images WHERE hash = ? LIMIT 1", hash,
+	).Scan(&format, &thumbFormat)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	if thumbnail {
+		format = thumbFormat
+	}
+	if format == "" || name != images.FileName(hash, format, thumbnail) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(images.Path(name))
+	if err != nil {
+		log.Println("Error opening image:", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	// The name is the content's hash: it never changes, so browsers may
+	// keep it forever, and ServeContent answers If-None-Match with 304.
+	// Shared caches may keep the response too, so it must not carry the
+	// visitor's anonymous CSRF cookie.
+	h := w.Header()
+	h.Del("Set-Cookie")
+	h.Set("Content-Type", images.ContentType(format))
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Set("ETag", `"`+name+`"`)
+	h.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, time.Time{}, f)
+}
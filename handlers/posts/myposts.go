@@ -1,17 +1,13 @@
 package posts
 
 import (
-	"html/template"
 	"net/http"
 
-	"forum/database"
 	auth "forum/handlers"
-	likes "forum/handlers/likes"
 	"forum/models"
+	"forum/pagination"
 )
 
-var myPostsTmpl = template.Must(template.ParseGlob("templates/*.html"))
-
 // MyPostsHandler shows posts created by the logged-in user.
 func MyPostsHandler(w http.ResponseWriter, r *http.Request) {
 	user, _ := auth.GetUserFromRequest(r)
@@ -20,85 +16,37 @@ func MyPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1. Load raw posts for this user
-	rows, err := database.DB.Query(`
-		SELECT posts.id,
-		       posts.user_id,
-		       users.username,
-		       posts.title,
-		       posts.content,
-		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at)
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		WHERE posts.user_id = ?
-		ORDER BY posts.created_at DESC
-	`, user.ID)
+	// 1. Load one page of this user's posts
+	sort, err := SortFromRequest(r)
 	if err != nil {
-		// Internal DB error → panic → 500 page
-		panic(err)
-	}
-
-	type rawPost struct {
-		ID        int
-		UserID    int
-		Username  string
-		Title     string
-		Content   string
-		CreatedAt string
+		http.Error(w, "Invalid sort order", http.StatusBadRequest)
+		return
 	}
-
-	var rawPosts []rawPost
-	for rows.Next() {
-		var rp rawPost
-		if err := rows.Scan(&rp.ID, &rp.UserID, &rp.Username, &rp.Title, &rp.Content, &rp.CreatedAt); err != nil {
-			continue
-		}
-		rawPosts = append(rawPosts, rp)
+	req, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid page cursor", http.StatusBadRequest)
+		return
 	}
-	rows.Close()
-
-	// 2. Enrich with categories + likes
-	var postsList []models.Post
-
-	for _, rp := range rawPosts {
-		p := models.Post{
-			ID:        rp.ID,
-			UserID:    rp.UserID,
-			Username:  rp.Username,
-			Title:     rp.Title,
-			Content:   rp.Content,
-			CreatedAt: rp.CreatedAt,
-		}
-
-		// Categories (convert handler type → models.Category)
-		handlerCats, _ := GetCategoriesForPost(p.ID)
-		var converted []models.Category
-		for _, c := range handlerCats {
-			converted = append(converted, models.Category{
-				ID:   c.ID,
-				Name: c.Name,
-			})
-		}
-		p.Categories = converted
-
-		// Likes
-		l, d := likes.CountPostLikes(p.ID)
-		p.Likes = l
-		p.Dislikes = d
-
-		postsList = append(postsList, p)
+	feed, err := LoadFeed(FeedQuery{Where: "posts.user_id = ?", Args: []any{user.ID}}, sort, req)
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
 	}
 
-	// 3. Render template
+	// 2. Render template
 	data := struct {
 		User  *auth.SessionUser
 		Posts []models.Post
+		Page  pagination.Page
+		Sort  Sort
 	}{
 		User:  user,
-		Posts: postsList,
+		Posts: feed.Posts,
+		Page:  feed.Page,
+		Sort:  feed.Sort,
 	}
 
-	if err := myPostsTmpl.ExecuteTemplate(w, "my_posts.html", data); err != nil {
+	if err := auth.Render(w, r, "my_posts.html", data); err != nil {
 		// Template error → panic → 500 page
 		panic(err)
 	}
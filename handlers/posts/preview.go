@@ -0,0 +1,38 @@
+package posts
+
+import (
+	"net/http"
+
+	auth "forum/handlers"
+	"forum/markdown"
+)
+
+// maxPreviewSize bounds the Markdown a preview request may send.
+const maxPreviewSize = 64 << 10
+
+// -----------------------------------------------------------
+// PreviewHandler — POST renders the "content" field as it will
+// appear once saved (an HTML fragment, for static/preview.js)
+// -----------------------------------------------------------
+func PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := auth.GetUserFromRequest(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	content := r.FormValue("content")
+	if len(content) > maxPreviewSize {
+		http.Error(w, "Content too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(markdown.Render(content)))
+}
@@ -0,0 +1,154 @@
+package posts
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Feeds can be sorted with ?sort=new|hot|top|controversial; "top" takes
+// a time window, ?t=day|week|month|all. The rankings are stored with
+// each post (see package ranking), so every order pages through an index
+// like "new" does.
+
+// ErrInvalidSort is returned for unknown sort or t parameters.
+var ErrInvalidSort = errors.New("invalid sort order")
+
+// sortOrders maps each order to the column the feed is sorted by.
+var sortOrders = []struct {
+	name, label, column string
+}{
+	{"new", "New", "posts.created_at"},
+	{"hot", "Hot", "posts.hot_score"},
+	{"top", "Top", "posts.vote_score"},
+	{"controversial", "Controversial", "posts.controversy_score"},
+}
+
+// topWindows maps each window of the "top" order to an SQLite date
+// modifier ("" = all time).
+var topWindows = []struct {
+	name, label, modifier string
+}{
+	{"day", "Today", "-1 day"},
+	{"week", "This week", "-7 days"},
+	{"month", "This month", "-1 month"},
+	{"all", "All time", ""},
+}
+
+// Sort is the order a feed was asked for.
+type Sort struct {
+	Order  string // "new" (default), "hot", "top" or "controversial"
+	Window string // for "top": "day", "week", "month" or "all" (default)
+
+	url *url.URL
+}
+
+// SortFromRequest reads the "sort" and "t" parameters of r.
+func SortFromRequest(r *http.Request) (Sort, error) {
+	q := r.URL.Query()
+	s := Sort{Order: q.Get("sort"), Window: q.Get("t"), url: r.URL}
+	if s.Order == "" {
+		s.Order = "new"
+	}
+	if s.column() == "" {
+		return Sort{}, ErrInvalidSort
+	}
+
+	if s.Order != "top" {
+		s.Window = ""
+	} else if s.Window == "" {
+		s.Window = "all"
+	}
+	if s.Window != "" && !knownWindow(s.Window) {
+		return Sort{}, ErrInvalidSort
+	}
+	return s, nil
+}
+
+func (s Sort) column() string {
+	for _, o := range sortOrders {
+		if o.name == s.Order {
+			return o.column
+		}
+	}
+	return ""
+}
+
+func knownWindow(name string) bool {
+	for _, w := range topWindows {
+		if w.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// since returns the condition limiting "top" to its window ("" if none).
+func (s Sort) since() string {
+	for _, w := range topWindows {
+		if w.name == s.Window && w.modifier != "" {
+			return "posts.created_at >= datetime('now', '" + w.modifier + "')"
+		}
+	}
+	return ""
+}
+
+// SortLink is one choice of the sort bar.
+type SortLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// Orders returns links to the feed in every order, starting over at its
+// first page.
+func (s Sort) Orders() []SortLink {
+	var links []SortLink
+	for _, o := range sortOrders {
+		links = append(links, SortLink{
+			Label:  o.label,
+			URL:    s.link(o.name, ""),
+			Active: o.name == s.Order,
+		})
+	}
+	return links
+}
+
+// Windows returns links to the "top" feed over every window (nil for
+// the other orders).
+func (s Sort) Windows() []SortLink {
+	if s.Order != "top" {
+		return nil
+	}
+	var links []SortLink
+	for _, w := range topWindows {
+		links = append(links, SortLink{
+			Label:  w.label,
+			URL:    s.link("top", w.name),
+			Active: w.name == s.Window,
+		})
+	}
+	return links
+}
+
+// link returns the feed's URL in another order, keeping its other
+// parameters (category...) but not the page.
+func (s Sort) link(order, window string) string {
+	if s.url == nil {
+		return ""
+	}
+	q := s.url.Query()
+	for _, p := range []string{"after", "before", "sort", "t"} {
+		q.Del(p)
+	}
+	if order != "new" {
+		q.Set("sort", order)
+	}
+	if window != "" {
+		q.Set("t", window)
+	}
+	if len(q) == 0 {
+		return s.url.Path
+	}
+	return s.url.Path + "?" + q.Encode()
+}
@@ -0,0 +1,136 @@
+package posts
+
+import (
+	"html/template"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+	comments "forum/handlers/comments"
+)
+
+type CommentView struct {
+	ID          int
+	PostID      int
+	ParentID    int // 0 for top-level comments
+	Content     string
+	ContentHTML template.HTML
+	CreatedAt   string
+	EditedAt    string // "" if never edited
+	Username    string
+	Likes       int
+	Dislikes    int
+	Deleted     bool // shown as "[deleted]"; likes and replies are kept
+	CanEdit     bool
+	CanDelete   bool
+	CanReply    bool
+
+	Replies        []*CommentView
+	ReplyCount     int  // direct replies, loaded or not
+	ContinueThread bool // replies exist below the depth limit
+}
+
+// loadCommentTree returns a post's comments as a tree, oldest first at
+// every level, at most comments.MaxDepth() levels deep. With rootID set
+// only that comment and its replies are loaded (nothing if it belongs to
+// another post).
+//
+// One recursive query walks the tree; each row carries its depth and a
+// path of zero-padded ids, so sorting by path lists every comment right
+// after its parent.
+func loadCommentTree(postID, rootID int, user *auth.SessionUser, postDeleted bool) ([]*CommentView, error) {
+	rows, err := database.DB.Query(`
+		WITH RECURSIVE thread(id, depth, path) AS (
+			SELECT id, 0, printf('%010d', id)
+			FROM comments
+			WHERE post_id = ?1
+			  AND CASE WHEN ?2 = 0 THEN parent_comment_id IS NULL ELSE id = ?2 END
+			UNION ALL
+			SELECT comments.id, thread.depth + 1, thread.path || '/' || printf('%010d', comments.id)
+			FROM comments
+			JOIN thread ON comments.parent_comment_id = thread.id
+			WHERE thread.depth + 1 < ?3
+		)
+		SELECT comments.id,
+		       COALESCE(comments.parent_comment_id, 0),
+		       comments.user_id,
+		       comments.content,
+		       comments.content_html,
+		       comments.content_html_version,
+		       strftime('%Y-%m-%d %H:%M:%S', comments.created_at),
+		       CAST(strftime('%s', 'now') - strftime('%s', comments.created_at) AS INTEGER),
+		       COALESCE(strftime('%Y-%m-%d %H:%M:%S', comments.edited_at), ''),
+		       comments.deleted_at IS NOT NULL,
+		       users.username,
+		       thread.depth,
+		       (SELECT COUNT(*) FROM comments AS replies WHERE replies.parent_comment_id = comments.id),
+		       (SELECT COUNT(*) FROM likes WHERE likes.comment_id = comments.id AND likes.post_id IS NULL AND likes.value = 1),
+		       (SELECT COUNT(*) FROM likes WHERE likes.comment_id = comments.id AND likes.post_id IS NULL AND likes.value = -1)
+		FROM thread
+		JOIN comments ON comments.id = thread.id
+		JOIN users ON comments.user_id = users.id
+		ORDER BY thread.path
+	`, postID, rootID, comments.MaxDepth())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	maxDepth := comments.MaxDepth()
+	type cachedContent struct {
+		cv      *CommentView
+		html    string
+		version int
+	}
+	var (
+		roots   []*CommentView
+		stack   []*CommentView // the current node's ancestors, by depth
+		content []cachedContent
+	)
+	for rows.Next() {
+		cv := &CommentView{PostID: postID}
+		var (
+			userID      int
+			ageSeconds  int64
+			depth       int
+			contentHTML string
+			htmlVersion int
+		)
+		err := rows.Scan(&cv.ID, &cv.ParentID, &userID, &cv.Content, &contentHTML, &htmlVersion, &cv.CreatedAt, &ageSeconds,
+			&cv.EditedAt, &cv.Deleted, &cv.Username, &depth, &cv.ReplyCount, &cv.Likes, &cv.Dislikes)
+		if err != nil {
+			return nil, err
+		}
+
+		// Deleted comments keep their place (and likes) in the thread
+		if cv.Deleted {
+			cv.Content, cv.Username, cv.EditedAt = "", "", ""
+		} else {
+			age := time.Duration(ageSeconds) * time.Second
+			cv.CanEdit = !postDeleted && comments.CanEdit(user, userID, age)
+			cv.CanDelete = comments.CanDelete(user, userID)
+			cv.CanReply = !postDeleted && user != nil
+			content = append(content, cachedContent{cv, contentHTML, htmlVersion})
+		}
+		cv.ContinueThread = depth == maxDepth-1 && cv.ReplyCount > 0
+
+		stack = append(stack[:depth], cv)
+		if depth == 0 {
+			roots = append(roots, cv)
+		} else {
+			parent := stack[depth-1]
+			parent.Replies = append(parent.Replies, cv)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Rendering may update the cache, so only once the rows are closed
+	for _, c := range content {
+		c.cv.ContentHTML = commentHTML(c.cv.ID, c.cv.Content, c.html, c.version)
+	}
+
+	return roots, nil
+}
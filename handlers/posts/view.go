@@ -1,8 +1,7 @@
 package posts
 
 import (
-	"html/template"
-	"log"
+	"database/sql"
 	"net/http"
 	"strconv"
 
@@ -12,21 +11,15 @@ import (
 	"forum/models"
 )
 
-var postTmpl = template.Must(template.ParseGlob("templates/*.html"))
-
 type PostPageData struct {
 	User     *auth.SessionUser
 	Post     models.Post
-	Comments []CommentView
-}
+	CanEdit  bool // author or moderator
+	Comments []*CommentView
 
-type CommentView struct {
-	ID        int
-	Content   string
-	CreatedAt string
-	Username  string
-	Likes     int
-	Dislikes  int
+	// Set when a single thread is shown (?thread=ID)
+	Thread       int
+	ThreadParent int // the thread root's parent, 0 if it is top-level
 }
 
 // -----------------------------------------------------------
@@ -49,23 +42,45 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 2. Load the post
 	var p models.Post
+	var (
+		editedAt    sql.NullString
+		contentHTML string
+		htmlVersion int
+	)
 	err = database.DB.QueryRow(`
 		SELECT posts.id,
 		       posts.user_id,
 		       users.username,
 		       posts.title,
 		       posts.content,
-		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at)
+		       posts.content_html,
+		       posts.content_html_version,
+		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at),
+		       strftime('%Y-%m-%d %H:%M:%S', posts.edited_at),
+		       posts.deleted_at IS NOT NULL
 		FROM posts
 		JOIN users ON posts.user_id = users.id
 		WHERE posts.id = ?
-	`, postID).Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt)
+	`, postID).Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content,
+		&contentHTML, &htmlVersion, &p.CreatedAt, &editedAt, &p.Deleted)
 
 	if err != nil {
 		// Treat as "not found" for now (could be sql.ErrNoRows or other)
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	p.EditedAt = editedAt.String
+
+	// Deleted posts stay as a tombstone so their comments keep a parent
+	if p.Deleted {
+		p.Title, p.Content, p.EditedAt = "", "", ""
+	} else {
+		p.ContentHTML = PostHTML(p.ID, p.Content, contentHTML, htmlVersion)
+		if p.Images, err = loadPostImages(p.ID); err != nil {
+			// Internal DB failure → panic, caught by main.go wrapper → 500 page
+			panic(err)
+		}
+	}
 
 	// 3. Load & convert categories (handler type → models.Category)
 	handlerCats, _ := GetCategoriesForPost(postID)
@@ -83,65 +98,38 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 	p.Likes = lc
 	p.Dislikes = dc
 
-	// 5. Load comments into a buffer (avoid SQLite cursor + nested queries)
-	rows, err := database.DB.Query(`
-		SELECT comments.id,
-		       comments.content,
-		       strftime('%Y-%m-%d %H:%M:%S', comments.created_at),
-		       users.username
-		FROM comments
-		JOIN users ON comments.user_id = users.id
-		WHERE comments.post_id = ?
-		ORDER BY comments.created_at ASC
-	`, postID)
+	// 5. Load the comment tree, or the subtree of ?thread=ID
+	threadID := 0
+	if v := r.URL.Query().Get("thread"); v != "" {
+		threadID, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+	}
+	tree, err := loadCommentTree(postID, threadID, user, p.Deleted)
 	if err != nil {
 		// Internal DB failure → panic, caught by main.go wrapper → 500 page
 		panic(err)
 	}
-
-	type rawComment struct {
-		ID        int
-		Content   string
-		CreatedAt string
-		Username  string
-	}
-
-	var rawComments []rawComment
-	for rows.Next() {
-		var rc rawComment
-		if err := rows.Scan(&rc.ID, &rc.Content, &rc.CreatedAt, &rc.Username); err != nil {
-			log.Println("Error scanning comment:", err)
-			continue
-		}
-		rawComments = append(rawComments, rc)
-	}
-	rows.Close()
-
-	// 6. Convert to CommentView + per-comment likes
-	var comments []CommentView
-	for _, rc := range rawComments {
-		cv := CommentView{
-			ID:        rc.ID,
-			Content:   rc.Content,
-			CreatedAt: rc.CreatedAt,
-			Username:  rc.Username,
-		}
-
-		lc, dc := likes.CountCommentLikes(rc.ID)
-		cv.Likes = lc
-		cv.Dislikes = dc
-
-		comments = append(comments, cv)
+	if threadID != 0 && len(tree) == 0 {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
 	}
 
-	// 7. Render template
+	// 6. Render template
 	data := PostPageData{
 		User:     user,
 		Post:     p,
-		Comments: comments,
+		CanEdit:  !p.Deleted && canModify(user, p.UserID),
+		Comments: tree,
+		Thread:   threadID,
+	}
+	if threadID != 0 {
+		data.ThreadParent = tree[0].ParentID
 	}
 
-	if err := postTmpl.ExecuteTemplate(w, "post.html", data); err != nil {
+	if err := auth.Render(w, r, "post.html", data); err != nil {
 		// Template failure → panic → main.go wrapper → 500.html
 		panic(err)
 	}
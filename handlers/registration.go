@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/mailer"
+)
+
+// Registration policies (FORUM_REGISTRATION).
+const (
+	RegistrationOpen   = "open"   // anyone can register
+	RegistrationInvite = "invite" // a valid invite code is required
+	RegistrationDomain = "domain" // the email must belong to FORUM_REGISTRATION_DOMAINS
+	RegistrationClosed = "closed" // no new accounts
+)
+
+// registrationPolicy returns the configured policy, falling back to open.
+func registrationPolicy() string {
+	switch p := config.String("FORUM_REGISTRATION", RegistrationOpen); p {
+	case RegistrationInvite, RegistrationDomain, RegistrationClosed:
+		return p
+	default:
+		return RegistrationOpen
+	}
+}
+
+// registrationDomains lists the email domains accepted by the "domain" policy.
+func registrationDomains() []string {
+	var domains []string
+	for _, d := range config.List("FORUM_REGISTRATION_DOMAINS") {
+		domains = append(domains, strings.ToLower(strings.TrimPrefix(d, "@")))
+	}
+	return domains
+}
+
+func emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range registrationDomains() {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterPageData is passed to register.html.
+type RegisterPageData struct {
+	Error   string
+	Policy  string
+	Domains []string
+	Invite  string
+}
+
+// renderRegister shows the registration form with an optional error message.
+func renderRegister(w http.ResponseWriter, r *http.Request, errMsg string) {
+	Render(w, r, "register.html", RegisterPageData{
+		Error:   errMsg,
+		Policy:  registrationPolicy(),
+		Domains: registrationDomains(),
+		Invite:  r.FormValue("invite"),
+	})
+}
+
+// checkRegistrationPolicy returns an error message when email may not
+// register, and the invite to consume: under the invite-only policy, or
+// an invite mailed to email, which proves the address and lets it
+// register whatever the policy (see InviteBootstrapAdmins).
+func checkRegistrationPolicy(email, code string) (inviteID int, errMsg string, err error) {
+	var boundTo string
+	if code != "" {
+		if inviteID, boundTo, err = findInvite(code); err != nil {
+			return 0, "", err
+		}
+	}
+	if inviteID != 0 && boundTo != "" {
+		if !strings.EqualFold(boundTo, email) {
+			return 0, "This invite was sent to another email address.", nil
+		}
+		return inviteID, "", nil
+	}
+
+	switch registrationPolicy() {
+	case RegistrationClosed:
+		return 0, "Registration is closed.", nil
+
+	case RegistrationDomain:
+		if !emailDomainAllowed(email) {
+			return 0, "Registration is limited to these email domains: " +
+				strings.Join(registrationDomains(), ", ") + ".", nil
+		}
+
+	case RegistrationInvite:
+		if code == "" {
+			return 0, "An invite code is required to register.", nil
+		}
+		if inviteID == 0 {
+			return 0, "This invite code is invalid, used up or expired.", nil
+		}
+		return inviteID, "", nil
+	}
+
+	return 0, "", nil
+}
+
+// externalSignupMessage returns why an external provider may not create
+// a new account for email, or "" if it may. Invites can't travel through
+// the provider redirect, so invite-only forums need a normal registration.
+func externalSignupMessage(email string) string {
+	switch registrationPolicy() {
+	case RegistrationClosed:
+		return "Registration is closed."
+	case RegistrationInvite:
+		return "New accounts need an invite. Register with your invite code, then link this provider from your settings."
+	case RegistrationDomain:
+		if !emailDomainAllowed(email) {
+			return "Registration is limited to these email domains: " +
+				strings.Join(registrationDomains(), ", ") + "."
+		}
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+// Invites
+// ---------------------------------------------------------------------------
+
+// findInvite returns the id of a usable invite (or 0), and the address
+// it was mailed to ("" for codes handed out by members).
+func findInvite(code string) (id int, email string, err error) {
+	err = database.DB.QueryRow(`
+		SELECT id, email FROM invites
+		WHERE code_hash = ?
+		  AND revoked_at IS NULL
+		  AND (max_uses = 0 OR uses < max_uses)
+		  AND (expires_at IS NULL OR expires_at > ?)
+	`, hashToken(code), time.Now()).Scan(&id, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return id, email, err
+}
+
+// adminInviteLifetime is how long the invites mailed to
+// FORUM_ADMIN_EMAILS work.
+const adminInviteLifetime = 7 * 24 * time.Hour
+
+// InviteBootstrapAdmins mails a single-use invite to every address in
+// FORUM_ADMIN_EMAILS that has no account yet but may not register under
+// the current policy, so the first admin can sign up on a closed or
+// invite-only forum. Knowing the address is not enough: the invite only
+// reaches whoever reads its mail. It runs at startup, and sends nothing
+// while an earlier invite is still valid.
+func InviteBootstrapAdmins() {
+	for _, email := range config.List("FORUM_ADMIN_EMAILS") {
+		if _, msg, err := checkRegistrationPolicy(email, ""); err != nil || msg == "" {
+			continue
+		}
+
+		var pending int
+		err := database.DB.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM users WHERE lower(email) = lower(?1))
+			     + (SELECT COUNT(*) FROM invites
+			        WHERE lower(email) = lower(?1) AND revoked_at IS NULL
+			          AND uses < max_uses AND expires_at > ?2)
+		`, email, time.Now()).Scan(&pending)
+		if err != nil {
+			log.Println("Error checking admin invite:", err)
+			continue
+		}
+		if pending > 0 {
+			continue
+		}
+
+		code, hash, err := newToken()
+		if err != nil {
+			log.Println("Error generating admin invite:", err)
+			continue
+		}
+		_, err = database.DB.Exec(`
+			INSERT INTO invites (code_hash, hint, note, email, max_uses, expires_at, created_at)
+			VALUES (?, ?, 'FORUM_ADMIN_EMAILS', ?, 1, ?, ?)
+		`, hash, code[:6], email, time.Now().Add(adminInviteLifetime), time.Now())
+		if err != nil {
+			log.Println("Error storing admin invite:", err)
+			continue
+		}
+
+		link := config.BaseURL() + "/register?invite=" + url.QueryEscape(code)
+		body := "This address is listed as an administrator of the forum.\n\n" +
+			"Create your account here (the link works for 7 days):\n\n" + link + "\n"
+		if err := mailer.Default().Send(email, "Your forum administrator invite", body); err != nil {
+			log.Println("Error sending admin invite:", err)
+			continue
+		}
+		log.Println("📧 Sent an admin invite to", email)
+	}
+}
+
+// consumeInvite records that userID registered with the invite. It runs in
+// the registration transaction, so a used-up invite rolls the signup back.
+func consumeInvite(tx *sql.Tx, inviteID, userID int) (bool, error) {
+	res, err := tx.Exec(`
+		UPDATE invites SET uses = uses + 1
+		WHERE id = ?
+		  AND revoked_at IS NULL
+		  AND (max_uses = 0 OR uses < max_uses)
+		  AND (expires_at IS NULL OR expires_at > ?)
+	`, inviteID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)",
+		inviteID, userID, time.Now(),
+	)
+	return err == nil, err
+}
+
+// inviteUseOptions and inviteExpiryOptions are offered when creating an
+// invite (0 = unlimited / never).
+var (
+	inviteUseOptions    = []int{1, 5, 10, 0}
+	inviteExpiryOptions = []int{1, 7, 30, 0}
+)
+
+// InviteView is one invite as listed on /invites.
+type InviteView struct {
+	ID        int
+	Hint      string
+	Note      string
+	CreatedBy string
+	Uses      int
+	MaxUses   int
+	Expires   string
+	Status    string
+	UsedBy    string
+	CanRevoke bool
+}
+
+// InvitesPageData is passed to invites.html.
+type InvitesPageData struct {
+	User          *SessionUser
+	Policy        string
+	Invites       []InviteView
+	UseOptions    []int
+	ExpiryOptions []int
+	NewLink       string
+	NewCode       string
+	Error         string
+	Message       string
+}
+
+// ---------------------------------------------------------------------------
+// InvitesHandler handles GET + POST /invites (PermInviteUsers)
+// ---------------------------------------------------------------------------
+func InvitesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := GetUserFromRequest(r)
+
+	data := InvitesPageData{
+		User:          user,
+		Policy:        registrationPolicy(),
+		UseOptions:    inviteUseOptions,
+		ExpiryOptions: inviteExpiryOptions,
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "create":
+			data.NewCode, data.Error = createInvite(user, r)
+			if data.Error == "" {
+				data.NewLink = config.BaseURL() + "/register?invite=" + url.QueryEscape(data.NewCode)
+				data.Message = "Invite created. Copy the link now — it won't be shown again."
+			}
+		case "revoke":
+			data.Error = revokeInvite(user, r.FormValue("id"))
+			if data.Error == "" {
+				data.Message = "Invite revoked."
+			}
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadInvites(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "invites.html", data); err != nil {
+		panic(err)
+	}
+}
+
+func createInvite(user *SessionUser, r *http.Request) (string, string) {
+	maxUses, err := strconv.Atoi(r.FormValue("max_uses"))
+	if err != nil || maxUses < 0 {
+		return "", "Choose how many times the invite can be used."
+	}
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || days < 0 {
+		return "", "Choose when the invite expires."
+	}
+
+	var expiresAt sql.NullTime
+	if days > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, days), Valid: true}
+	}
+
+	code, hash, err := newToken()
+	if err != nil {
+		log.Println("Error generating invite:", err)
+		return "", "Could not create the invite."
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO invites (code_hash, hint, note, created_by, max_uses, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, hash, code[:6], strings.TrimSpace(r.FormValue("note")), user.ID, maxUses, expiresAt, time.Now())
+	if err != nil {
+		log.Println("Error storing invite:", err)
+		return "", "Could not create the invite."
+	}
+
+	return code, ""
+}
+
+// revokeInvite stops an invite from being used. Users may revoke their own
+// invites; admins may revoke any.
+func revokeInvite(user *SessionUser, idStr string) string {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "Invite not found."
+	}
+
+	res, err := database.DB.Exec(`
+		UPDATE invites SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL AND (created_by = ? OR ?)
+	`, time.Now(), id, user.ID, user.IsAdmin())
+	if err != nil {
+		log.Println("Error revoking invite:", err)
+		return "Could not revoke the invite."
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "Invite not found."
+	}
+	return ""
+}
+
+// loadInvites lists the user's invites (every invite for admins), with the
+// accounts that registered through each one.
+func loadInvites(user *SessionUser, data *InvitesPageData) error {
+	rows, err := database.DB.Query(`
+		SELECT invites.id, invites.hint, invites.note, COALESCE(creator.username, '[deleted]'),
+		       invites.created_by = ?, invites.uses, invites.max_uses, invites.expires_at,
+		       invites.revoked_at IS NOT NULL,
+		       COALESCE((SELECT GROUP_CONCAT(users.username, ', ')
+		                 FROM invite_uses JOIN users ON users.id = invite_uses.user_id
+		                 WHERE invite_uses.invite_id = invites.id), '')
+		FROM invites
+		LEFT JOIN users AS creator ON creator.id = invites.created_by
+		WHERE invites.created_by = ? OR ?
+		ORDER BY invites.id DESC
+	`, user.ID, user.ID, user.IsAdmin())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var (
+			iv        InviteView
+			own       bool
+			revoked   bool
+			expiresAt sql.NullTime
+		)
+		err := rows.Scan(&iv.ID, &iv.Hint, &iv.Note, &iv.CreatedBy, &own, &iv.Uses, &iv.MaxUses,
+			&expiresAt, &revoked, &iv.UsedBy)
+		if err != nil {
+			return err
+		}
+
+		iv.Expires = "never"
+		if expiresAt.Valid {
+			iv.Expires = expiresAt.Time.Format("2006-01-02 15:04")
+		}
+
+		switch {
+		case revoked:
+			iv.Status = "revoked"
+		case expiresAt.Valid && now.After(expiresAt.Time):
+			iv.Status = "expired"
+		case iv.MaxUses > 0 && iv.Uses >= iv.MaxUses:
+			iv.Status = "used up"
+		default:
+			iv.Status = "active"
+			iv.CanRevoke = own || user.IsAdmin()
+		}
+		data.Invites = append(data.Invites, iv)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// templates is the shared, never-executed template set. Render clones it
+// per request so the csrf helpers can return that request's token.
+var templates = template.Must(
+	template.New("").Funcs(template.FuncMap{
+		"csrfToken": func() string { return "" },
+		"csrfField": func() template.HTML { return "" },
+	}).ParseGlob("templates/*.html"),
+)
+
+// Render executes the named template with the request's CSRF helpers:
+//
+//	{{csrfField}}  hidden <input> to put inside every POST form
+//	{{csrfToken}}  the bare token (e.g. for fetch() headers)
+func Render(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	t, err := templates.Clone()
+	if err != nil {
+		return err
+	}
+
+	token := CSRFToken(r)
+	t.Funcs(template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="csrf_token" value="` +
+				template.HTMLEscapeString(token) + `">`)
+		},
+	})
+
+	return t.ExecuteTemplate(w, name, data)
+}
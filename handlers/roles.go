@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/config"
+	"forum/database"
+)
+
+// Roles, from least to most privileged. Guests are visitors without a
+// session; every registered account is at least a member.
+const (
+	RoleGuest     = "guest"
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles an account can be given, in order.
+var Roles = []string{RoleMember, RoleModerator, RoleAdmin}
+
+var roleRank = map[string]int{
+	RoleGuest:     0,
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Permission is something a handler can require.
+type Permission string
+
+const (
+	// Members
+	PermCreatePost  Permission = "post:create"
+	PermEditPost    Permission = "post:edit" // edit / delete own posts (others' need PermModeratePosts)
+	PermComment     Permission = "comment:create"
+	PermEditComment Permission = "comment:edit" // edit / delete own comments (others' need PermModerateComments)
+	PermVote        Permission = "vote"
+
+	// Moderators
+	PermModeratePosts    Permission = "post:moderate"    // edit / delete others' posts
+	PermModerateComments Permission = "comment:moderate" // edit / delete others' comments
+	PermManageCategories Permission = "category:manage"
+	PermBanUsers         Permission = "user:ban"
+
+	// Admins
+	PermManageUsers    Permission = "user:manage" // change roles
+	PermManageLockouts Permission = "lockout:manage"
+	PermInviteUsers    Permission = "user:invite" // FORUM_INVITE_ROLE can open it to trusted roles
+)
+
+// minRole is the least privileged role holding each permission.
+// Higher roles inherit everything below them.
+var minRole = map[Permission]string{
+	PermCreatePost:       RoleMember,
+	PermEditPost:         RoleMember,
+	PermComment:          RoleMember,
+	PermEditComment:      RoleMember,
+	PermVote:             RoleMember,
+	PermModeratePosts:    RoleModerator,
+	PermModerateComments: RoleModerator,
+	PermManageCategories: RoleModerator,
+	PermBanUsers:         RoleModerator,
+	PermManageUsers:      RoleAdmin,
+	PermManageLockouts:   RoleAdmin,
+	PermInviteUsers:      RoleAdmin,
+}
+
+// requiredRole returns the least privileged role holding p.
+func requiredRole(p Permission) (string, bool) {
+	if p == PermInviteUsers {
+		if role := config.String("FORUM_INVITE_ROLE", RoleAdmin); ValidRole(role) {
+			return role, true
+		}
+	}
+	role, ok := minRole[p]
+	return role, ok
+}
+
+// Can reports whether the user (nil = guest) holds a permission.
+func (u *SessionUser) Can(p Permission) bool {
+	role := RoleGuest
+	if u != nil {
+		role = u.Role
+	}
+	required, ok := requiredRole(p)
+	if !ok || roleRank[role] < roleRank[required] {
+		return false
+	}
+
+	// Access tokens are further limited to the scopes they were given
+	if u != nil && u.Scopes != nil {
+		scope, ok := permissionScopes[p]
+		return ok && u.HasScope(scope)
+	}
+	return true
+}
+
+// IsModerator reports whether the user is a moderator or admin.
+func (u *SessionUser) IsModerator() bool {
+	return u != nil && roleRank[u.Role] >= roleRank[RoleModerator]
+}
+
+// IsAdmin reports whether the user is an admin.
+func (u *SessionUser) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
+// ValidRole reports whether role can be assigned to an account.
+func ValidRole(role string) bool {
+	return role == RoleMember || role == RoleModerator || role == RoleAdmin
+}
+
+type userContextKey struct{}
+
+// Require wraps a handler with a permission check. Guests are sent to
+// the login page, logged-in users without the permission get a 403.
+// The loaded user is kept on the request, so GetUserFromRequest in
+// the wrapped handler does not query the database again.
+// These are the only routes where access tokens may change data.
+func Require(p Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := requestUser(r, true)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil && bearerToken(r) != "" {
+			// Scripts get a status code instead of the login page
+			w.Header().Set("WWW-Authenticate", `Bearer realm="forum"`)
+			http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+			return
+		}
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !user.Can(p) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// BootstrapAdmins promotes the accounts listed in FORUM_ADMIN_EMAILS to
+// admin. It runs at startup and whenever an address is verified, so the
+// first admin can be created by listing their email before (or after)
+// they sign up. Only verified addresses count: anybody can register with
+// an address, only its owner can confirm it.
+func BootstrapAdmins() {
+	for _, email := range config.List("FORUM_ADMIN_EMAILS") {
+		res, err := database.DB.Exec(
+			"UPDATE users SET role = ? WHERE lower(email) = ? AND email_verified = 1 AND role != ?",
+			RoleAdmin, strings.ToLower(email), RoleAdmin,
+		)
+		if err != nil {
+			log.Println("Error promoting admin:", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Println("👑 Promoted to admin:", email)
+		}
+	}
+}
+
+// isBootstrapAdmin reports whether email is listed in FORUM_ADMIN_EMAILS.
+func isBootstrapAdmin(email string) bool {
+	for _, admin := range config.List("FORUM_ADMIN_EMAILS") {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
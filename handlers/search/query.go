@@ -0,0 +1,63 @@
+package search
+
+import "strings"
+
+// matchQuery turns what the user typed into an FTS5 MATCH expression,
+// so that no input is a syntax error:
+//
+//	go templates      both words (in any order)
+//	"go templates"    the phrase
+//	temp*             words starting with "temp" (also "temp"*)
+//
+// Everything is quoted; FTS5 operators (AND, OR, NEAR, column filters...)
+// are searched for as plain words. It returns "" if nothing is left to
+// search for.
+func matchQuery(q string) string {
+	var terms []string
+	add := func(text string, prefix bool) {
+		text = strings.TrimSpace(text)
+		if strings.Trim(text, "*") == "" {
+			return
+		}
+		term := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	for len(q) > 0 {
+		q = strings.TrimLeft(q, " \t\r\n")
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				// Unclosed: the rest is the phrase
+				add(q[1:], false)
+				break
+			}
+			phrase := q[1 : 1+end]
+			q = q[2+end:]
+			prefix := strings.HasPrefix(q, "*")
+			if prefix {
+				q = q[1:]
+			}
+			add(phrase, prefix)
+			continue
+		}
+
+		end := strings.IndexAny(q, " \t\r\n\"")
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		q = q[end:]
+		prefix := strings.HasSuffix(word, "*")
+		add(strings.TrimRight(word, "*"), prefix)
+	}
+
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,254 @@
+package search
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/models"
+	"forum/pagination"
+)
+
+// Result is one matching post or comment.
+type Result struct {
+	PostID    int
+	CommentID int           // 0 for posts
+	Title     template.HTML // the post's title, matches highlighted for posts
+	Snippet   template.HTML // the text around the matches, highlighted
+	Username  string
+	CreatedAt string
+	URL       string
+}
+
+type SearchPageData struct {
+	User       *auth.SessionUser
+	Available  bool
+	Query      string
+	Type       string // "posts" or "comments"
+	Author     string
+	CategoryID int
+	From, To   string // YYYY-MM-DD, both included
+	Categories []models.Category
+	Searched   bool
+	Results    []Result
+	Page       pagination.Page
+	Error      string
+}
+
+// FTS5 marks matches with control characters rather than tags, so that
+// the text around them can still be escaped (a stray one in a post only
+// adds a harmless <mark>).
+const (
+	markStart = "\x01"
+	markEnd   = "\x02"
+)
+
+// -----------------------------------------------------------
+// SearchHandler — GET /search?q=...&type=posts|comments
+// &author=NAME&category=ID&from=DATE&to=DATE
+// -----------------------------------------------------------
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := auth.GetUserFromRequest(r)
+	q := r.URL.Query()
+	data := SearchPageData{
+		User:      user,
+		Available: database.SearchAvailable,
+		Query:     strings.TrimSpace(q.Get("q")),
+		Type:      q.Get("type"),
+		Author:    strings.TrimSpace(q.Get("author")),
+		From:      q.Get("from"),
+		To:        q.Get("to"),
+	}
+	if data.Type != "comments" {
+		data.Type = "posts"
+	}
+	data.CategoryID, _ = strconv.Atoi(q.Get("category"))
+
+	var err error
+	data.Categories, err = loadCategories()
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	switch {
+	case !data.Available:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case data.Query == "":
+		// Just the form
+	case !validDate(data.From) || !validDate(data.To):
+		data.Error = "Dates must look like 2024-01-31."
+	default:
+		req, err := pagination.FromRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid page cursor", http.StatusBadRequest)
+			return
+		}
+		data.Searched = true
+		data.Results, data.Page, err = run(data, req)
+		if err != nil {
+			log.Println("Error searching:", err)
+			data.Error = "Search failed. Please try again."
+		}
+	}
+
+	if err := auth.Render(w, r, "search.html", data); err != nil {
+		// Template error → panic → 500 page
+		panic(err)
+	}
+}
+
+// run executes the search described by data, best matches first.
+func run(data SearchPageData, req pagination.Request) ([]Result, pagination.Page, error) {
+	match := matchQuery(data.Query)
+	if match == "" {
+		return nil, pagination.Page{}, nil
+	}
+
+	// Posts weigh matches in the title ten times those in the content.
+	// bm25() is lower for better matches; the feed key is its opposite.
+	var table, rank, columns, joins string
+	var where []string
+	if data.Type == "comments" {
+		table, rank = "comments", "-bm25(comments_fts)"
+		columns = `comments.id, comments.post_id, posts.title,
+			snippet(comments_fts, 0, char(1), char(2), '…', 24)`
+		joins = `JOIN comments ON comments.id = comments_fts.rowid
+			JOIN posts ON posts.id = comments.post_id
+			JOIN users ON users.id = comments.user_id`
+		where = append(where, "comments_fts MATCH ?", "comments.deleted_at IS NULL")
+	} else {
+		table, rank = "posts", "-bm25(posts_fts, 10.0, 1.0)"
+		columns = `0, posts.id,
+			highlight(posts_fts, 0, char(1), char(2)),
+			snippet(posts_fts, 1, char(1), char(2), '…', 24)`
+		joins = `JOIN posts ON posts.id = posts_fts.rowid
+			JOIN users ON users.id = posts.user_id`
+		where = append(where, "posts_fts MATCH ?")
+	}
+	args := []any{match}
+	where = append(where, "posts.deleted_at IS NULL")
+
+	if data.Author != "" {
+		where = append(where, "users.username = ? COLLATE NOCASE")
+		args = append(args, data.Author)
+	}
+	if data.CategoryID > 0 {
+		where = append(where, `EXISTS (SELECT 1 FROM post_categories
+			WHERE post_categories.post_id = posts.id AND post_categories.category_id = ?)`)
+		args = append(args, data.CategoryID)
+	}
+	if data.From != "" {
+		where = append(where, table+".created_at >= ?")
+		args = append(args, data.From)
+	}
+	if data.To != "" {
+		where = append(where, table+".created_at < date(?, '+1 day')")
+		args = append(args, data.To)
+	}
+
+	cond, keyArgs, orderLimit := req.Keyset(rank, table+".id")
+	where = append(where, cond)
+	args = append(args, keyArgs...)
+
+	rows, err := database.DB.Query(`
+		SELECT `+columns+`,
+		       users.username,
+		       strftime('%Y-%m-%d %H:%M:%S', `+table+`.created_at),
+		       `+rank+`
+		FROM `+table+`_fts
+		`+joins+`
+		WHERE `+strings.Join(where, " AND ")+`
+		`+orderLimit, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	type rawResult struct {
+		Result
+		title, snippet string
+		rank           float64
+	}
+	var raw []rawResult
+	for rows.Next() {
+		var rr rawResult
+		err := rows.Scan(&rr.CommentID, &rr.PostID, &rr.title, &rr.snippet,
+			&rr.Username, &rr.CreatedAt, &rr.rank)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		raw = append(raw, rr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	raw, page := pagination.Finish(req, raw, func(rr rawResult) pagination.Cursor {
+		id := rr.PostID
+		if rr.CommentID != 0 {
+			id = rr.CommentID
+		}
+		return pagination.NewCursor(rr.rank, id)
+	})
+
+	results := make([]Result, 0, len(raw))
+	for _, rr := range raw {
+		res := rr.Result
+		res.Title = highlighted(rr.title)
+		res.Snippet = highlighted(rr.snippet)
+		res.URL = "/post?id=" + strconv.Itoa(res.PostID)
+		if res.CommentID != 0 {
+			// The thread view shows the comment however deep it is
+			c := strconv.Itoa(res.CommentID)
+			res.URL += "&thread=" + c + "#comment-" + c
+		}
+		results = append(results, res)
+	}
+	return results, page, nil
+}
+
+// highlighted escapes text marked by FTS5 and turns the marks into <mark>.
+func highlighted(s string) template.HTML {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	s = strings.ReplaceAll(s, markEnd, "</mark>")
+	return template.HTML(s)
+}
+
+func validDate(s string) bool {
+	if s == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+func loadCategories() ([]models.Category, error) {
+	rows, err := database.DB.Query("SELECT id, name FROM categories ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cats []models.Category
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			return nil, err
+		}
+		cats = append(cats, c)
+	}
+	return cats, rows.Err()
+}
@@ -3,47 +3,172 @@ package auth
 import (
 	"database/sql"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
+	"forum/config"
 	"forum/database"
+
+	"github.com/google/uuid"
 )
 
 // SessionUser represents the logged-in user loaded from a session.
 type SessionUser struct {
-	ID       int
-	Username string
-	Email    string
+	ID            int
+	Username      string
+	Email         string
+	EmailVerified bool
+	Role          string
+
+	// Scopes is set when the request was authenticated with a personal
+	// access token instead of a session cookie (see access_tokens.go).
+	Scopes []string
 }
 
-// GetUserFromRequest checks the session cookie and loads the user if logged in.
-func GetUserFromRequest(r *http.Request) (*SessionUser, error) {
-	// Get cookie
+// sessionTTL is how long a new session stays valid.
+const sessionTTL = 24 * time.Hour
+
+// lastSeenInterval limits how often last_seen_at is written,
+// so browsing does not cause a DB write on every request.
+const lastSeenInterval = time.Minute
+
+// Session policies (FORUM_SESSION_POLICY):
+//   - "multi"  (default) a user may be logged in on several devices at once
+//   - "single" logging in ends every other session of that user
+const (
+	SessionPolicyMulti  = "multi"
+	SessionPolicySingle = "single"
+)
+
+func sessionPolicy() string {
+	return config.String("FORUM_SESSION_POLICY", SessionPolicyMulti)
+}
+
+// CreateSession stores a new session for userID and sets the session cookie.
+func CreateSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	// Opt-in: only one active session per user
+	if sessionPolicy() == SessionPolicySingle {
+		if _, err := database.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+	}
+
+	sessionID := uuid.New().String()
+	now := time.Now()
+	expires := now.Add(sessionTTL)
+
+	csrfToken, _, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO sessions (id, user_id, expires_at, user_agent, ip_address, created_at, last_seen_at, csrf_token)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, userID, expires, r.UserAgent(), ClientIP(r), now, now, csrfToken)
+	if err != nil {
+		return err
+	}
+
+	// Set session cookie
+	cookie := http.Cookie{
+		Name:     "session_id",
+		Value:    sessionID,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Change to true if HTTPS enabled
+		// Lax: not sent on cross-site POSTs, still sent when following links
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// ClientIP returns the remote address of the request without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// currentSessionID returns the session cookie value, or "" if there is none.
+func currentSessionID(r *http.Request) string {
 	cookie, err := r.Cookie("session_id")
 	if err != nil {
-		// No cookie = not logged in
-		return nil, nil
+		return ""
 	}
+	return cookie.Value
+}
+
+// clearSessionCookie expires the session cookie in the browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	expiredCookie := http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &expiredCookie)
+}
 
-	sessionID := cookie.Value
+// DeleteUserSessions ends every session of a user.
+func DeleteUserSessions(userID int) error {
+	_, err := database.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// GetUserFromRequest checks the session cookie (or an access token) and
+// loads the user if logged in.
+func GetUserFromRequest(r *http.Request) (*SessionUser, error) {
+	// Already loaded by Require
+	if user, ok := r.Context().Value(userContextKey{}).(*SessionUser); ok {
+		return user, nil
+	}
+
+	return requestUser(r, false)
+}
+
+// requestUser loads the user behind the session cookie or, without one, the
+// "Authorization: Bearer" access token. Tokens are only looked at on routes
+// that accept them: with tokenWrites, set by Require for the permissions
+// tokens may use, or for reads on routes wrapped in AllowTokenReads.
+func requestUser(r *http.Request, tokenWrites bool) (*SessionUser, error) {
+	// Get cookie
+	sessionID := currentSessionID(r)
 	if sessionID == "" {
-		return nil, nil
+		// No cookie = not logged in, unless a script sent a token here
+		if !tokenWrites && !tokenReadsAllowed(r) {
+			return nil, nil
+		}
+		return userFromAccessToken(r, tokenWrites)
 	}
 
 	// Look up session and user in DB
 	var (
-		userID    int
-		username  string
-		email     string
-		expiresAt time.Time
+		userID        int
+		username      string
+		email         string
+		emailVerified bool
+		role          string
+		expiresAt     time.Time
+		lastSeenAt    sql.NullTime
 	)
 
-	err = database.DB.QueryRow(`
-		SELECT users.id, users.username, users.email, sessions.expires_at
+	err := database.DB.QueryRow(`
+		SELECT users.id, users.username, users.email, users.email_verified, users.role,
+		       sessions.expires_at, sessions.last_seen_at
 		FROM sessions
 		JOIN users ON sessions.user_id = users.id
 		WHERE sessions.id = ?
-	`, sessionID).Scan(&userID, &username, &email, &expiresAt)
+	`, sessionID).Scan(&userID, &username, &email, &emailVerified, &role, &expiresAt, &lastSeenAt)
 
 	if err == sql.ErrNoRows {
 		// Session not found or user deleted
@@ -55,7 +180,8 @@ func GetUserFromRequest(r *http.Request) (*SessionUser, error) {
 	}
 
 	// Check if session expired
-	if time.Now().After(expiresAt) {
+	now := time.Now()
+	if now.After(expiresAt) {
 		// Optionally: clean up expired session
 		_, delErr := database.DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
 		if delErr != nil {
@@ -64,20 +190,34 @@ func GetUserFromRequest(r *http.Request) (*SessionUser, error) {
 		return nil, nil
 	}
 
+	// Keep "last seen" roughly up to date for the sessions page
+	if !lastSeenAt.Valid || now.Sub(lastSeenAt.Time) > lastSeenInterval {
+		_, updErr := database.DB.Exec(
+			"UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, sessionID,
+		)
+		if updErr != nil {
+			log.Println("Error updating session last seen:", updErr)
+		}
+	}
+
 	return &SessionUser{
-		ID:       userID,
-		Username: username,
-		Email:    email,
+		ID:            userID,
+		Username:      username,
+		Email:         email,
+		EmailVerified: emailVerified,
+		Role:          role,
 	}, nil
 }
 
-// LogoutHandler destroys the session and clears the cookie.
+// LogoutHandler handles POST /logout: destroys the session and clears the cookie.
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Get cookie (if no cookie, just redirect)
-	cookie, err := r.Cookie("session_id")
-	if err == nil && cookie.Value != "" {
-		sessionID := cookie.Value
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	// Get cookie (if no cookie, just redirect)
+	if sessionID := currentSessionID(r); sessionID != "" {
 		// Delete from DB
 		_, err := database.DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
 		if err != nil {
@@ -85,15 +225,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Expire the cookie in the browser
-		expiredCookie := http.Cookie{
-			Name:     "session_id",
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Unix(0, 0),
-			MaxAge:   -1,
-			HttpOnly: true,
-		}
-		http.SetCookie(w, &expiredCookie)
+		clearSessionCookie(w)
 	}
 
 	// Redirect to home after logout
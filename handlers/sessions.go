@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"forum/database"
+)
+
+// SessionView is one row on the "/sessions" page.
+// Handle identifies the session in forms without exposing the session ID.
+type SessionView struct {
+	Handle     string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  string
+	LastSeenAt string
+	Current    bool
+}
+
+type SessionsPageData struct {
+	User     *SessionUser
+	Sessions []SessionView
+}
+
+// sessionHandle derives a stable public identifier from a session ID.
+func sessionHandle(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SessionsHandler lists the active sessions of the logged-in user.
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, user_agent, ip_address, created_at, last_seen_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, user.ID, time.Now())
+	if err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+	defer rows.Close()
+
+	current := currentSessionID(r)
+
+	var sessions []SessionView
+	for rows.Next() {
+		var (
+			id         string
+			sv         SessionView
+			createdAt  time.Time
+			lastSeenAt time.Time
+		)
+		if err := rows.Scan(&id, &sv.UserAgent, &sv.IPAddress, &createdAt, &lastSeenAt); err != nil {
+			log.Println("Error scanning session:", err)
+			continue
+		}
+		sv.Handle = sessionHandle(id)
+		sv.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		sv.LastSeenAt = lastSeenAt.Format("2006-01-02 15:04:05")
+		sv.Current = id == current
+		sessions = append(sessions, sv)
+	}
+
+	data := SessionsPageData{
+		User:     user,
+		Sessions: sessions,
+	}
+
+	if err := Render(w, r, "sessions.html", data); err != nil {
+		// Template error → panic → 500 page
+		panic(err)
+	}
+}
+
+// RevokeSessionHandler handles POST /sessions/revoke and ends one session.
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	handle := r.FormValue("session")
+	if handle == "" {
+		http.Error(w, "Missing session", http.StatusBadRequest)
+		return
+	}
+
+	// Find the matching session among this user's own sessions only
+	rows, err := database.DB.Query("SELECT id FROM sessions WHERE user_id = ?", user.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	var target string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil && sessionHandle(id) == handle {
+			target = id
+		}
+	}
+	rows.Close()
+
+	if target == "" {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM sessions WHERE id = ?", target); err != nil {
+		log.Println("Error revoking session:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Revoking the current session is the same as logging out
+	if target == currentSessionID(r) {
+		clearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+// LogoutEverywhereHandler handles POST /logout-all and ends every session of the user.
+func LogoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := DeleteUserSessions(user.ID); err != nil {
+		log.Println("Error deleting sessions:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/database"
+	"forum/mailer"
+	pw "forum/password"
+)
+
+// SettingsPageData is passed to settings.html.
+type SettingsPageData struct {
+	User         *SessionUser
+	PendingEmail string
+	HasPassword  bool
+	History      []AccountChange
+	Error        string
+	Message      string
+}
+
+// AccountChange is one row of the account history shown on the settings page.
+type AccountChange struct {
+	Action    string
+	OldValue  string
+	NewValue  string
+	IPAddress string
+	CreatedAt string
+}
+
+// recordAccountChange appends an entry to the user's account history.
+func recordAccountChange(r *http.Request, userID int, action, oldValue, newValue string) {
+	_, err := database.DB.Exec(`
+		INSERT INTO account_history (user_id, action, old_value, new_value, ip_address)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, action, oldValue, newValue, ClientIP(r))
+	if err != nil {
+		log.Println("Error recording account change:", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// SettingsHandler handles GET + POST /settings
+// ---------------------------------------------------------------------------
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := SettingsPageData{User: user}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "username":
+			data.Message, data.Error = changeUsername(r, user, strings.TrimSpace(r.FormValue("username")))
+		case "email":
+			data.Message, data.Error = changeEmail(r, user,
+				r.FormValue("current_password"), strings.TrimSpace(r.FormValue("email")))
+		case "password":
+			data.Message, data.Error = changePassword(r, user,
+				r.FormValue("current_password"), r.FormValue("new_password"), r.FormValue("confirm_password"))
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadSettings(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "settings.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// changeUsername applies the same checks as registration. It returns a
+// success message or an error message for the page.
+func changeUsername(r *http.Request, user *SessionUser, username string) (string, string) {
+	if username == "" {
+		return "", "Username is required."
+	}
+	if username == user.Username {
+		return "", "That is already your username."
+	}
+
+	// Checked in the same statement: databases created before the schema
+	// had UNIQUE(username) don't enforce it
+	res, err := database.DB.Exec(`
+		UPDATE users SET username = ?
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM users WHERE username = ?)
+	`, username, user.ID, username)
+	if err != nil {
+		log.Println("Error changing username:", err)
+		return "", "Could not change your username."
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "Username is already taken."
+	}
+
+	recordAccountChange(r, user.ID, "username", user.Username, username)
+	user.Username = username
+	return "Your username has been changed.", ""
+}
+
+// changeEmail starts an email change: the new address must be confirmed
+// through the link sent to it before users.email is updated. Whoever
+// controls the email can reset the password, so the change needs the
+// current password like changePassword.
+func changeEmail(r *http.Request, user *SessionUser, current, email string) (string, string) {
+	if msg, err := confirmIdentity(r, user, current); err != nil {
+		log.Println("Error confirming password:", err)
+		return "", "Could not change your email address."
+	} else if msg != "" {
+		return "", msg
+	}
+
+	if email == "" {
+		return "", "Email is required."
+	}
+	if email == user.Email {
+		return "", "That is already your email address."
+	}
+
+	var exists int
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error checking email:", err)
+		return "", "Could not change your email address."
+	}
+	if exists > 0 {
+		return "", "Email is already registered."
+	}
+
+	// Only the latest request stays valid
+	_, err = database.DB.Exec(
+		"DELETE FROM email_verifications WHERE user_id = ? AND email != ?", user.ID, user.Email,
+	)
+	if err != nil {
+		log.Println("Error clearing pending email changes:", err)
+	}
+
+	if err := SendEmailChangeVerification(user.ID, email); err != nil {
+		log.Println("Error sending email change verification:", err)
+		return "", "Could not send the confirmation email."
+	}
+
+	// Let the current address know, in case the account was taken over
+	body := "A change of the email address on your forum account to " + email + " was requested.\n\n" +
+		"The change only happens once the new address is confirmed. " +
+		"If you didn't ask for it, change your password and log out your other sessions."
+	if err := mailer.Default().Send(user.Email, "Your forum email address is changing", body); err != nil {
+		log.Println("Error notifying old email address:", err)
+	}
+
+	recordAccountChange(r, user.ID, "email requested", user.Email, email)
+	return "We sent a confirmation link to " + email + ". Your address changes once you open it.", ""
+}
+
+// changePassword checks the current password, stores the new one and ends
+// every other session of the user.
+func changePassword(r *http.Request, user *SessionUser, current, password, confirm string) (string, string) {
+	if msg, err := confirmIdentity(r, user, current); err != nil {
+		log.Println("Error confirming password:", err)
+		return "", "Could not change your password."
+	} else if msg != "" {
+		return "", msg
+	}
+
+	if password != confirm {
+		return "", "The new passwords don't match."
+	}
+	if err := pw.Validate(password); err != nil {
+		return "", err.Error()
+	}
+
+	hashed, err := pw.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		return "", "Could not change your password."
+	}
+
+	_, err = database.DB.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, user.ID)
+	if err != nil {
+		log.Println("Error changing password:", err)
+		return "", "Could not change your password."
+	}
+
+	// Keep this browser logged in, end everything else
+	_, err = database.DB.Exec(
+		"DELETE FROM sessions WHERE user_id = ? AND id != ?", user.ID, currentSessionID(r),
+	)
+	if err != nil {
+		log.Println("Error deleting other sessions:", err)
+	}
+
+	// Never store password hashes in the history
+	recordAccountChange(r, user.ID, "password", "", "")
+	return "Your password has been changed. Your other sessions were logged out.", ""
+}
+
+// reauthWindow is how recently an account without a password must have
+// logged in to make sensitive changes.
+const reauthWindow = 10 * time.Minute
+
+// confirmIdentity checks the password confirming a sensitive change and
+// returns a message for the user if the change must be refused. Wrong
+// guesses count against the same lockout as the login form, so a stolen
+// session can't be used to find the password. Accounts without a password
+// (external or sign-in-link logins) have nothing to type: their session
+// must have started within reauthWindow instead.
+func confirmIdentity(r *http.Request, user *SessionUser, current string) (string, error) {
+	emailKey, ipKey := loginEmailKey(user.Email), loginIPKey(ClientIP(r))
+	wait, err := lockedFor(emailKey, ipKey)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return lockoutMessage(wait), nil
+	}
+
+	var hash string
+	err = database.DB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&hash)
+	if err != nil {
+		return "", err
+	}
+
+	if hash == "" {
+		var createdAt time.Time
+		err := database.DB.QueryRow(
+			"SELECT created_at FROM sessions WHERE id = ?", currentSessionID(r),
+		).Scan(&createdAt)
+		if err == sql.ErrNoRows || (err == nil && time.Since(createdAt) > reauthWindow) {
+			return "For your security, log out and log in again, then retry within 10 minutes.", nil
+		}
+		return "", err
+	}
+
+	ok, _, err := pw.Verify(current, hash)
+	if err != nil {
+		log.Println("Error verifying password:", err)
+	}
+	if !ok {
+		recordLoginFailure(emailKey, ipKey)
+		return "Current password is incorrect.", nil
+	}
+	if err := clearThrottle(emailKey); err != nil {
+		log.Println("Error clearing lockout:", err)
+	}
+	return "", nil
+}
+
+func loadSettings(user *SessionUser, data *SettingsPageData) error {
+	var hash string
+	err := database.DB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+	data.HasPassword = hash != ""
+
+	// Latest email change still waiting for confirmation
+	err = database.DB.QueryRow(`
+		SELECT email FROM email_verifications
+		WHERE user_id = ? AND email != ?
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, user.ID, user.Email).Scan(&data.PendingEmail)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT action, old_value, new_value, ip_address, strftime('%Y-%m-%d %H:%M:%S', created_at)
+		FROM account_history
+		WHERE user_id = ?
+		ORDER BY id DESC
+		LIMIT 50
+	`, user.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c AccountChange
+		if err := rows.Scan(&c.Action, &c.OldValue, &c.NewValue, &c.IPAddress, &c.CreatedAt); err != nil {
+			return err
+		}
+		data.History = append(data.History, c)
+	}
+	return rows.Err()
+}
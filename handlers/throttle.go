@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+)
+
+// throttleRule describes when repeated attempts start being delayed.
+// After freeAttempts attempts within window, every further attempt locks
+// the key for baseDelay, doubling each time, up to maxDelay.
+type throttleRule struct {
+	freeAttempts int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	window       time.Duration
+}
+
+func loginAccountRule() throttleRule {
+	return throttleRule{
+		freeAttempts: config.Int("FORUM_LOGIN_MAX_ATTEMPTS", 5),
+		baseDelay:    config.Duration("FORUM_LOCKOUT_BASE", 30*time.Second),
+		maxDelay:     config.Duration("FORUM_LOCKOUT_MAX", time.Hour),
+		window:       config.Duration("FORUM_LOCKOUT_WINDOW", 24*time.Hour),
+	}
+}
+
+// An IP may legitimately serve many users (office, NAT), so it gets more slack.
+func loginIPRule() throttleRule {
+	r := loginAccountRule()
+	r.freeAttempts = config.Int("FORUM_LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+	return r
+}
+
+func registerIPRule() throttleRule {
+	return throttleRule{
+		freeAttempts: config.Int("FORUM_REGISTER_MAX_PER_IP", 3),
+		baseDelay:    config.Duration("FORUM_REGISTER_LOCKOUT_BASE", 10*time.Minute),
+		maxDelay:     config.Duration("FORUM_LOCKOUT_MAX", time.Hour),
+		window:       time.Hour,
+	}
+}
+
+// Throttle keys
+func loginEmailKey(email string) string { return "login:email:" + strings.ToLower(email) }
+func loginIPKey(ip string) string       { return "login:ip:" + ip }
+func registerIPKey(ip string) string    { return "register:ip:" + ip }
+
+// lockedFor returns how long the longest-locked key remains locked (0 = free).
+func lockedFor(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var longest time.Duration
+
+	for _, key := range keys {
+		var until sql.NullTime
+		err := database.DB.QueryRow(
+			"SELECT locked_until FROM auth_throttle WHERE key = ?", key,
+		).Scan(&until)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if until.Valid && until.Time.After(now) && until.Time.Sub(now) > longest {
+			longest = until.Time.Sub(now)
+		}
+	}
+	return longest, nil
+}
+
+// recordAttempt counts one attempt against key (a failed login, or any
+// registration) and locks the key when the rule says so.
+func recordAttempt(key string, rule throttleRule) error {
+	now := time.Now()
+
+	var (
+		attempts int
+		last     time.Time
+	)
+	err := database.DB.QueryRow(
+		"SELECT attempts, last_attempt_at FROM auth_throttle WHERE key = ?", key,
+	).Scan(&attempts, &last)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Old attempts are forgotten
+	if err == sql.ErrNoRows || now.Sub(last) > rule.window {
+		attempts = 0
+	}
+	attempts++
+
+	var lockedUntil any // NULL unless locked
+	if over := attempts - rule.freeAttempts; over > 0 {
+		delay := rule.baseDelay
+		for i := 1; i < over && delay < rule.maxDelay; i++ {
+			delay *= 2
+		}
+		if delay > rule.maxDelay {
+			delay = rule.maxDelay
+		}
+		lockedUntil = now.Add(delay)
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO auth_throttle (key, attempts, last_attempt_at, locked_until)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			attempts = excluded.attempts,
+			last_attempt_at = excluded.last_attempt_at,
+			locked_until = excluded.locked_until
+	`, key, attempts, now, lockedUntil)
+	return err
+}
+
+// clearThrottle forgets every attempt recorded for key.
+func clearThrottle(key string) error {
+	_, err := database.DB.Exec("DELETE FROM auth_throttle WHERE key = ?", key)
+	return err
+}
+
+// lockoutMessage formats a user-facing "try again later" message.
+func lockoutMessage(wait time.Duration) string {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return "Too many attempts. Please try again in " + wait.String() + "."
+}
@@ -0,0 +1,672 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	pw "forum/password"
+	"forum/totp"
+)
+
+const (
+	// challengeTTL is how long a user has to enter the second factor.
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts wrong codes end the login attempt.
+	maxChallengeAttempts = 5
+	// recoveryCodeCount codes are generated per batch.
+	recoveryCodeCount = 10
+)
+
+// TwoFactorEnabled reports whether the user has a confirmed authenticator.
+func TwoFactorEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := database.DB.QueryRow(
+		"SELECT enabled FROM totp_secrets WHERE user_id = ?", userID,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// ------------------------------------------------------------
+// LOGIN — SECOND STEP
+// ------------------------------------------------------------
+
+// startTwoFactorChallenge remembers that userID passed the password check
+// and sends the browser to the second login step.
+func startTwoFactorChallenge(w http.ResponseWriter, r *http.Request, userID int) error {
+	if err := issueTwoFactorChallenge(w, userID); err != nil {
+		return err
+	}
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+	return nil
+}
+
+// issueTwoFactorChallenge stores the pending login and sets its cookie,
+// leaving it to the caller to send the browser to /login/2fa.
+func issueTwoFactorChallenge(w http.ResponseWriter, userID int) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = database.DB.Exec("DELETE FROM login_challenges WHERE expires_at < ?", now)
+	if err != nil {
+		log.Println("Error cleaning login challenges:", err)
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO login_challenges (token_hash, user_id, expires_at)
+		VALUES (?, ?, ?)
+	`, hash, userID, now.Add(challengeTTL))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "login_challenge",
+		Value:    token,
+		Path:     "/login",
+		Expires:  now.Add(challengeTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// TwoFactorLoginHandler handles GET + POST for /login/2fa.
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("login_challenge")
+	if err != nil || cookie.Value == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	challengeHash := hashToken(cookie.Value)
+
+	var (
+		userID    int
+		attempts  int
+		expiresAt time.Time
+	)
+	err = database.DB.QueryRow(`
+		SELECT user_id, attempts, expires_at
+		FROM login_challenges
+		WHERE token_hash = ?
+	`, challengeHash).Scan(&userID, &attempts, &expiresAt)
+	if err != nil || time.Now().After(expiresAt) || attempts >= maxChallengeAttempts {
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("Error loading login challenge:", err)
+		}
+		endChallenge(w, challengeHash)
+		renderLogin(w, r, "Your login attempt expired. Please log in again.")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		Render(w, r, "login_2fa.html", nil)
+
+	case "POST":
+		// Codes count against the same lockout as passwords, so logging in
+		// again doesn't buy a password holder a fresh set of guesses
+		emailKey, err := accountLoginKey(userID)
+		if err != nil {
+			log.Println("Error loading account for lockout:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		ipKey := loginIPKey(ClientIP(r))
+		wait, err := lockedFor(emailKey, ipKey)
+		if err != nil {
+			log.Println("Error checking lockout:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			Render(w, r, "login_2fa.html", lockoutMessage(wait))
+			return
+		}
+
+		code := r.FormValue("code")
+		recovery := r.FormValue("recovery_code")
+
+		var ok bool
+		switch {
+		case code != "":
+			ok, err = checkTOTP(userID, code)
+		case recovery != "":
+			ok, err = useRecoveryCode(userID, recovery)
+		}
+		if err != nil {
+			log.Println("Error checking second factor:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !ok {
+			_, err := database.DB.Exec(
+				"UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?", challengeHash,
+			)
+			if err != nil {
+				log.Println("Error counting 2FA attempt:", err)
+			}
+			recordLoginFailure(emailKey, ipKey)
+			Render(w, r, "login_2fa.html", "Invalid code.")
+			return
+		}
+
+		endChallenge(w, challengeHash)
+		startSession(w, r, userID)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// endChallenge deletes a login challenge and its cookie.
+func endChallenge(w http.ResponseWriter, challengeHash string) {
+	_, err := database.DB.Exec("DELETE FROM login_challenges WHERE token_hash = ?", challengeHash)
+	if err != nil {
+		log.Println("Error deleting login challenge:", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "login_challenge",
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// checkTOTP validates a code for an enabled authenticator and
+// remembers its time step so the same code cannot be replayed.
+func checkTOTP(userID int, code string) (bool, error) {
+	var (
+		secret   string
+		lastStep int64
+	)
+	err := database.DB.QueryRow(`
+		SELECT secret, last_used_step FROM totp_secrets
+		WHERE user_id = ? AND enabled = 1
+	`, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if secret, err = openTOTPSecret(userID, secret); err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE totp_secrets SET last_used_step = ? WHERE user_id = ?", step, userID,
+	)
+	return err == nil, err
+}
+
+// useRecoveryCode consumes one unused recovery code if it matches.
+func useRecoveryCode(userID int, code string) (bool, error) {
+	code = normalizeRecoveryCode(code)
+
+	rows, err := database.DB.Query(`
+		SELECT id, code_hash FROM recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	matchID := 0
+	for rows.Next() {
+		var (
+			id   int
+			hash string
+		)
+		if err := rows.Scan(&id, &hash); err != nil {
+			continue
+		}
+		if ok, _, _ := pw.Verify(code, hash); ok {
+			matchID = id
+			break
+		}
+	}
+	rows.Close()
+
+	if matchID == 0 {
+		return false, nil
+	}
+
+	res, err := database.DB.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now(), matchID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ------------------------------------------------------------
+// RECOVERY CODES
+// ------------------------------------------------------------
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", "")
+}
+
+// generateRecoveryCodes replaces all recovery codes of a user and returns
+// the new plain codes. They are only ever shown once.
+func generateRecoveryCodes(userID int) ([]string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b)) // 8 chars
+		hash, err := pw.Hash(raw)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hash)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, h,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// ------------------------------------------------------------
+// SETTINGS — ENROL / DISABLE
+// ------------------------------------------------------------
+
+type TwoFactorPageData struct {
+	User          *SessionUser
+	Enabled       bool
+	HasPassword   bool
+	Secret        string
+	URI           template.URL // otpauth:// is not on html/template's safe scheme list
+	RecoveryCodes []string
+	CodesLeft     int
+	Error         string
+	Message       string
+}
+
+// TwoFactorSettingsHandler handles GET + POST for /settings/2fa.
+func TwoFactorSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromRequest(r)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := TwoFactorPageData{User: user}
+
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "enable":
+			data.RecoveryCodes, data.Error = enableTwoFactor(user, r.FormValue("code"))
+			if data.Error == "" {
+				data.Message = "Two-factor authentication is on."
+			}
+		case "disable":
+			data.Error = disableTwoFactor(r, user, r.FormValue("password"), r.FormValue("code"))
+			if data.Error == "" {
+				data.Message = "Two-factor authentication is off."
+			}
+		case "regenerate":
+			data.RecoveryCodes, data.Error = regenerateCodes(user, r.FormValue("code"))
+			if data.Error == "" {
+				data.Message = "New recovery codes generated. The old ones no longer work."
+			}
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	} else if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := loadTwoFactorState(user, &data); err != nil {
+		// Internal DB error → panic → 500 page
+		panic(err)
+	}
+
+	if err := Render(w, r, "settings_2fa.html", data); err != nil {
+		panic(err)
+	}
+}
+
+// loadTwoFactorState fills the page data, creating a pending (not yet
+// enabled) secret the first time a user opens the page.
+func loadTwoFactorState(user *SessionUser, data *TwoFactorPageData) error {
+	var secret string
+	err := database.DB.QueryRow(
+		"SELECT secret, enabled FROM totp_secrets WHERE user_id = ?", user.ID,
+	).Scan(&secret, &data.Enabled)
+
+	if err == sql.ErrNoRows {
+		var sealed string
+		secret, err = totp.GenerateSecret()
+		if err == nil {
+			sealed, err = sealTOTPSecret(user.ID, secret)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = database.DB.Exec(
+			"INSERT INTO totp_secrets (user_id, secret, enabled) VALUES (?, ?, 0)", user.ID, sealed,
+		)
+	} else if err == nil && !data.Enabled {
+		secret, err = openTOTPSecret(user.ID, secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	err = database.DB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+	data.HasPassword = hash != ""
+
+	if data.Enabled {
+		return database.DB.QueryRow(`
+			SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL
+		`, user.ID).Scan(&data.CodesLeft)
+	}
+
+	data.Secret = secret
+	data.URI = template.URL(totp.URI(config.String("FORUM_TOTP_ISSUER", "Forum"), user.Email, secret))
+	return nil
+}
+
+func enableTwoFactor(user *SessionUser, code string) ([]string, string) {
+	var secret string
+	err := database.DB.QueryRow(
+		"SELECT secret FROM totp_secrets WHERE user_id = ? AND enabled = 0", user.ID,
+	).Scan(&secret)
+	if err != nil {
+		return nil, "Two-factor authentication is already on, or setup was not started."
+	}
+	if secret, err = openTOTPSecret(user.ID, secret); err != nil {
+		log.Println("Error reading TOTP secret:", err)
+		return nil, "Could not enable two-factor authentication."
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, "That code didn't match. Check the time on your phone and try again."
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE totp_secrets SET enabled = 1, last_used_step = ? WHERE user_id = ?", step, user.ID,
+	)
+	if err != nil {
+		log.Println("Error enabling 2FA:", err)
+		return nil, "Could not enable two-factor authentication."
+	}
+
+	codes, err := generateRecoveryCodes(user.ID)
+	if err != nil {
+		log.Println("Error generating recovery codes:", err)
+		return nil, "Could not generate recovery codes."
+	}
+	return codes, ""
+}
+
+// disableTwoFactor asks for the password, or for an authenticator or
+// recovery code when the account has none (external or sign-in-link
+// logins), so a stolen session alone can't remove the second factor.
+func disableTwoFactor(r *http.Request, user *SessionUser, password, code string) string {
+	msg, err := confirmTwoFactorChange(r, user, password, code)
+	if err != nil {
+		log.Println("Error confirming 2FA change:", err)
+		return "Could not disable two-factor authentication."
+	}
+	if msg != "" {
+		return msg
+	}
+
+	for _, q := range []string{
+		"DELETE FROM totp_secrets WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+	} {
+		if _, err := database.DB.Exec(q, user.ID); err != nil {
+			log.Println("Error disabling 2FA:", err)
+			return "Could not disable two-factor authentication."
+		}
+	}
+	return ""
+}
+
+// confirmTwoFactorChange checks the password through confirmIdentity or,
+// for accounts without one, a current code. Wrong codes count against
+// the login lockout like wrong passwords.
+func confirmTwoFactorChange(r *http.Request, user *SessionUser, password, code string) (string, error) {
+	var hash string
+	err := database.DB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&hash)
+	if err != nil {
+		return "", err
+	}
+	if hash != "" {
+		return confirmIdentity(r, user, password)
+	}
+
+	emailKey, ipKey := loginEmailKey(user.Email), loginIPKey(ClientIP(r))
+	wait, err := lockedFor(emailKey, ipKey)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return lockoutMessage(wait), nil
+	}
+
+	// A recovery code still works if the secret can't be read
+	ok, err := checkTOTP(user.ID, code)
+	if err != nil {
+		log.Println("Error checking TOTP:", err)
+	}
+	if !ok {
+		if ok, err = useRecoveryCode(user.ID, code); err != nil {
+			return "", err
+		}
+	}
+	if !ok {
+		recordLoginFailure(emailKey, ipKey)
+		return "Invalid authenticator or recovery code.", nil
+	}
+	if err := clearThrottle(emailKey); err != nil {
+		log.Println("Error clearing lockout:", err)
+	}
+	return "", nil
+}
+
+func regenerateCodes(user *SessionUser, code string) ([]string, string) {
+	ok, err := checkTOTP(user.ID, code)
+	if err != nil {
+		log.Println("Error checking TOTP:", err)
+	}
+	if !ok {
+		return nil, "Invalid authenticator code."
+	}
+
+	codes, err := generateRecoveryCodes(user.ID)
+	if err != nil {
+		log.Println("Error generating recovery codes:", err)
+		return nil, "Could not generate recovery codes."
+	}
+	return codes, ""
+}
+
+// ------------------------------------------------------------
+// SECRET STORAGE
+// ------------------------------------------------------------
+
+// TOTP secrets must be readable by the server to check codes, so they
+// can't be hashed like passwords. When FORUM_TOTP_KEY is set they are
+// sealed with AES-GCM under a key derived from it, bound to their user
+// so a row can't be copied onto another account; a copy of the database
+// alone is then not enough to get past the second factor. Without the
+// key they are stored as is.
+
+// sealedPrefix marks an encrypted secret. Base32 never contains ':'.
+const sealedPrefix = "v1:"
+
+func totpKey() []byte {
+	key := config.String("FORUM_TOTP_KEY", "")
+	if key == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func totpCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealTOTPSecret returns the form of secret to store for a user.
+func sealTOTPSecret(userID int, secret string) (string, error) {
+	key := totpKey()
+	if key == nil {
+		return secret, nil
+	}
+	aead, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(strconv.Itoa(userID)))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openTOTPSecret reverses sealTOTPSecret. Secrets stored before a key
+// was set are returned unchanged.
+func openTOTPSecret(userID int, stored string) (string, error) {
+	data, sealed := strings.CutPrefix(stored, sealedPrefix)
+	if !sealed {
+		return stored, nil
+	}
+	key := totpKey()
+	if key == nil {
+		return "", errors.New("TOTP secret is encrypted but FORUM_TOTP_KEY is not set")
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	aead, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < aead.NonceSize() {
+		return "", errors.New("TOTP secret is damaged")
+	}
+	nonce, ciphertext := raw[:aead.NonceSize()], raw[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(userID)))
+	if err != nil {
+		return "", errors.New("TOTP secret does not match FORUM_TOTP_KEY")
+	}
+	return string(secret), nil
+}
+
+// SealTOTPSecrets encrypts the secrets stored before FORUM_TOTP_KEY was
+// set. It runs at startup and does nothing without a key.
+func SealTOTPSecrets() {
+	if totpKey() == nil {
+		return
+	}
+	rows, err := database.DB.Query(
+		"SELECT user_id, secret FROM totp_secrets WHERE secret NOT LIKE ?", sealedPrefix+"%",
+	)
+	if err != nil {
+		log.Println("Error loading TOTP secrets:", err)
+		return
+	}
+	plain := map[int]string{}
+	for rows.Next() {
+		var (
+			userID int
+			secret string
+		)
+		if err := rows.Scan(&userID, &secret); err != nil {
+			rows.Close()
+			log.Println("Error scanning TOTP secret:", err)
+			return
+		}
+		plain[userID] = secret
+	}
+	rows.Close()
+
+	for userID, secret := range plain {
+		sealed, err := sealTOTPSecret(userID, secret)
+		if err == nil {
+			_, err = database.DB.Exec(
+				"UPDATE totp_secrets SET secret = ? WHERE user_id = ? AND secret = ?", sealed, userID, secret,
+			)
+		}
+		if err != nil {
+			log.Println("Error encrypting TOTP secret:", err)
+			return
+		}
+	}
+	if len(plain) > 0 {
+		log.Printf("🔐 Encrypted %d TOTP secrets", len(plain))
+	}
+}
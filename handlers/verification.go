@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"forum/config"
+	"forum/database"
+	"forum/mailer"
+)
+
+func verificationTTL() time.Duration {
+	return config.Duration("FORUM_VERIFY_TOKEN_TTL", 48*time.Hour)
+}
+
+// resendInterval is the minimum time between two verification emails.
+func resendInterval() time.Duration {
+	return config.Duration("FORUM_VERIFY_RESEND_INTERVAL", 5*time.Minute)
+}
+
+// issueVerificationLink stores a verification token for email and returns the link.
+func issueVerificationLink(userID int, email string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = database.DB.Exec(`
+		INSERT INTO email_verifications (user_id, email, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, email, hash, now.Add(verificationTTL()), now)
+	if err != nil {
+		return "", err
+	}
+
+	return config.BaseURL() + "/verify-email?token=" + url.QueryEscape(token), nil
+}
+
+// SendVerificationEmail issues a verification token for email and mails the link.
+func SendVerificationEmail(userID int, email string) error {
+	link, err := issueVerificationLink(userID, email)
+	if err != nil {
+		return err
+	}
+
+	body := "Welcome to the forum!\n\n" +
+		"Please confirm your email address by opening this link:\n" +
+		link + "\n\n" +
+		"You won't be able to post, comment or vote until your address is confirmed."
+
+	return mailer.Default().Send(email, "Confirm your forum email address", body)
+}
+
+// SendEmailChangeVerification mails a confirmation link to newEmail. The
+// account keeps its current address until the link is opened.
+func SendEmailChangeVerification(userID int, newEmail string) error {
+	link, err := issueVerificationLink(userID, newEmail)
+	if err != nil {
+		return err
+	}
+
+	body := "Someone asked to use this address for their forum account.\n\n" +
+		"If that was you, confirm the change by opening this link:\n" +
+		link + "\n\n" +
+		"If it wasn't, you can ignore this email."
+
+	return mailer.Default().Send(newEmail, "Confirm your new forum email address", body)
+}
+
+// RequireVerified renders the "verify your email" page and returns false
+// when the user has not confirmed their address yet.
+func RequireVerified(w http.ResponseWriter, r *http.Request, user *SessionUser) bool {
+	if user.EmailVerified {
+		return true
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	Render(w, r, "verify_email.html", map[string]interface{}{
+		"User":  user,
+		"Error": "Please confirm your email address before posting, commenting or voting.",
+	})
+	return false
+}
+
+// VerifyEmailHandler handles GET /verify-email?token=X.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	invalid := map[string]interface{}{
+		"Error": "This verification link is invalid or has expired.",
+	}
+
+	if token == "" {
+		Render(w, r, "verify_email.html", invalid)
+		return
+	}
+
+	var (
+		userID    int
+		email     string
+		expiresAt time.Time
+	)
+	err := database.DB.QueryRow(`
+		SELECT user_id, email, expires_at
+		FROM email_verifications
+		WHERE token_hash = ?
+	`, hashToken(token)).Scan(&userID, &email, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && time.Now().After(expiresAt)) {
+		Render(w, r, "verify_email.html", invalid)
+		return
+	}
+	if err != nil {
+		log.Println("Error looking up verification token:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Verify the address the link was sent to. If it differs from the
+	// current one, this link confirms an email change from /settings.
+	var current string
+	err = database.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&current)
+	if err != nil {
+		log.Println("Error loading user for verification:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if current == email {
+		_, err = database.DB.Exec("UPDATE users SET email_verified = 1 WHERE id = ?", userID)
+	} else {
+		var taken int
+		err = database.DB.QueryRow(
+			"SELECT COUNT(*) FROM users WHERE email = ? AND id != ?", email, userID,
+		).Scan(&taken)
+		if err == nil && taken > 0 {
+			Render(w, r, "verify_email.html", map[string]interface{}{
+				"Error": "That email address is now used by another account.",
+			})
+			return
+		}
+		if err == nil {
+			_, err = database.DB.Exec(
+				"UPDATE users SET email = ?, email_verified = 1 WHERE id = ?", email, userID,
+			)
+		}
+		if err == nil {
+			recordAccountChange(r, userID, "email", current, email)
+		}
+	}
+	if err != nil {
+		log.Println("Error verifying email:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM email_verifications WHERE user_id = ?", userID)
+	if err != nil {
+		log.Println("Error cleaning verification tokens:", err)
+	}
+
+	// First admin: promote if the address is listed in FORUM_ADMIN_EMAILS
+	BootstrapAdmins()
+
+	user, _ := GetUserFromRequest(r)
+	Render(w, r, "verify_email.html", map[string]interface{}{
+		"User":    user,
+		"Message": "Thanks! Your email address is confirmed.",
+	})
+}
+
+// ResendVerificationHandler handles POST /resend-verification.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := GetUserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if user.EmailVerified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// THROTTLE: one email per resendInterval
+	var last time.Time
+	err := database.DB.QueryRow(`
+		SELECT created_at FROM email_verifications
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, user.ID).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error checking last verification email:", err)
+	}
+
+	if err == nil && time.Since(last) < resendInterval() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		Render(w, r, "verify_email.html", map[string]interface{}{
+			"User":  user,
+			"Error": "A verification email was sent recently. Please wait a few minutes before asking again.",
+		})
+		return
+	}
+
+	if err := SendVerificationEmail(user.ID, user.Email); err != nil {
+		log.Println("Error sending verification email:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	Render(w, r, "verify_email.html", map[string]interface{}{
+		"User":    user,
+		"Message": "A new verification link has been sent to " + user.Email + ".",
+	})
+}
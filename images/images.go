@@ -0,0 +1,204 @@
+// Package images checks and prepares the pictures attached to posts.
+//
+// An upload is recognised by its first bytes (JPEG, PNG, GIF or WebP),
+// never by its name or declared type. Metadata that may give away where
+// and how a photo was taken (EXIF, XMP, text chunks...) is cut out of the
+// file without re-encoding it, so the picture itself is unchanged, and a
+// thumbnail is made with the standard image packages (WebP has no
+// decoder there, so WebP images are shown scaled down instead).
+//
+// Files are stored under the SHA-256 of their content (see store.go):
+// the same picture uploaded twice is kept once.
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"forum/config"
+)
+
+// Errors returned by Process, worded to be shown to the uploader.
+var (
+	ErrTooLarge    = errors.New("image is larger than the size limit")
+	ErrUnsupported = errors.New("file is not a JPEG, PNG, GIF or WebP image")
+	ErrCorrupt     = errors.New("image file is damaged")
+	ErrDimensions  = errors.New("image has too many pixels")
+)
+
+// maxPixels bounds width × height, so a small file can't decode into
+// gigabytes of memory.
+const maxPixels = 40_000_000
+
+// thumbSize is the longest side of a thumbnail.
+const thumbSize = 320
+
+// MaxBytes is the largest image accepted (FORUM_UPLOAD_MAX_BYTES, default 5 MB).
+func MaxBytes() int {
+	if n := config.Int("FORUM_UPLOAD_MAX_BYTES", 5<<20); n > 0 {
+		return n
+	}
+	return 5 << 20
+}
+
+// MaxPerPost is how many images a post may have (FORUM_UPLOAD_MAX_IMAGES,
+// default 4; 0 turns uploads off).
+func MaxPerPost() int {
+	if n := config.Int("FORUM_UPLOAD_MAX_IMAGES", 4); n >= 0 {
+		return n
+	}
+	return 4
+}
+
+// MaxRequestBytes bounds a whole upload request: every image plus room
+// for the other form fields.
+func MaxRequestBytes() int64 {
+	return int64(MaxPerPost())*int64(MaxBytes()) + 1<<20
+}
+
+// Image is an upload ready to be stored.
+type Image struct {
+	Hash          string // hex SHA-256 of Data
+	Format        string // "jpeg", "png", "gif" or "webp"
+	Width, Height int
+	Data          []byte // the file, metadata removed
+
+	// Thumbnail is nil when the image is already small enough or can't
+	// be decoded (WebP).
+	Thumbnail       []byte
+	ThumbnailFormat string
+}
+
+// decoders holds the standard library codecs, by format.
+var decoders = map[string]struct {
+	config func(io.Reader) (image.Config, error)
+	decode func(io.Reader) (image.Image, error)
+}{
+	"jpeg": {jpeg.DecodeConfig, jpeg.Decode},
+	"png":  {png.DecodeConfig, png.Decode},
+	"gif":  {gif.DecodeConfig, gif.Decode},
+}
+
+// Detect returns the format of data from its magic bytes ("" if unknown).
+func Detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1A\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// Process checks an uploaded file and prepares it for storage.
+func Process(data []byte) (*Image, error) {
+	if len(data) > MaxBytes() {
+		return nil, ErrTooLarge
+	}
+	img := &Image{Format: Detect(data)}
+
+	var err error
+	switch img.Format {
+	case "":
+		return nil, ErrUnsupported
+	case "webp":
+		if img.Data, err = stripWebP(data); err != nil {
+			return nil, err
+		}
+		if img.Width, img.Height, err = webpSize(img.Data); err != nil {
+			return nil, err
+		}
+		if err := checkDimensions(img.Width, img.Height); err != nil {
+			return nil, err
+		}
+	default:
+		if img.Data, err = stripMetadata(img.Format, data); err != nil {
+			return nil, err
+		}
+		codec := decoders[img.Format]
+		cfg, err := codec.config(bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		img.Width, img.Height = cfg.Width, cfg.Height
+		if err := checkDimensions(img.Width, img.Height); err != nil {
+			return nil, err
+		}
+
+		// Decoding the whole picture also proves it is one
+		decoded, err := codec.decode(bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		if img.Width > thumbSize || img.Height > thumbSize {
+			if err := img.makeThumbnail(decoded); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sum := sha256.Sum256(img.Data)
+	img.Hash = hex.EncodeToString(sum[:])
+	return img, nil
+}
+
+func checkDimensions(w, h int) error {
+	if w <= 0 || h <= 0 {
+		return ErrCorrupt
+	}
+	if int64(w)*int64(h) > maxPixels {
+		return ErrDimensions
+	}
+	return nil
+}
+
+// makeThumbnail scales decoded down to fit thumbSize. Photos stay JPEG;
+// the other formats become PNG, which keeps their transparency.
+func (img *Image) makeThumbnail(decoded image.Image) error {
+	w, h := thumbSize, thumbSize
+	if img.Width >= img.Height {
+		h = max(1, img.Height*thumbSize/img.Width)
+	} else {
+		w = max(1, img.Width*thumbSize/img.Height)
+	}
+	small := scaleDown(decoded, w, h)
+
+	var buf bytes.Buffer
+	var err error
+	if img.Format == "jpeg" {
+		img.ThumbnailFormat = "jpeg"
+		err = jpeg.Encode(&buf, small, &jpeg.Options{Quality: 85})
+	} else {
+		img.ThumbnailFormat = "png"
+		err = png.Encode(&buf, small)
+	}
+	if err != nil {
+		return err
+	}
+	img.Thumbnail = buf.Bytes()
+	return nil
+}
+
+// Ext returns the file extension used for format.
+func Ext(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	return "image/" + format
+}
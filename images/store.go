@@ -0,0 +1,172 @@
+package images
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"forum/config"
+	"forum/database"
+)
+
+// Files live in FORUM_UPLOAD_DIR (default "uploads"), named after the
+// hash of their content and spread over subdirectories by its first two
+// characters:
+//
+//	uploads/3f/3f9a…c1.jpg         the image
+//	uploads/3f/3f9a…c1.thumb.jpg   its thumbnail
+//
+// A file is kept as long as some post_images row names its hash. Rows
+// are inserted before their files are written, and storeMu makes "is the
+// hash still used?" and the removal one step, so a post losing an image
+// can't delete a file another post is adding at the same time.
+
+var storeMu sync.Mutex
+
+// Dir is the directory holding the uploaded files.
+func Dir() string {
+	return config.String("FORUM_UPLOAD_DIR", "uploads")
+}
+
+// FileName returns the name of an image's file, or of its thumbnail.
+func FileName(hash, format string, thumbnail bool) string {
+	if thumbnail {
+		return hash + ".thumb." + Ext(format)
+	}
+	return hash + "." + Ext(format)
+}
+
+// Path returns where the file called name (see FileName) is stored.
+func Path(name string) string {
+	return filepath.Join(Dir(), name[:2], name)
+}
+
+// ValidHash reports whether s looks like a hash made by Process, so it
+// can safely be part of a path.
+func ValidHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes img (and its thumbnail) unless it is stored already.
+func Save(img *Image) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if err := writeFile(FileName(img.Hash, img.Format, false), img.Data); err != nil {
+		return err
+	}
+	if img.Thumbnail != nil {
+		return writeFile(FileName(img.Hash, img.ThumbnailFormat, true), img.Thumbnail)
+	}
+	return nil
+}
+
+// writeFile stores data under name, through a temporary file so a file
+// is never seen half written.
+func writeFile(name string, data []byte) error {
+	path := Path(name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RemoveUnused deletes the files of every hash no post uses any more.
+func RemoveUnused(hashes ...string) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	for _, hash := range hashes {
+		var n int
+		err := database.DB.QueryRow("SELECT COUNT(*) FROM post_images WHERE hash = ?", hash).Scan(&n)
+		if err != nil {
+			log.Println("Error checking image use:", err)
+			continue
+		}
+		if n > 0 || !ValidHash(hash) {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(Dir(), hash[:2], hash+".*"))
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				log.Println("Error removing image:", err)
+			}
+		}
+	}
+}
+
+// RemoveOrphans deletes every stored file no post uses, such as those
+// left behind when the server stopped halfway through an upload or
+// a deletion.
+func RemoveOrphans() {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	rows, err := database.DB.Query("SELECT DISTINCT hash FROM post_images")
+	if err != nil {
+		log.Println("Error loading image hashes:", err)
+		return
+	}
+	used := map[string]bool{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			log.Println("Error scanning image hash:", err)
+			return
+		}
+		used[hash] = true
+	}
+	rows.Close()
+
+	removed := 0
+	err = filepath.WalkDir(Dir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// Temporary files are only open while storeMu is held
+		hash, _, _ := strings.Cut(d.Name(), ".")
+		if used[hash] || !(ValidHash(hash) || strings.HasPrefix(d.Name(), ".upload-")) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println("Error removing orphaned images:", err)
+	}
+	if removed > 0 {
+		log.Printf("🗑️ Removed %d orphaned image files", removed)
+	}
+}
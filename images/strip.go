@@ -0,0 +1,332 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Metadata is removed by walking each format's container and copying
+// every part except the ones holding it, so the image data is kept
+// byte for byte. Anything after the end of the image is dropped too.
+
+func stripMetadata(format string, data []byte) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return stripJPEG(data)
+	case "png":
+		return stripPNG(data)
+	case "gif":
+		return stripGIF(data)
+	}
+	return data, nil
+}
+
+// ------------------------------------------------------------
+// JPEG
+// ------------------------------------------------------------
+
+// stripJPEG drops the APPn segments (EXIF and XMP live in APP1, IPTC in
+// APP13) and comments, except JFIF, ICC colour profiles and Adobe's
+// colour transform, which change how the picture looks.
+//
+// The EXIF orientation goes too, so a photo taken sideways shows as it
+// was stored.
+func stripJPEG(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	out = append(out, 0xFF, 0xD8)
+
+	for i := 2; ; {
+		if i+2 > len(b) || b[i] != 0xFF {
+			return nil, ErrCorrupt
+		}
+		marker := b[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			i++
+			continue
+		case marker == 0xD9:
+			// End of image
+			return append(out, 0xFF, 0xD9), nil
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			// Markers without a length
+			out = append(out, b[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(b) {
+			return nil, ErrCorrupt
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, ErrCorrupt
+		}
+		end := i + 2 + n
+
+		if marker == 0xDA {
+			// Start of scan: the compressed data runs to the next marker
+			// that is not an escaped 0xFF (FF 00) or a restart (FF D0-D7).
+			for {
+				k := bytes.IndexByte(b[end:], 0xFF)
+				if k < 0 || end+k+1 >= len(b) {
+					return nil, ErrCorrupt
+				}
+				end += k
+				next := b[end+1]
+				if next != 0x00 && (next < 0xD0 || next > 0xD7) {
+					break
+				}
+				end += 2
+			}
+		} else if !keepJPEGSegment(marker, b[i+4:end]) {
+			i = end
+			continue
+		}
+		out = append(out, b[i:end]...)
+		i = end
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0: // JFIF
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+// ------------------------------------------------------------
+// PNG
+// ------------------------------------------------------------
+
+// pngMetadata lists the chunks dropped from PNG files.
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:8]...)
+
+	for i := 8; ; {
+		if i+12 > len(b) {
+			return nil, ErrCorrupt
+		}
+		n := uint64(binary.BigEndian.Uint32(b[i:]))
+		if n > uint64(len(b)-i-12) {
+			return nil, ErrCorrupt
+		}
+		end := i + 12 + int(n)
+		typ := string(b[i+4 : i+8])
+		if !pngMetadata[typ] {
+			out = append(out, b[i:end]...)
+		}
+		if typ == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+}
+
+// ------------------------------------------------------------
+// GIF
+// ------------------------------------------------------------
+
+// gifKeptApplications lists the application extensions kept in GIF
+// files: both say how often an animation loops. Others carry XMP and
+// similar metadata.
+var gifKeptApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// stripGIF drops comment extensions and every application extension
+// not in gifKeptApplications.
+func stripGIF(b []byte) ([]byte, error) {
+	// Header and logical screen descriptor, then the global colour table
+	i := 13
+	if len(b) < i {
+		return nil, ErrCorrupt
+	}
+	if flags := b[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	if i > len(b) {
+		return nil, ErrCorrupt
+	}
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:i]...)
+
+	for i < len(b) {
+		start := i
+		keep := true
+		switch b[i] {
+		case 0x3B:
+			// Trailer
+			return append(out, 0x3B), nil
+		case 0x2C:
+			// Image descriptor, local colour table, LZW code size, data
+			if i+11 > len(b) {
+				return nil, ErrCorrupt
+			}
+			flags := b[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+		case 0x21:
+			if i+2 > len(b) {
+				return nil, ErrCorrupt
+			}
+			switch b[i+1] {
+			case 0xFE:
+				keep = false
+			case 0xFF:
+				app := b[i+2:]
+				keep = len(app) >= 12 && app[0] == 11 && gifKeptApplications[string(app[1:12])]
+			}
+			i += 2
+		default:
+			return nil, ErrCorrupt
+		}
+
+		end, err := gifSkipSubBlocks(b, i)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			out = append(out, b[start:end]...)
+		}
+		i = end
+	}
+	return nil, ErrCorrupt
+}
+
+// gifSkipSubBlocks returns the offset after the data sub-blocks starting
+// at i, each a length byte and that many bytes, ending with a zero length.
+func gifSkipSubBlocks(b []byte, i int) (int, error) {
+	for {
+		if i >= len(b) {
+			return 0, ErrCorrupt
+		}
+		n := int(b[i])
+		i += 1 + n
+		if n == 0 {
+			return i, nil
+		}
+	}
+}
+
+// ------------------------------------------------------------
+// WEBP
+// ------------------------------------------------------------
+
+// VP8X flags telling that EXIF and XMP chunks follow.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+// webpChunks splits a WebP file into the chunks of its RIFF container.
+func webpChunks(b []byte) ([]riffChunk, error) {
+	if len(b) < 12 {
+		return nil, ErrCorrupt
+	}
+	size := uint64(binary.LittleEndian.Uint32(b[4:]))
+	if size < 4 || size > uint64(len(b)-8) {
+		return nil, ErrCorrupt
+	}
+	body := b[12 : 8+size]
+
+	var chunks []riffChunk
+	for len(body) > 0 {
+		if len(body) < 8 {
+			return nil, ErrCorrupt
+		}
+		n := uint64(binary.LittleEndian.Uint32(body[4:]))
+		if n > uint64(len(body)-8) {
+			return nil, ErrCorrupt
+		}
+		chunks = append(chunks, riffChunk{string(body[:4]), body[8 : 8+n]})
+
+		// Chunks are padded to an even length
+		next := 8 + int(n) + int(n&1)
+		body = body[min(next, len(body)):]
+	}
+	if len(chunks) == 0 {
+		return nil, ErrCorrupt
+	}
+	return chunks, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their VP8X flags.
+func stripWebP(b []byte) ([]byte, error) {
+	chunks, err := webpChunks(b)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		data := c.data
+		switch c.id {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if len(data) > 0 {
+				data = bytes.Clone(data)
+				data[0] &^= webpFlagEXIF | webpFlagXMP
+			}
+		}
+		body = append(body, c.id...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+		body = append(body, data...)
+		if len(data)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...), nil
+}
+
+// webpSize reads the canvas size from the first chunk: VP8X for extended
+// files, otherwise the lossy (VP8) or lossless (VP8L) bitstream header.
+func webpSize(b []byte) (w, h int, err error) {
+	chunks, err := webpChunks(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	c := chunks[0]
+	uint24 := func(p []byte) int { return int(p[0]) | int(p[1])<<8 | int(p[2])<<16 }
+
+	switch {
+	case c.id == "VP8X" && len(c.data) >= 10:
+		return 1 + uint24(c.data[4:]), 1 + uint24(c.data[7:]), nil
+	case c.id == "VP8 " && len(c.data) >= 10 && bytes.Equal(c.data[3:6], []byte{0x9D, 0x01, 0x2A}):
+		w := int(binary.LittleEndian.Uint16(c.data[6:]) & 0x3FFF)
+		h := int(binary.LittleEndian.Uint16(c.data[8:]) & 0x3FFF)
+		return w, h, nil
+	case c.id == "VP8L" && len(c.data) >= 5 && c.data[0] == 0x2F:
+		bits := binary.LittleEndian.Uint32(c.data[1:])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, nil
+	}
+	return 0, 0, ErrCorrupt
+}
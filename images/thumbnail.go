@@ -0,0 +1,54 @@
+package images
+
+import (
+	"image"
+	"image/color"
+)
+
+// scaleDown shrinks src to w×h (no larger than src) by averaging the
+// source pixels under each thumbnail pixel. Colours are averaged with
+// their alpha applied, so transparent pixels don't darken the edges.
+func scaleDown(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	at := rgba64At(src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*sh/h, b.Min.Y+(y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*sw/w, b.Min.X+(x+1)*sw/w
+
+			var r, g, bl, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := at(sx, sy)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// rgba64At returns a pixel reader for src, using the decoders' fast
+// path when they have one.
+func rgba64At(src image.Image) func(x, y int) color.RGBA64 {
+	if img, ok := src.(image.RGBA64Image); ok {
+		return img.RGBA64At
+	}
+	return func(x, y int) color.RGBA64 {
+		r, g, b, a := src.At(x, y).RGBA()
+		return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+}
@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A small BER (X.690) codec, enough for LDAPv3 messages: single-byte
+// tags, definite lengths. Packets are exported so an in-process fake
+// directory can be written with the same primitives as the client.
+
+// Tag classes
+const (
+	ClassUniversal   = 0x00
+	ClassApplication = 0x40
+	ClassContext     = 0x80
+)
+
+// Universal tags
+const (
+	TagBoolean     = 1
+	TagInteger     = 2
+	TagOctetString = 4
+	TagNull        = 5
+	TagEnumerated  = 10
+	TagSequence    = 16
+	TagSet         = 17
+)
+
+// maxPacketSize bounds what ReadPacket accepts from the network.
+const maxPacketSize = 1 << 20
+
+var errBER = errors.New("ldap: malformed BER")
+
+// Packet is one BER element. Primitive elements carry Value,
+// constructed ones Children.
+type Packet struct {
+	Class       byte
+	Constructed bool
+	Tag         byte
+	Value       []byte
+	Children    []*Packet
+}
+
+// NewPrimitive returns a primitive element.
+func NewPrimitive(class, tag byte, value []byte) *Packet {
+	return &Packet{Class: class, Tag: tag, Value: value}
+}
+
+// NewConstructed returns a constructed element holding children.
+func NewConstructed(class, tag byte, children ...*Packet) *Packet {
+	return &Packet{Class: class, Constructed: true, Tag: tag, Children: children}
+}
+
+// Sequence returns a universal SEQUENCE.
+func Sequence(children ...*Packet) *Packet {
+	return NewConstructed(ClassUniversal, TagSequence, children...)
+}
+
+// OctetString returns a universal OCTET STRING.
+func OctetString(s string) *Packet {
+	return NewPrimitive(ClassUniversal, TagOctetString, []byte(s))
+}
+
+// Integer returns a universal INTEGER.
+func Integer(n int64) *Packet {
+	return NewPrimitive(ClassUniversal, TagInteger, encodeInt(n))
+}
+
+// Enumerated returns a universal ENUMERATED.
+func Enumerated(n int64) *Packet {
+	return NewPrimitive(ClassUniversal, TagEnumerated, encodeInt(n))
+}
+
+// Boolean returns a universal BOOLEAN.
+func Boolean(b bool) *Packet {
+	v := byte(0)
+	if b {
+		v = 0xff
+	}
+	return NewPrimitive(ClassUniversal, TagBoolean, []byte{v})
+}
+
+// Is reports whether p has the given class and tag.
+func (p *Packet) Is(class, tag byte) bool {
+	return p != nil && p.Class == class && p.Tag == tag
+}
+
+// Int decodes an INTEGER or ENUMERATED value.
+func (p *Packet) Int() (int64, error) {
+	if p == nil || p.Constructed || len(p.Value) == 0 || len(p.Value) > 8 {
+		return 0, errBER
+	}
+	n := int64(int8(p.Value[0])) // sign extend
+	for _, b := range p.Value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// Str returns a primitive value as a string ("" for nil or constructed).
+func (p *Packet) Str() string {
+	if p == nil || p.Constructed {
+		return ""
+	}
+	return string(p.Value)
+}
+
+// Child returns the i-th child, or nil.
+func (p *Packet) Child(i int) *Packet {
+	if p == nil || i < 0 || i >= len(p.Children) {
+		return nil
+	}
+	return p.Children[i]
+}
+
+// Bytes encodes the packet.
+func (p *Packet) Bytes() []byte {
+	var content []byte
+	if p.Constructed {
+		var buf bytes.Buffer
+		for _, c := range p.Children {
+			buf.Write(c.Bytes())
+		}
+		content = buf.Bytes()
+	} else {
+		content = p.Value
+	}
+
+	id := p.Class | p.Tag
+	if p.Constructed {
+		id |= 0x20
+	}
+	out := append([]byte{id}, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+func (p *Packet) String() string {
+	if p.Constructed {
+		return fmt.Sprintf("[%#x %d]%v", p.Class, p.Tag, p.Children)
+	}
+	return fmt.Sprintf("[%#x %d]%q", p.Class, p.Tag, p.Value)
+}
+
+// ReadPacket reads one element from r.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0]&0x1f == 0x1f {
+		return nil, errBER // multi-byte tags are not used by LDAP
+	}
+
+	length := int(hdr[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errBER // indefinite or absurd lengths
+		}
+		var lb [4]byte
+		if _, err := io.ReadFull(r, lb[:n]); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lb[:n] {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, errBER
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parsePacket(hdr[0], content, 0)
+}
+
+// maxNesting bounds recursion on hostile input.
+const maxNesting = 32
+
+func parsePacket(id byte, content []byte, depth int) (*Packet, error) {
+	p := &Packet{
+		Class:       id & 0xc0,
+		Constructed: id&0x20 != 0,
+		Tag:         id & 0x1f,
+	}
+	if !p.Constructed {
+		p.Value = content
+		return p, nil
+	}
+	if depth > maxNesting {
+		return nil, errBER
+	}
+
+	for len(content) > 0 {
+		if len(content) < 2 || content[0]&0x1f == 0x1f {
+			return nil, errBER
+		}
+		childID := content[0]
+		length := int(content[1])
+		rest := content[2:]
+		if length&0x80 != 0 {
+			n := length & 0x7f
+			if n == 0 || n > 4 || len(rest) < n {
+				return nil, errBER
+			}
+			length = 0
+			for _, b := range rest[:n] {
+				length = length<<8 | int(b)
+			}
+			rest = rest[n:]
+		}
+		if length < 0 || length > len(rest) {
+			return nil, errBER
+		}
+		child, err := parsePacket(childID, rest[:length], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+		content = rest[length:]
+	}
+	return p, nil
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// encodeInt returns the shortest two's complement encoding of n.
+func encodeInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
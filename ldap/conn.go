@@ -0,0 +1,302 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511): simple bind,
+// subtree search and StartTLS, which is all the forum needs to check
+// passwords against a directory and read a user's groups.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operations (application tags)
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opSearchReference  = 19
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+)
+
+// Result codes the client tells apart
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Error is an LDAP result other than success.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsInvalidCredentials reports whether err is a failed bind.
+func IsInvalidCredentials(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == ResultInvalidCredentials
+}
+
+// Entry is one search result. Attribute names are lower-cased.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of attr, or "".
+func (e *Entry) Get(attr string) string {
+	if v := e.Attributes[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Conn is a connection to a directory server. Requests are sent one at a
+// time; a Conn is not safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	msgID   int64
+	timeout time.Duration
+}
+
+// NewConn wraps an established connection (plain, TLS, or one end of a
+// net.Pipe talking to a fake server).
+func NewConn(c net.Conn, timeout time.Duration) *Conn {
+	return &Conn{conn: c, timeout: timeout}
+}
+
+// Dial connects to an ldap:// or ldaps:// URL.
+func Dial(rawURL string, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		c, err := dialer.Dial("tcp", host)
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(c, timeout), nil
+
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		c, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(c, timeout), nil
+	}
+	return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+}
+
+// Close sends an unbind request and closes the connection.
+func (c *Conn) Close() error {
+	c.send(NewPrimitive(ClassApplication, opUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// StartTLS upgrades the connection (RFC 4511 4.14).
+func (c *Conn) StartTLS(config *tls.Config) error {
+	req := NewConstructed(ClassApplication, opExtendedRequest,
+		NewPrimitive(ClassContext, 0, []byte(startTLSOID)))
+	id, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !op.Is(ClassApplication, opExtendedResponse) {
+		return errBER
+	}
+	if err := resultError(op); err != nil {
+		return err
+	}
+
+	tc := tls.Client(c.conn, config)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tc
+	return nil
+}
+
+// Bind authenticates with a DN and password (simple bind). An empty
+// password is refused here: servers treat it as an anonymous bind and
+// report success.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" && dn != "" {
+		return &Error{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+	req := NewConstructed(ClassApplication, opBindRequest,
+		Integer(3),
+		OctetString(dn),
+		NewPrimitive(ClassContext, 0, []byte(password)),
+	)
+	id, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !op.Is(ClassApplication, opBindResponse) {
+		return errBER
+	}
+	return resultError(op)
+}
+
+// Search runs a subtree search under baseDN and returns at most sizeLimit
+// entries (0 = server default). Hitting the limit is not an error.
+func (c *Conn) Search(baseDN, filter string, attrs []string, sizeLimit int) ([]*Entry, error) {
+	f, err := CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrList := Sequence()
+	for _, a := range attrs {
+		attrList.Children = append(attrList.Children, OctetString(a))
+	}
+	req := NewConstructed(ClassApplication, opSearchRequest,
+		OctetString(baseDN),
+		Enumerated(2), // wholeSubtree
+		Enumerated(0), // neverDerefAliases
+		Integer(int64(sizeLimit)),
+		Integer(int64(c.timeout/time.Second)),
+		Boolean(false),
+		f,
+		attrList,
+	)
+	id, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case op.Is(ClassApplication, opSearchEntry):
+			e, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case op.Is(ClassApplication, opSearchReference):
+			// Referrals to other servers are not followed
+		case op.Is(ClassApplication, opSearchDone):
+			if err := resultError(op); err != nil {
+				var le *Error
+				if errors.As(err, &le) && le.Code == ResultNoSuchObject {
+					return nil, nil
+				}
+				if errors.As(err, &le) && le.Code == ResultSizeLimitExceeded {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, errBER
+		}
+	}
+}
+
+func parseEntry(op *Packet) (*Entry, error) {
+	if len(op.Children) < 2 {
+		return nil, errBER
+	}
+	e := &Entry{DN: op.Child(0).Str(), Attributes: map[string][]string{}}
+	for _, attr := range op.Child(1).Children {
+		name := strings.ToLower(attr.Child(0).Str())
+		if name == "" {
+			return nil, errBER
+		}
+		if vals := attr.Child(1); vals != nil {
+			for _, v := range vals.Children {
+				e.Attributes[name] = append(e.Attributes[name], v.Str())
+			}
+		}
+	}
+	return e, nil
+}
+
+// resultError turns an LDAPResult into nil or an *Error.
+func resultError(op *Packet) error {
+	code, err := op.Child(0).Int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{Code: int(code), Message: op.Child(2).Str()}
+}
+
+// send writes an LDAPMessage and returns its message ID.
+func (c *Conn) send(op *Packet) (int64, error) {
+	c.msgID++
+	msg := Sequence(Integer(c.msgID), op)
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	_, err := c.conn.Write(msg.Bytes())
+	return c.msgID, err
+}
+
+// receive reads the next message for id and returns its protocol op.
+func (c *Conn) receive(id int64) (*Packet, error) {
+	for {
+		if c.timeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		msg, err := ReadPacket(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if !msg.Is(ClassUniversal, TagSequence) || len(msg.Children) < 2 {
+			return nil, errBER
+		}
+		got, err := msg.Child(0).Int()
+		if err != nil {
+			return nil, err
+		}
+		if got == 0 {
+			// Unsolicited notification, e.g. notice of disconnection
+			return nil, errors.New("ldap: server closed the connection")
+		}
+		if got == id {
+			return msg.Child(1), nil
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCredentials means the login is unknown to the directory or
+// the password is wrong; the two are not told apart.
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// Directory describes how to find and authenticate users.
+type Directory struct {
+	URL      string // ldap://host[:389] or ldaps://host[:636]
+	StartTLS bool   // upgrade an ldap:// connection before binding
+
+	// Service account used to search for users (empty = anonymous)
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserFilter string // "%s" is replaced by the escaped login
+
+	EmailAttr    string // e.g. "mail"
+	UsernameAttr string // e.g. "uid" or "sAMAccountName"
+	GroupAttr    string // group DNs on the user entry, e.g. "memberOf"
+
+	// Optional group search for servers without memberOf:
+	// "%s" in GroupFilter is replaced by the escaped user DN.
+	GroupBaseDN string
+	GroupFilter string
+
+	Timeout time.Duration
+
+	// Dial opens the connection; nil dials URL. Tests can point it at an
+	// in-process fake server (e.g. one end of a net.Pipe).
+	Dial func() (net.Conn, error)
+}
+
+// User is a directory account that passed authentication.
+type User struct {
+	DN       string
+	Email    string
+	Username string
+	Groups   []string // DNs
+}
+
+func (d *Directory) connect() (*Conn, error) {
+	if d.Dial != nil {
+		c, err := d.Dial()
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(c, d.Timeout), nil
+	}
+
+	conn, err := Dial(d.URL, d.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.StartTLS {
+		host := ""
+		if u, err := url.Parse(d.URL); err == nil {
+			host = u.Hostname()
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate finds the entry matching login, checks password by binding
+// as that entry and returns the user's details and groups.
+func (d *Directory) Authenticate(login, password string) (*User, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// 1. Find the user with the service account
+	if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+		return nil, err
+	}
+	filter := strings.ReplaceAll(d.UserFilter, "%s", EscapeFilter(login))
+	attrs := []string{d.EmailAttr, d.UsernameAttr}
+	if d.GroupAttr != "" {
+		attrs = append(attrs, d.GroupAttr)
+	}
+	// Two results are enough to tell "unique" from "ambiguous"
+	entries, err := conn.Search(d.BaseDN, filter, attrs, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	// 2. The password is checked by the directory itself
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if IsInvalidCredentials(err) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	user := &User{
+		DN:       entry.DN,
+		Email:    entry.Get(d.EmailAttr),
+		Username: entry.Get(d.UsernameAttr),
+	}
+	if d.GroupAttr != "" {
+		user.Groups = entry.Attributes[strings.ToLower(d.GroupAttr)]
+	}
+
+	// 3. Groups that list their members, read with the service account again
+	if d.GroupFilter != "" {
+		if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, err
+		}
+		base := d.GroupBaseDN
+		if base == "" {
+			base = d.BaseDN
+		}
+		groups, err := conn.Search(base, strings.ReplaceAll(d.GroupFilter, "%s", EscapeFilter(entry.DN)), []string{"1.1"}, 0)
+		if err != nil {
+			return nil, err
+		}
+	next:
+		for _, g := range groups {
+			for _, known := range user.Groups {
+				if SameDN(known, g.DN) {
+					continue next
+				}
+			}
+			user.Groups = append(user.Groups, g.DN)
+		}
+	}
+
+	return user, nil
+}
+
+// SameDN compares two DNs ignoring case and spaces around separators,
+// which is how group DNs are usually written in configuration.
+func SameDN(a, b string) bool {
+	return normalizeDN(a) == normalizeDN(b)
+}
+
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		for j := range kv {
+			kv[j] = strings.TrimSpace(kv[j])
+		}
+		parts[i] = strings.ToLower(strings.Join(kv, "="))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,183 @@
+package ldap_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"forum/ldap"
+	"forum/ldap/ldaptest"
+)
+
+const (
+	serviceDN = "cn=forum,ou=services,dc=example,dc=com"
+	aliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+	adminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+	staffDN   = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+func newServer() *ldaptest.Server {
+	return &ldaptest.Server{Entries: []*ldaptest.Entry{
+		{DN: serviceDN, Password: "service-secret"},
+		{
+			DN:       aliceDN,
+			Password: "alice-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"alice"},
+				"mail":        {"alice@example.com"},
+				"memberOf":    {adminsDN},
+			},
+		},
+		{
+			DN: staffDN,
+			Attributes: map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"member":      {aliceDN},
+			},
+		},
+	}}
+}
+
+func newDirectory(s *ldaptest.Server) *ldap.Directory {
+	return &ldap.Directory{
+		BindDN:       serviceDN,
+		BindPassword: "service-secret",
+		BaseDN:       "dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(|(mail=%s)(uid=%s)))",
+		EmailAttr:    "mail",
+		UsernameAttr: "uid",
+		GroupAttr:    "memberOf",
+		Timeout:      2 * time.Second,
+		Dial:         s.Dial,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newServer()
+	d := newDirectory(s)
+
+	for _, login := range []string{"alice@example.com", "alice", "ALICE"} {
+		u, err := d.Authenticate(login, "alice-secret")
+		if err != nil {
+			t.Fatalf("Authenticate(%q): %v", login, err)
+		}
+		want := &ldap.User{DN: aliceDN, Email: "alice@example.com", Username: "alice", Groups: []string{adminsDN}}
+		if !reflect.DeepEqual(u, want) {
+			t.Errorf("Authenticate(%q) = %+v, want %+v", login, u, want)
+		}
+	}
+
+	// Search as the service account, then check the password as the user
+	binds := s.Binds()
+	if len(binds) < 2 || binds[0] != serviceDN || binds[1] != aliceDN {
+		t.Errorf("binds = %q, want the service account then the user", binds)
+	}
+}
+
+func TestAuthenticateGroupSearch(t *testing.T) {
+	d := newDirectory(newServer())
+	d.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+
+	u, err := d.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{adminsDN, staffDN}; !reflect.DeepEqual(u.Groups, want) {
+		t.Errorf("groups = %q, want %q", u.Groups, want)
+	}
+}
+
+func TestAuthenticateWrongPassword(t *testing.T) {
+	d := newDirectory(newServer())
+	for _, login := range []string{"alice", "nobody"} {
+		if _, err := d.Authenticate(login, "wrong"); !errors.Is(err, ldap.ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, wrong password) error = %v, want ErrInvalidCredentials", login, err)
+		}
+	}
+}
+
+func TestAuthenticateWrongServicePassword(t *testing.T) {
+	d := newDirectory(newServer())
+	d.BindPassword = "wrong"
+
+	// A misconfigured service account is an outage, not a wrong password
+	_, err := d.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Errorf("error = %v, want the bind failure", err)
+	}
+}
+
+func TestAuthenticateEmptyPassword(t *testing.T) {
+	s := newServer()
+	d := newDirectory(s)
+
+	// Servers treat a bind with a DN and no password as anonymous and
+	// report success, so it must never reach them.
+	if _, err := d.Authenticate("alice", ""); !errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Errorf("Authenticate with an empty password: error = %v, want ErrInvalidCredentials", err)
+	}
+	if n := s.Dials(); n != 0 {
+		t.Errorf("empty password opened %d connections, want 0", n)
+	}
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := ldap.NewConn(c, 2*time.Second)
+	defer conn.Close()
+	if err := conn.Bind(aliceDN, ""); !ldap.IsInvalidCredentials(err) {
+		t.Errorf("Bind with an empty password: error = %v, want invalid credentials", err)
+	}
+	if binds := s.Binds(); len(binds) != 0 {
+		t.Errorf("bind requests sent = %q, want none", binds)
+	}
+}
+
+func TestAuthenticateEscapesFilter(t *testing.T) {
+	// Unescaped, each of these would widen the filter to match alice
+	for _, login := range []string{"*", "alice)(uid=*", "al*", `alice\`, "(uid=alice)"} {
+		s := newServer()
+		d := newDirectory(s)
+
+		if _, err := d.Authenticate(login, "alice-secret"); !errors.Is(err, ldap.ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrInvalidCredentials", login, err)
+		}
+
+		filters := s.Filters()
+		if len(filters) != 1 {
+			t.Fatalf("Authenticate(%q) sent %d searches, want 1", login, len(filters))
+		}
+		want := []string{"person", login, login}
+		if got := ldaptest.EqualityValues(filters[0]); !reflect.DeepEqual(got, want) {
+			t.Errorf("Authenticate(%q) searched for %q, want %q", login, got, want)
+		}
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	tests := map[string]string{
+		"alice":            "alice",
+		`*()\`:             `\2a\28\29\5c`,
+		"a*b":              `a\2ab`,
+		"x\x00y":           `x\00y`,
+		"é":                `\c3\a9`,
+		"alice)(uid=*)(x=": `alice\29\28uid=\2a\29\28x=`,
+	}
+	for in, want := range tests {
+		if got := ldap.EscapeFilter(in); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", in, got, want)
+		}
+		// Escaped values compile to an equality match on the original
+		f, err := ldap.CompileFilter("(uid=" + ldap.EscapeFilter(in) + ")")
+		if err != nil {
+			t.Errorf("CompileFilter with %q: %v", in, err)
+			continue
+		}
+		if got := ldaptest.EqualityValues(f); len(got) != 1 || got[0] != in {
+			t.Errorf("filter for %q matches %q", in, got)
+		}
+	}
+}
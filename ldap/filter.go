@@ -0,0 +1,184 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Search filters (RFC 4515), compiled to their BER form (RFC 4511 4.5.1).
+// Supported: & | ! = =* ~= >= <= and substrings (a*b*c).
+
+// Filter choice tags
+const (
+	filterAnd          = 0
+	filterOr           = 1
+	filterNot          = 2
+	filterEquality     = 3
+	filterSubstrings   = 4
+	filterGreaterEqual = 5
+	filterLessEqual    = 6
+	filterPresent      = 7
+	filterApprox       = 8
+)
+
+// EscapeFilter escapes a value for use inside a filter, so user input
+// can't change the filter's meaning.
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '*', c == '(', c == ')', c == '\\', c == 0, c >= 0x80:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// CompileFilter parses a filter string such as "(&(objectClass=person)(uid=jo))".
+func CompileFilter(s string) (*Packet, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && s[0] != '(' {
+		s = "(" + s + ")" // the outer parentheses are optional
+	}
+	p, rest, err := parseFilter(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return p, nil
+}
+
+func parseFilter(s string, depth int) (*Packet, string, error) {
+	if depth > maxNesting {
+		return nil, "", fmt.Errorf("ldap: filter nested too deeply")
+	}
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: filter must start with '('")
+	}
+	s = s[1:]
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		set := NewConstructed(ClassContext, tag)
+		s = s[1:]
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseFilter(s, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			set.Children = append(set.Children, child)
+			s = rest
+		}
+		if len(set.Children) == 0 || len(s) == 0 || s[0] != ')' {
+			return nil, "", fmt.Errorf("ldap: bad filter list")
+		}
+		return set, s[1:], nil
+
+	case '!':
+		child, rest, err := parseFilter(s[1:], depth+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", fmt.Errorf("ldap: bad negation")
+		}
+		return NewConstructed(ClassContext, filterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	p, err := parseItem(item)
+	return p, rest, err
+}
+
+// parseItem compiles a simple "attr<op>value" item.
+func parseItem(item string) (*Packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: bad filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("ldap: bad filter item %q", item)
+	}
+
+	if tag == filterEquality && value == "*" {
+		return NewPrimitive(ClassContext, filterPresent, []byte(attr)), nil
+	}
+
+	if tag == filterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := Sequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeValue(part)
+			if err != nil {
+				return nil, err
+			}
+			kind := byte(1) // any
+			switch i {
+			case 0:
+				kind = 0 // initial
+			case len(parts) - 1:
+				kind = 2 // final
+			}
+			subs.Children = append(subs.Children, NewPrimitive(ClassContext, kind, []byte(v)))
+		}
+		return NewConstructed(ClassContext, filterSubstrings, OctetString(attr), subs), nil
+	}
+
+	v, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return NewConstructed(ClassContext, tag, OctetString(attr), OctetString(v)), nil
+}
+
+// unescapeValue decodes the \XX escapes of a filter value.
+func unescapeValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		b.WriteByte(c[0])
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,233 @@
+// Package ldaptest runs an in-process fake directory for testing LDAP
+// clients. It answers simple binds against the entries it holds and
+// evaluates subtree searches over them, speaking real LDAPv3 messages
+// over a net.Pipe, so ldap.Directory.Dial can point straight at it.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"forum/ldap"
+)
+
+// Protocol operations and filter choices (RFC 4511), as the server sees them.
+const (
+	opBindRequest   = 0
+	opBindResponse  = 1
+	opUnbindRequest = 2
+	opSearchRequest = 3
+	opSearchEntry   = 4
+	opSearchDone    = 5
+
+	filterAnd      = 0
+	filterOr       = 1
+	filterNot      = 2
+	filterEquality = 3
+	filterPresent  = 7
+)
+
+// Entry is one directory object. Attribute names are matched without
+// regard to case.
+type Entry struct {
+	DN         string
+	Password   string // "" means binding as the entry always fails
+	Attributes map[string][]string
+}
+
+// Server is a fake directory. Anonymous binds are accepted; any other
+// bind needs an entry's DN and password. Filters support &, |, !,
+// equality (case-insensitive) and presence; other items never match.
+type Server struct {
+	Entries []*Entry
+
+	mu      sync.Mutex
+	dials   int
+	binds   []string
+	filters []*ldap.Packet
+}
+
+// Dial opens a connection to the server, for use as ldap.Directory.Dial.
+func (s *Server) Dial() (net.Conn, error) {
+	s.mu.Lock()
+	s.dials++
+	s.mu.Unlock()
+
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+// Dials returns how many connections were opened.
+func (s *Server) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+// Binds returns the DNs of every bind request received, in order.
+func (s *Server) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+// Filters returns the filter of every search request received, in order.
+func (s *Server) Filters() []*ldap.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*ldap.Packet(nil), s.filters...)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ldap.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id, err := msg.Child(0).Int()
+		if err != nil {
+			return
+		}
+		op := msg.Child(1)
+
+		var replies []*ldap.Packet
+		switch {
+		case op.Is(ldap.ClassApplication, opBindRequest):
+			replies = []*ldap.Packet{s.bind(op)}
+		case op.Is(ldap.ClassApplication, opSearchRequest):
+			replies = s.search(op)
+		default:
+			// Unbind, or anything the fake doesn't know
+			return
+		}
+		for _, reply := range replies {
+			if _, err := conn.Write(ldap.Sequence(ldap.Integer(id), reply).Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(op byte, code int, msg string) *ldap.Packet {
+	return ldap.NewConstructed(ldap.ClassApplication, op,
+		ldap.Enumerated(int64(code)), ldap.OctetString(""), ldap.OctetString(msg))
+}
+
+func (s *Server) bind(op *ldap.Packet) *ldap.Packet {
+	dn, password := op.Child(1).Str(), op.Child(2).Str()
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+
+	if dn == "" && password == "" {
+		return result(opBindResponse, ldap.ResultSuccess, "")
+	}
+	for _, e := range s.Entries {
+		if ldap.SameDN(e.DN, dn) && e.Password != "" && e.Password == password {
+			return result(opBindResponse, ldap.ResultSuccess, "")
+		}
+	}
+	return result(opBindResponse, ldap.ResultInvalidCredentials, "invalid credentials")
+}
+
+func (s *Server) search(op *ldap.Packet) []*ldap.Packet {
+	base := strings.ToLower(op.Child(0).Str())
+	sizeLimit, _ := op.Child(3).Int()
+	filter := op.Child(6)
+	var attrs []string
+	for _, a := range op.Child(7).Children {
+		attrs = append(attrs, a.Str())
+	}
+
+	s.mu.Lock()
+	s.filters = append(s.filters, filter)
+	s.mu.Unlock()
+
+	var replies []*ldap.Packet
+	for _, e := range s.Entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), base) || !e.matches(filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(replies)) == sizeLimit {
+			return append(replies, result(opSearchDone, ldap.ResultSizeLimitExceeded, ""))
+		}
+		replies = append(replies, e.packet(attrs))
+	}
+	return append(replies, result(opSearchDone, ldap.ResultSuccess, ""))
+}
+
+// values returns the values of attr.
+func (e *Entry) values(attr string) []string {
+	for name, vals := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return vals
+		}
+	}
+	return nil
+}
+
+func (e *Entry) matches(f *ldap.Packet) bool {
+	switch {
+	case f.Is(ldap.ClassContext, filterAnd):
+		for _, c := range f.Children {
+			if !e.matches(c) {
+				return false
+			}
+		}
+		return true
+	case f.Is(ldap.ClassContext, filterOr):
+		for _, c := range f.Children {
+			if e.matches(c) {
+				return true
+			}
+		}
+		return false
+	case f.Is(ldap.ClassContext, filterNot):
+		return !e.matches(f.Child(0))
+	case f.Is(ldap.ClassContext, filterPresent):
+		return len(e.values(string(f.Value))) > 0
+	case f.Is(ldap.ClassContext, filterEquality):
+		for _, v := range e.values(f.Child(0).Str()) {
+			if strings.EqualFold(v, f.Child(1).Str()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// packet encodes e as a search result entry with the requested attributes.
+func (e *Entry) packet(attrs []string) *ldap.Packet {
+	list := ldap.Sequence()
+	for _, a := range attrs {
+		vals := e.values(a)
+		if len(vals) == 0 {
+			continue
+		}
+		set := ldap.NewConstructed(ldap.ClassUniversal, ldap.TagSet)
+		for _, v := range vals {
+			set.Children = append(set.Children, ldap.OctetString(v))
+		}
+		list.Children = append(list.Children, ldap.Sequence(ldap.OctetString(a), set))
+	}
+	return ldap.NewConstructed(ldap.ClassApplication, opSearchEntry, ldap.OctetString(e.DN), list)
+}
+
+// EqualityValues returns the asserted values of every equality item in
+// filter, in order: what the server was actually asked to match.
+func EqualityValues(filter *ldap.Packet) []string {
+	if filter.Is(ldap.ClassContext, filterEquality) {
+		return []string{filter.Child(1).Str()}
+	}
+	var out []string
+	if filter.Constructed && (filter.Is(ldap.ClassContext, filterAnd) ||
+		filter.Is(ldap.ClassContext, filterOr) || filter.Is(ldap.ClassContext, filterNot)) {
+		for _, c := range filter.Children {
+			out = append(out, EqualityValues(c)...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/ldap"
+	"forum/ldap/ldaptest"
+)
+
+const (
+	ldapServiceDN = "cn=forum,ou=services,dc=example,dc=com"
+	ldapAdminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+func ldapPerson(uid, mail, password string, groups ...string) *ldaptest.Entry {
+	return &ldaptest.Entry{
+		DN:       "uid=" + uid + ",ou=people,dc=example,dc=com",
+		Password: password,
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {uid},
+			"mail":        {mail},
+			"memberOf":    groups,
+		},
+	}
+}
+
+// useLDAP makes the login form check passwords against s first, then
+// against local accounts, until the test ends.
+func useLDAP(t *testing.T, s *ldaptest.Server) {
+	t.Helper()
+	auth.SetLoginBackends([]auth.Authenticator{
+		&auth.LDAPAuthenticator{
+			Directory: &ldap.Directory{
+				BindDN:       ldapServiceDN,
+				BindPassword: "service-secret",
+				BaseDN:       "dc=example,dc=com",
+				UserFilter:   "(&(objectClass=person)(|(mail=%s)(uid=%s)))",
+				EmailAttr:    "mail",
+				UsernameAttr: "uid",
+				GroupAttr:    "memberOf",
+				Timeout:      2 * time.Second,
+				Dial:         s.Dial,
+			},
+			AdminGroups: []string{ldapAdminsDN},
+		},
+		auth.LocalAuthenticator{},
+	})
+	t.Cleanup(func() { auth.SetLoginBackends([]auth.Authenticator{auth.LocalAuthenticator{}}) })
+}
+
+func newLoginServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", auth.LoginHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postLogin submits the login form and returns the response status.
+func postLogin(t *testing.T, srv *httptest.Server, client *http.Client, login, password string) int {
+	t.Helper()
+	resp, err := client.PostForm(srv.URL+"/login", url.Values{"email": {login}, "password": {password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestLDAPLoginProvisionsAccount(t *testing.T) {
+	resetThrottle(t)
+	s := &ldaptest.Server{Entries: []*ldaptest.Entry{
+		{DN: ldapServiceDN, Password: "service-secret"},
+		ldapPerson("carol", "carol@example.com", "carol-secret", ldapAdminsDN),
+	}}
+	useLDAP(t, s)
+	srv := newLoginServer(t)
+
+	client := newClient(t)
+	if code := postLogin(t, srv, client, "carol", "carol-secret"); code != http.StatusSeeOther {
+		t.Fatalf("login: status %d, want %d", code, http.StatusSeeOther)
+	}
+	userID := sessionUser(t, client, srv)
+	if userID == 0 {
+		t.Fatal("login created no session")
+	}
+
+	var (
+		email, username, password, role string
+		verified                        bool
+	)
+	err := database.DB.QueryRow(
+		"SELECT email, username, password, role, email_verified FROM users WHERE id = ?", userID,
+	).Scan(&email, &username, &password, &role, &verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "carol@example.com" || username != "carol" || password != "" || !verified {
+		t.Errorf("provisioned account = %q %q password %q verified %v", email, username, password, verified)
+	}
+	if role != auth.RoleAdmin {
+		t.Errorf("role = %q, want %q from the admins group", role, auth.RoleAdmin)
+	}
+
+	var subject string
+	err = database.DB.QueryRow(
+		"SELECT subject FROM user_identities WHERE user_id = ? AND provider = 'ldap'", userID,
+	).Scan(&subject)
+	if err != nil || subject != strings.ToLower(s.Entries[1].DN) {
+		t.Errorf("identity subject = %q (%v), want the entry's DN", subject, err)
+	}
+
+	// The next login finds the same account
+	again := newClient(t)
+	postLogin(t, srv, again, "carol@example.com", "carol-secret")
+	if got := sessionUser(t, again, srv); got != userID {
+		t.Errorf("second login as user %d, want %d", got, userID)
+	}
+	var accounts int
+	database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'carol@example.com'").Scan(&accounts)
+	if accounts != 1 {
+		t.Errorf("%d accounts for carol, want 1", accounts)
+	}
+}
+
+func TestLDAPLoginAdoptsLocalAccount(t *testing.T) {
+	resetThrottle(t)
+	localID := newUser(t, "dave@example.com", "dave_local", "Zebra-Orbit-991", false)
+	s := &ldaptest.Server{Entries: []*ldaptest.Entry{
+		{DN: ldapServiceDN, Password: "service-secret"},
+		ldapPerson("dave", "dave@example.com", "dave-secret"),
+	}}
+	useLDAP(t, s)
+	srv := newLoginServer(t)
+
+	client := newClient(t)
+	postLogin(t, srv, client, "dave", "dave-secret")
+	if got := sessionUser(t, client, srv); got != localID {
+		t.Fatalf("logged in as user %d, want the existing account %d", got, localID)
+	}
+
+	var (
+		linked   int
+		verified bool
+		role     string
+	)
+	database.DB.QueryRow(
+		"SELECT COUNT(*) FROM user_identities WHERE user_id = ? AND provider = 'ldap'", localID,
+	).Scan(&linked)
+	database.DB.QueryRow("SELECT email_verified, role FROM users WHERE id = ?", localID).Scan(&verified, &role)
+	if linked != 1 || !verified {
+		t.Errorf("adopted account: %d ldap identities, verified %v; want 1 and true", linked, verified)
+	}
+	if role != auth.RoleMember {
+		t.Errorf("role = %q, want %q (not in a mapped group)", role, auth.RoleMember)
+	}
+
+	// The local password keeps working alongside the directory
+	local := newClient(t)
+	postLogin(t, srv, local, "dave@example.com", "Zebra-Orbit-991")
+	if got := sessionUser(t, local, srv); got != localID {
+		t.Errorf("local password login as user %d, want %d", got, localID)
+	}
+}
+
+func TestLDAPLoginRejected(t *testing.T) {
+	s := &ldaptest.Server{Entries: []*ldaptest.Entry{
+		{DN: ldapServiceDN, Password: "service-secret"},
+		ldapPerson("erin", "erin@example.com", "erin-secret"),
+	}}
+	useLDAP(t, s)
+	srv := newLoginServer(t)
+
+	for _, tt := range []struct{ name, login, password string }{
+		{"wrong password", "erin", "wrong"},
+		{"empty password", "erin", ""},
+		{"wildcard login", "*", "erin-secret"},
+		{"injected filter", "erin)(uid=*", "erin-secret"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetThrottle(t)
+			client := newClient(t)
+			if code := postLogin(t, srv, client, tt.login, tt.password); code != http.StatusOK {
+				t.Errorf("status %d, want the login form again (%d)", code, http.StatusOK)
+			}
+			if got := sessionUser(t, client, srv); got != 0 {
+				t.Errorf("refused login created a session for user %d", got)
+			}
+		})
+	}
+
+	var provisioned int
+	database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'erin@example.com'").Scan(&provisioned)
+	if provisioned != 0 {
+		t.Errorf("refused logins provisioned %d accounts", provisioned)
+	}
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/config"
+)
+
+// Mailer delivers plain-text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// ------------------------------------------------------------
+// SMTP
+// ------------------------------------------------------------
+
+// SMTPMailer sends mail through an SMTP server.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + headerValue(m.From),
+		"To: " + headerValue(to),
+		"Subject: " + headerValue(subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// headerValue strips line breaks so user input cannot inject headers.
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// ------------------------------------------------------------
+// WRITER (stdout / file) — for local development and tests
+// ------------------------------------------------------------
+
+// WriterMailer writes every email to W instead of sending it.
+type WriterMailer struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func (m *WriterMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.W,
+		"---- MAIL %s ----\nTo: %s\nSubject: %s\n\n%s\n---- END MAIL ----\n",
+		time.Now().Format(time.RFC3339), to, subject, body,
+	)
+	return err
+}
+
+// ------------------------------------------------------------
+// DEFAULT MAILER
+// ------------------------------------------------------------
+
+var (
+	defaultMailer Mailer
+	defaultOnce   sync.Once
+)
+
+// Default returns the mailer selected by FORUM_MAILER:
+//   - "smtp"  send through FORUM_SMTP_HOST / _PORT / _USER / _PASSWORD
+//   - "file"  append to FORUM_MAIL_FILE (default mail.log)
+//   - "log"   (default) print to stdout
+func Default() Mailer {
+	defaultOnce.Do(func() {
+		defaultMailer = fromConfig()
+	})
+	return defaultMailer
+}
+
+// SetDefault replaces the mailer returned by Default.
+func SetDefault(m Mailer) {
+	defaultOnce.Do(func() {})
+	defaultMailer = m
+}
+
+func fromConfig() Mailer {
+	switch config.String("FORUM_MAILER", "log") {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     config.String("FORUM_SMTP_HOST", "localhost"),
+			Port:     config.String("FORUM_SMTP_PORT", "587"),
+			Username: config.String("FORUM_SMTP_USER", ""),
+			Password: config.String("FORUM_SMTP_PASSWORD", ""),
+			From:     config.String("FORUM_MAIL_FROM", "forum@localhost"),
+		}
+
+	case "file":
+		path := config.String("FORUM_MAIL_FILE", "mail.log")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Printf("Cannot open mail file %s, falling back to stdout: %v", path, err)
+			return &WriterMailer{W: os.Stdout}
+		}
+		return &WriterMailer{W: f}
+
+	default:
+		return &WriterMailer{W: os.Stdout}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"html/template"
 	"log"
 	"net/http"
 
@@ -11,17 +10,20 @@ import (
 	comments "forum/handlers/comments"
 	likes "forum/handlers/likes"
 	posts "forum/handlers/posts"
+	search "forum/handlers/search"
 	"forum/models"
+	"forum/pagination"
 )
 
-var templates *template.Template
-
 // ------------------------------------------------------------
 // DATA STRUCTS
 // ------------------------------------------------------------
 type HomeData struct {
 	User       *auth.SessionUser
 	Posts      []models.Post
+	Page       pagination.Page
+	Sort       posts.Sort
+	Filter     posts.FeedFilter
 	Categories []models.Category
 }
 
@@ -48,30 +50,75 @@ func (sr *statusRecorder) Write(b []byte) (int, error) {
 // ------------------------------------------------------------
 func main() {
 	database.InitDB()
-	loadTemplates()
+	auth.BootstrapAdmins()
+	auth.InviteBootstrapAdmins()
+	auth.SealTOTPSecrets()
+	auth.StartDeletionSweeper()
 
 	mux := http.NewServeMux()
 
 	// AUTH
-	mux.HandleFunc("/", homeHandler)
+	mux.HandleFunc("/", auth.AllowTokenReads(homeHandler))
 	mux.HandleFunc("/register", auth.RegisterHandler)
 	mux.HandleFunc("/login", auth.LoginHandler)
+	mux.HandleFunc("/login/2fa", auth.TwoFactorLoginHandler)
+	mux.HandleFunc("/login/magic", auth.MagicLinkHandler)
+	mux.HandleFunc("/login/magic/confirm", auth.MagicLinkConfirmHandler)
+	mux.HandleFunc("/webauthn/login/begin", auth.PasskeyLoginBeginHandler)
+	mux.HandleFunc("/webauthn/login/finish", auth.PasskeyLoginFinishHandler)
+	mux.HandleFunc("/webauthn/register/begin", auth.PasskeyRegisterBeginHandler)
+	mux.HandleFunc("/webauthn/register/finish", auth.PasskeyRegisterFinishHandler)
+	mux.HandleFunc("/oauth/start", auth.OAuthStartHandler)
+	mux.HandleFunc("/oauth/callback", auth.OAuthCallbackHandler)
 	mux.HandleFunc("/logout", auth.LogoutHandler)
+	mux.HandleFunc("/forgot-password", auth.ForgotPasswordHandler)
+	mux.HandleFunc("/reset-password", auth.ResetPasswordHandler)
+	mux.HandleFunc("/verify-email", auth.VerifyEmailHandler)
+	mux.HandleFunc("/resend-verification", auth.ResendVerificationHandler)
+
+	// SESSIONS
+	mux.HandleFunc("/sessions", auth.SessionsHandler)
+	mux.HandleFunc("/sessions/revoke", auth.RevokeSessionHandler)
+	mux.HandleFunc("/logout-all", auth.LogoutEverywhereHandler)
+
+	// SETTINGS
+	mux.HandleFunc("/settings", auth.SettingsHandler)
+	mux.HandleFunc("/settings/export", auth.ExportHandler)
+	mux.HandleFunc("/settings/delete", auth.DeleteAccountHandler)
+	mux.HandleFunc("/settings/tokens", auth.AccessTokensHandler)
+	mux.HandleFunc("/settings/2fa", auth.TwoFactorSettingsHandler)
+	mux.HandleFunc("/settings/identities", auth.IdentitiesHandler)
+	mux.HandleFunc("/settings/passkeys", auth.PasskeysHandler)
 
 	// POSTS
-	mux.HandleFunc("/create-post", posts.CreatePostHandler)
-	mux.HandleFunc("/post", posts.ViewPostHandler)
-	mux.HandleFunc("/my-posts", posts.MyPostsHandler)
-	mux.HandleFunc("/liked-posts", posts.LikedPostsHandler)
+	mux.HandleFunc("/create-post", auth.Require(auth.PermCreatePost, posts.CreatePostHandler))
+	mux.HandleFunc("/post", auth.AllowTokenReads(posts.ViewPostHandler))
+	mux.HandleFunc("/edit-post", auth.Require(auth.PermEditPost, posts.EditPostHandler))
+	mux.HandleFunc("/delete-post", auth.Require(auth.PermEditPost, posts.DeletePostHandler))
+	mux.HandleFunc("/post-history", auth.AllowTokenReads(posts.PostHistoryHandler))
+	mux.HandleFunc("/preview", posts.PreviewHandler)
+	mux.HandleFunc("/my-posts", auth.AllowTokenReads(posts.MyPostsHandler))
+	mux.HandleFunc("/liked-posts", auth.AllowTokenReads(posts.LikedPostsHandler))
+	mux.HandleFunc("/images/", posts.ImageHandler)
 
 	// COMMENTS
-	mux.HandleFunc("/create-comment", comments.CreateCommentHandler)
+	mux.HandleFunc("/create-comment", auth.Require(auth.PermComment, comments.CreateCommentHandler))
+	mux.HandleFunc("/edit-comment", auth.Require(auth.PermEditComment, comments.EditCommentHandler))
+	mux.HandleFunc("/delete-comment", auth.Require(auth.PermEditComment, comments.DeleteCommentHandler))
 
 	// CATEGORIES
-	mux.HandleFunc("/category", categories.ViewCategoryHandler)
+	mux.HandleFunc("/category", auth.AllowTokenReads(categories.ViewCategoryHandler))
+
+	// SEARCH
+	mux.HandleFunc("/search", auth.AllowTokenReads(search.SearchHandler))
 
 	// LIKES
-	mux.HandleFunc("/like", likes.LikeHandler)
+	mux.HandleFunc("/like", auth.Require(auth.PermVote, likes.LikeHandler))
+
+	// ADMIN
+	mux.HandleFunc("/admin/users", auth.Require(auth.PermManageUsers, auth.AdminUsersHandler))
+	mux.HandleFunc("/admin/lockouts", auth.Require(auth.PermManageLockouts, auth.AdminLockoutsHandler))
+	mux.HandleFunc("/invites", auth.Require(auth.PermInviteUsers, auth.InvitesHandler))
 
 	// STATIC FILES
 	static := http.FileServer(http.Dir("static"))
@@ -86,19 +133,20 @@ func main() {
 		defer func() {
 			if rec := recover(); rec != nil {
 				log.Println("Recovered panic:", rec)
-				render500(w)
+				render500(w, r)
 			}
 		}()
 
 		// Record output to detect unhandled routes
 		rec := &statusRecorder{ResponseWriter: w}
 
-		mux.ServeHTTP(rec, r)
+		// CSRF check for every state-changing request
+		auth.CSRFProtect(mux).ServeHTTP(rec, r)
 
 		// No handler wrote → Unknown route → 404
 		if !rec.written {
 			w.WriteHeader(http.StatusNotFound)
-			templates.ExecuteTemplate(w, "error_404.html", nil)
+			auth.Render(w, r, "error_404.html", nil)
 		}
 	})
 
@@ -108,23 +156,12 @@ func main() {
 	}
 }
 
-// ------------------------------------------------------------
-// TEMPLATE LOADER
-// ------------------------------------------------------------
-func loadTemplates() {
-	var err error
-	templates, err = template.ParseGlob("templates/*.html")
-	if err != nil {
-		log.Fatalf("Error parsing templates: %v", err)
-	}
-}
-
 // ------------------------------------------------------------
 // ERROR 500 PAGE
 // ------------------------------------------------------------
-func render500(w http.ResponseWriter) {
+func render500(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusInternalServerError)
-	templates.ExecuteTemplate(w, "error_500.html", nil)
+	auth.Render(w, r, "error_500.html", nil)
 }
 
 // ------------------------------------------------------------
@@ -140,72 +177,28 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	user, _ := auth.GetUserFromRequest(r)
 
 	// --------------------------------------------------------
-	// LOAD POSTS
+	// LOAD ONE PAGE OF POSTS
 	// --------------------------------------------------------
-	rows, err := database.DB.Query(`
-		SELECT posts.id,
-		       posts.user_id,
-		       users.username,
-		       posts.title,
-		       posts.content,
-		       strftime('%Y-%m-%d %H:%M:%S', posts.created_at)
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		ORDER BY posts.created_at DESC
-	`)
+	sort, err := posts.SortFromRequest(r)
 	if err != nil {
-		render500(w)
+		http.Error(w, "Invalid sort order", http.StatusBadRequest)
 		return
 	}
-
-	type rawPost struct {
-		ID        int
-		UserID    int
-		Username  string
-		Title     string
-		Content   string
-		CreatedAt string
+	filter, err := posts.FilterFromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid filter", http.StatusBadRequest)
+		return
 	}
-
-	var raw []rawPost
-
-	for rows.Next() {
-		var rp rawPost
-		if err := rows.Scan(
-			&rp.ID, &rp.UserID, &rp.Username,
-			&rp.Title, &rp.Content, &rp.CreatedAt,
-		); err == nil {
-			raw = append(raw, rp)
-		}
+	req, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid page cursor", http.StatusBadRequest)
+		return
 	}
-	rows.Close()
-
-	// Convert raw → full post model
-	var postsList []models.Post
-
-	for _, rp := range raw {
-		p := models.Post{
-			ID:        rp.ID,
-			UserID:    rp.UserID,
-			Username:  rp.Username,
-			Title:     rp.Title,
-			Content:   rp.Content,
-			CreatedAt: rp.CreatedAt,
-		}
-
-		// Categories
-		cats, _ := posts.GetCategoriesForPost(p.ID)
-		for _, c := range cats {
-			p.Categories = append(p.Categories, models.Category{
-				ID:   c.ID,
-				Name: c.Name,
-			})
-		}
-
-		// Likes / Dislikes
-		p.Likes, p.Dislikes = likes.CountPostLikes(p.ID)
-
-		postsList = append(postsList, p)
+	feed, err := posts.LoadFeed(filter.Query(), sort, req)
+	if err != nil {
+		log.Println("Error loading posts:", err)
+		render500(w, r)
+		return
 	}
 
 	// --------------------------------------------------------
@@ -215,7 +208,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		SELECT id, name FROM categories ORDER BY name ASC
 	`)
 	if err != nil {
-		render500(w)
+		render500(w, r)
 		return
 	}
 	defer catRows.Close()
@@ -231,11 +224,14 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	// Data → Template
 	data := HomeData{
 		User:       user,
-		Posts:      postsList,
+		Posts:      feed.Posts,
+		Page:       feed.Page,
+		Sort:       feed.Sort,
+		Filter:     filter,
 		Categories: allCats,
 	}
 
-	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
-		render500(w)
+	if err := auth.Render(w, r, "index.html", data); err != nil {
+		render500(w, r)
 	}
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"forum/database"
+	auth "forum/handlers"
+	pw "forum/password"
+)
+
+// The end-to-end tests live in package main, whose directory holds the
+// templates the handlers parse when they load. TestMain then moves to a
+// temporary directory so the tests run against a fresh forum.db.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+
+	schema, err := filepath.Abs("database/schema.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "forum-test-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "database"), 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Symlink(schema, filepath.Join(dir, "database", "schema.sql")); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	database.InitDB()
+
+	code := m.Run()
+	database.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newUser inserts an account and returns its ID. An empty password makes
+// an account that can only log in through an external provider.
+func newUser(t *testing.T, email, username, password string, verified bool) int {
+	t.Helper()
+	hash := ""
+	if password != "" {
+		var err error
+		if hash, err = pw.Hash(password); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := database.DB.Exec(
+		"INSERT INTO users (email, username, password, email_verified) VALUES (?, ?, ?, ?)",
+		email, username, hash, verified,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// newClient returns a browser stand-in: it keeps cookies but doesn't
+// follow redirects, so tests can check each step.
+func newClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// logIn gives client a session for userID on srv.
+func logIn(t *testing.T, client *http.Client, srv *httptest.Server, userID int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := auth.CreateSession(rec, httptest.NewRequest("GET", "/", nil), userID); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL)
+	client.Jar.SetCookies(u, rec.Result().Cookies())
+}
+
+// sessionUser returns the user client is logged in as on srv, or 0.
+func sessionUser(t *testing.T, client *http.Client, srv *httptest.Server) int {
+	t.Helper()
+	u, _ := url.Parse(srv.URL)
+	for _, c := range client.Jar.Cookies(u) {
+		if c.Name != "session_id" {
+			continue
+		}
+		var userID int
+		err := database.DB.QueryRow("SELECT user_id FROM sessions WHERE id = ?", c.Value).Scan(&userID)
+		if err == nil {
+			return userID
+		}
+	}
+	return 0
+}
+
+// resetThrottle forgets failed attempts, so one test's failures can't
+// lock the next one out.
+func resetThrottle(t *testing.T) {
+	t.Helper()
+	if _, err := database.DB.Exec("DELETE FROM auth_throttle"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,467 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// inliner renders the text of one block: code spans, emphasis, links,
+// images, autolinks and line breaks. Everything else is escaped text.
+type inliner struct {
+	out     *strings.Builder
+	text    strings.Builder // literal text not yet escaped and written
+	depth   int
+	noLinks bool // inside a link's text
+
+	// Positions from which a closing backtick run (by length) or
+	// emphasis delimiter is known not to exist, so that unclosed openers
+	// don't make rendering quadratic
+	noCodeEnd map[int]int
+	noCloser  map[string]int
+}
+
+// maxLinkText bounds how far "[" looks for its "]", and maxLinkTarget
+// how far a link's destination and title (or an autolink) may reach.
+const (
+	maxLinkText   = 1000
+	maxLinkTarget = 4000
+)
+
+func renderInline(b *strings.Builder, s string, depth int) {
+	in := &inliner{out: b, depth: depth}
+	in.run(s)
+}
+
+func (in *inliner) flush() {
+	in.out.WriteString(html.EscapeString(in.text.String()))
+	in.text.Reset()
+}
+
+// tag writes markup after any pending text.
+func (in *inliner) tag(s string) {
+	in.flush()
+	in.out.WriteString(s)
+}
+
+// nested renders s (e.g. the inside of emphasis) one level deeper.
+func (in *inliner) nested(s string, noLinks bool) {
+	in.flush()
+	child := &inliner{out: in.out, depth: in.depth + 1, noLinks: in.noLinks || noLinks}
+	child.run(s)
+}
+
+func (in *inliner) run(s string) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && isPunct(s[i+1]) {
+				in.text.WriteByte(s[i+1])
+				i += 2
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\n' {
+				in.lineBreak(true)
+				i += 2
+				continue
+			}
+
+		case '\n':
+			// Two trailing spaces make a hard break
+			in.lineBreak(strings.HasSuffix(in.text.String(), "  "))
+			i++
+			continue
+
+		case '`':
+			if end := in.codeSpanEnd(s, i); end > 0 {
+				n := runLen(s, i, '`')
+				in.codeSpan(s[i+n : end-n])
+				i = end
+				continue
+			}
+			n := runLen(s, i, '`')
+			in.text.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case '*', '_', '~':
+			if n := in.emphasis(s, i); n > 0 {
+				i += n
+				continue
+			}
+			n := runLen(s, i, c)
+			in.text.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case '!':
+			if i+1 < len(s) && s[i+1] == '[' {
+				if n := in.link(s[i:], true); n > 0 {
+					i += n
+					continue
+				}
+			}
+
+		case '[':
+			if !in.noLinks {
+				if n := in.link(s[i:], false); n > 0 {
+					i += n
+					continue
+				}
+			}
+
+		case '<':
+			if !in.noLinks {
+				if n := in.autolink(s[i:]); n > 0 {
+					i += n
+					continue
+				}
+			}
+
+		case 'h':
+			if !in.noLinks && (i == 0 || !isAlnum(s[i-1])) {
+				if n := in.bareURL(s[i:]); n > 0 {
+					i += n
+					continue
+				}
+			}
+		}
+
+		in.text.WriteByte(c)
+		i++
+	}
+	in.flush()
+}
+
+func (in *inliner) lineBreak(hard bool) {
+	text := strings.TrimRight(in.text.String(), " ")
+	in.text.Reset()
+	in.text.WriteString(text)
+	if hard {
+		in.tag("<br>\n")
+	} else {
+		in.text.WriteByte('\n')
+	}
+}
+
+func (in *inliner) codeSpan(code string) {
+	code = strings.ReplaceAll(code, "\n", " ")
+	// One space on both sides is padding (so code can start with a backtick)
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	in.tag("<code>" + html.EscapeString(code) + "</code>")
+}
+
+// emphasis handles *em*, _em_, **strong**, __strong__ and ~~del~~ at
+// s[i], returning the number of bytes consumed (0 = not emphasis).
+func (in *inliner) emphasis(s string, i int) int {
+	c := s[i]
+	run := runLen(s, i, c)
+
+	// An opener is followed by text; "_" must also start a word, so that
+	// snake_case_names stay as they are
+	if in.depth >= maxNesting || i+run >= len(s) || isSpace(s[i+run]) {
+		return 0
+	}
+	if c == '_' && i > 0 && isAlnum(s[i-1]) {
+		return 0
+	}
+
+	var n int
+	var open, close string
+	switch {
+	case c == '~' && run == 2:
+		n, open, close = 2, "<del>", "</del>"
+	case c == '~':
+		return 0
+	case run >= 2:
+		n, open, close = 2, "<strong>", "</strong>"
+	default:
+		n, open, close = 1, "<em>", "</em>"
+	}
+
+	end := in.findCloser(s, i+n, c, n)
+	if end < 0 {
+		return 0
+	}
+	in.tag(open)
+	in.nested(s[i+n:end], false)
+	in.tag(close)
+	return end + n - i
+}
+
+// findCloser returns the index of the delimiter run of n c's closing an
+// emphasis whose text starts at from, or -1.
+func (in *inliner) findCloser(s string, from int, c byte, n int) int {
+	key := strings.Repeat(string(c), n)
+	if failed, ok := in.noCloser[key]; ok && from >= failed {
+		return -1
+	}
+
+	for j := from; j < len(s); {
+		switch s[j] {
+		case '\\':
+			j += 2
+			continue
+		case '`':
+			if end := in.codeSpanEnd(s, j); end > 0 {
+				j = end
+				continue
+			}
+		case c:
+			r := runLen(s, j, c)
+			closes := j > from && !isSpace(s[j-1]) &&
+				(c != '_' || j+r >= len(s) || !isAlnum(s[j+r]))
+			// A single delimiter skips pairs, which belong to nested strong text
+			if closes && (r == n || (r > n && !(n == 1 && r == 2))) {
+				return j + r - n
+			}
+			j += r
+			continue
+		}
+		j++
+	}
+
+	if in.noCloser == nil {
+		in.noCloser = map[string]int{}
+	}
+	in.noCloser[key] = from
+	return -1
+}
+
+// link handles [text](url "title") and ![alt](src "title") at the start
+// of s, returning the number of bytes consumed (0 = not a link).
+func (in *inliner) link(s string, image bool) int {
+	start := 1
+	if image {
+		start = 2
+	}
+
+	// Find the matching "]"
+	end, depth := -1, 0
+scan:
+	for j := start; j < len(s) && j < maxLinkText; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			if k := in.codeSpanEnd(s, j); k > 0 {
+				j = k - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				end = j
+				break scan
+			}
+			depth--
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return 0
+	}
+	label := s[start:end]
+
+	dest, title, n, ok := parseDestination(s[end+1:])
+	if !ok {
+		return 0
+	}
+	url := unescapePunct(dest)
+	if !safeURL(url) {
+		return 0
+	}
+
+	attrs := ""
+	if title != "" {
+		attrs = ` title="` + html.EscapeString(unescapePunct(title)) + `"`
+	}
+	if image {
+		in.tag(`<img src="` + html.EscapeString(url) + `" alt="` +
+			html.EscapeString(unescapePunct(label)) + `"` + attrs + `>`)
+	} else {
+		in.tag(`<a href="` + html.EscapeString(url) + `"` + attrs + `>`)
+		in.nested(label, true)
+		in.tag("</a>")
+	}
+	return end + 1 + n
+}
+
+// parseDestination parses `(url "title")`, returning the bytes consumed.
+func parseDestination(s string) (dest, title string, n int, ok bool) {
+	if len(s) > maxLinkTarget {
+		s = s[:maxLinkTarget]
+	}
+	i := skipSpace(s, 1)
+
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexAny(s[i+1:], ">\n")
+		if end < 0 || s[i+1+end] != '>' {
+			return "", "", 0, false
+		}
+		dest = s[i+1 : i+1+end]
+		i += end + 2
+	} else {
+		start, parens := i, 0
+	dest:
+		for i < len(s) {
+			switch s[i] {
+			case '\\':
+				i++
+			case ' ', '\n':
+				break dest
+			case '(':
+				parens++
+			case ')':
+				if parens == 0 {
+					break dest
+				}
+				parens--
+			}
+			i++
+		}
+		if i > len(s) {
+			return "", "", 0, false
+		}
+		dest = s[start:i]
+	}
+
+	i = skipSpace(s, i)
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := strings.IndexByte(s[i+1:], closer)
+		if end < 0 {
+			return "", "", 0, false
+		}
+		title = s[i+1 : i+1+end]
+		i = skipSpace(s, i+end+2)
+	}
+
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+// autolink handles <https://example.com> and <user@example.com>.
+func (in *inliner) autolink(s string) int {
+	if len(s) > maxLinkTarget {
+		s = s[:maxLinkTarget]
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return 0
+	}
+	target := s[1:end]
+	if target == "" || strings.ContainsAny(target, " \n<") {
+		return 0
+	}
+
+	href := target
+	lower := strings.ToLower(target)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+	case strings.Contains(target, "@") && !strings.Contains(target, ":"):
+		href = "mailto:" + target
+	default:
+		return 0
+	}
+
+	in.tag(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(target) + `</a>`)
+	return end + 1
+}
+
+// bareURL links a plain http(s):// address, leaving out punctuation
+// that more likely ends the sentence.
+func (in *inliner) bareURL(s string) int {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return 0
+	}
+	end := strings.IndexAny(s, " \n<")
+	if end < 0 {
+		end = len(s)
+	}
+	url := strings.TrimRight(s[:end], ".,:;!?\"'*_~")
+	for strings.HasSuffix(url, ")") && strings.Count(url, "(") < strings.Count(url, ")") {
+		url = url[:len(url)-1]
+	}
+	if strings.HasSuffix(url, "://") {
+		return 0
+	}
+
+	in.tag(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + `</a>`)
+	return len(url)
+}
+
+// codeSpanEnd returns the index just past the code span starting at
+// s[i], or 0 if the backticks are not closed by a run of the same length.
+func (in *inliner) codeSpanEnd(s string, i int) int {
+	n := runLen(s, i, '`')
+	if failed, ok := in.noCodeEnd[n]; ok && i >= failed {
+		return 0
+	}
+	for j := i + n; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			break
+		}
+		j += k
+		r := runLen(s, j, '`')
+		if r == n {
+			return j + r
+		}
+		j += r
+	}
+
+	if in.noCodeEnd == nil {
+		in.noCodeEnd = map[int]int{}
+	}
+	in.noCodeEnd[n] = i
+	return 0
+}
+
+// unescapePunct removes the backslash from escaped punctuation.
+func unescapePunct(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func runLen(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
@@ -0,0 +1,450 @@
+// Package markdown renders the forum's Markdown dialect to HTML:
+// headings, paragraphs, emphasis, strikethrough, links and images, inline
+// and fenced code, block quotes, nested lists, tables (GitHub style) and
+// horizontal rules. Raw HTML in the source is shown as text, and the
+// output always goes through Sanitize, so only allowlisted markup can
+// reach a page even if the renderer has a bug.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version changes whenever the same source may render differently, so
+// HTML cached by an older renderer can be rebuilt.
+const Version = 1
+
+// maxNesting bounds quotes and lists inside each other, and emphasis
+// inside emphasis; deeper input is rendered as text.
+const maxNesting = 16
+
+// Render converts Markdown source to sanitised HTML.
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0, false)
+	return Sanitize(b.String())
+}
+
+// ------------------------------------------------------------
+// BLOCKS
+// ------------------------------------------------------------
+
+var (
+	headingRe = regexp.MustCompile(`^(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	ruleRe    = regexp.MustCompile(`^(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+	fenceRe   = regexp.MustCompile("^(`{3,}|~{3,})[ ]*([^`\\s]*)[^`]*$")
+	delimRe   = regexp.MustCompile(`^:?-+:?$`)
+)
+
+// renderBlocks writes the blocks in lines. In a tight list item (tight)
+// paragraphs are written without <p>.
+func renderBlocks(b *strings.Builder, lines []string, depth int, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		indent := leadingSpaces(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case indent >= 4:
+			i = renderIndentedCode(b, lines, i)
+
+		case fenceRe.MatchString(trimmed):
+			i = renderFencedCode(b, lines, i)
+
+		case headingRe.MatchString(trimmed):
+			m := headingRe.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">")
+			renderInline(b, m[2], 0)
+			b.WriteString("</h" + level + ">\n")
+			i++
+
+		case ruleRe.MatchString(trimmed):
+			b.WriteString("<hr>\n")
+			i++
+
+		case trimmed[0] == '>' && depth < maxNesting:
+			i = renderQuote(b, lines, i, depth)
+
+		case isListStart(line) && depth < maxNesting:
+			i = renderList(b, lines, i, depth)
+
+		case isTableStart(lines, i):
+			i = renderTable(b, lines, i)
+
+		default:
+			i = renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+// startsBlock reports whether line begins a block that ends a paragraph.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || leadingSpaces(line) >= 4 {
+		return trimmed == ""
+	}
+	if fenceRe.MatchString(trimmed) || headingRe.MatchString(trimmed) ||
+		ruleRe.MatchString(trimmed) || trimmed[0] == '>' {
+		return true
+	}
+	// Only lists starting at 1 interrupt a paragraph, so that a line
+	// like "2024. was a year" isn't taken for a list
+	if m, ok := listMarker(line); ok {
+		return !m.ordered || m.start == 1
+	}
+	return false
+}
+
+func renderParagraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	var para []string
+	for ; i < len(lines); i++ {
+		if len(para) > 0 && startsBlock(lines[i]) {
+			break
+		}
+		para = append(para, strings.TrimLeft(lines[i], " "))
+
+		// Setext heading: a paragraph line underlined with = or -
+		if i+1 < len(lines) && len(para) == 1 {
+			under := strings.TrimSpace(lines[i+1])
+			if under != "" && leadingSpaces(lines[i+1]) < 4 &&
+				(strings.Trim(under, "=") == "" || strings.Trim(under, "-") == "") {
+				level := "1"
+				if under[0] == '-' {
+					level = "2"
+				}
+				b.WriteString("<h" + level + ">")
+				renderInline(b, strings.TrimSpace(para[0]), 0)
+				b.WriteString("</h" + level + ">\n")
+				return i + 2
+			}
+		}
+	}
+
+	text := strings.TrimRight(strings.Join(para, "\n"), " ")
+	if !tight {
+		b.WriteString("<p>")
+	}
+	renderInline(b, text, 0)
+	if !tight {
+		b.WriteString("</p>")
+	}
+	b.WriteString("\n")
+	return i
+}
+
+func renderIndentedCode(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" && leadingSpaces(lines[i]) < 4 {
+			break
+		}
+		if len(lines[i]) >= 4 {
+			code = append(code, lines[i][4:])
+		} else {
+			code = append(code, "")
+		}
+	}
+	for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+		code = code[:len(code)-1]
+	}
+	writeCodeBlock(b, code, "")
+	return i
+}
+
+func renderFencedCode(b *strings.Builder, lines []string, i int) int {
+	indent := leadingSpaces(lines[i])
+	m := fenceRe.FindStringSubmatch(strings.TrimSpace(lines[i]))
+	fence, lang := m[1], m[2]
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		t := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+			i++
+			break
+		}
+		// Content is unindented by as much as the opening fence was
+		line := lines[i]
+		if n := min(indent, leadingSpaces(line)); n > 0 {
+			line = line[n:]
+		}
+		code = append(code, line)
+	}
+	writeCodeBlock(b, code, lang)
+	return i
+}
+
+func writeCodeBlock(b *strings.Builder, code []string, lang string) {
+	b.WriteString("<pre><code")
+	if lang != "" {
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line))
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+}
+
+func renderQuote(b *strings.Builder, lines []string, i int, depth int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		t := strings.TrimLeft(lines[i], " ")
+		if strings.HasPrefix(t, ">") && leadingSpaces(lines[i]) < 4 {
+			t = strings.TrimPrefix(t[1:], " ")
+			inner = append(inner, t)
+			continue
+		}
+		// Lazy continuation of a quoted paragraph
+		if len(inner) > 0 && strings.TrimSpace(inner[len(inner)-1]) != "" && !startsBlock(lines[i]) {
+			inner = append(inner, t)
+			continue
+		}
+		break
+	}
+	b.WriteString("<blockquote>\n")
+	renderBlocks(b, inner, depth+1, false)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// ------------------------------------------------------------
+// LISTS
+// ------------------------------------------------------------
+
+type marker struct {
+	ordered bool
+	start   int
+	char    byte // bullet, or "." / ")" after a number
+	content int  // column where the item's text starts
+}
+
+// listMarker parses the start of a list item ("- ", "* ", "+ ", "1. ", "1) ").
+func listMarker(line string) (marker, bool) {
+	indent := leadingSpaces(line)
+	if indent >= 4 {
+		return marker{}, false
+	}
+	s := line[indent:]
+	var m marker
+
+	n := 0
+	for n < len(s) && n < 9 && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	switch {
+	case n == 0 && len(s) > 0 && (s[0] == '-' || s[0] == '*' || s[0] == '+'):
+		m.char, n = s[0], 1
+	case n > 0 && n < len(s) && (s[n] == '.' || s[n] == ')'):
+		m.ordered, m.char = true, s[n]
+		m.start, _ = strconv.Atoi(s[:n])
+		n++
+	default:
+		return marker{}, false
+	}
+
+	rest := s[n:]
+	if rest != "" && rest[0] != ' ' {
+		return marker{}, false
+	}
+	spaces := leadingSpaces(rest)
+	if spaces == 0 || spaces > 4 || spaces == len(rest) {
+		spaces = 1 // blank item, or an indented code block inside it
+	}
+	m.content = indent + n + spaces
+	return m, true
+}
+
+func isListStart(line string) bool {
+	_, ok := listMarker(line)
+	return ok
+}
+
+func renderList(b *strings.Builder, lines []string, i int, depth int) int {
+	first, _ := listMarker(lines[i])
+
+	var (
+		items     [][]string
+		content   int  // text column of the current item
+		blank     bool // a blank line was seen since the last text
+		loose     bool
+		lastBlank int
+	)
+lines:
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			blank = true
+			lastBlank = i
+			items[len(items)-1] = append(items[len(items)-1], "")
+			continue
+		}
+
+		m, ok := listMarker(line)
+		switch {
+		case ok && sameList(first, m) && (len(items) == 0 || leadingSpaces(line) < content):
+			// Next item of this list
+			if blank {
+				loose = true
+			}
+			content = m.content
+			text := ""
+			if len(line) > content {
+				text = line[content:]
+			}
+			items = append(items, []string{text})
+
+		case leadingSpaces(line) >= content:
+			// Continuation, possibly nested blocks
+			if blank {
+				loose = true
+			}
+			items[len(items)-1] = append(items[len(items)-1], line[content:])
+
+		case !blank && !startsBlock(line):
+			// Lazy continuation of the item's paragraph
+			items[len(items)-1] = append(items[len(items)-1], strings.TrimLeft(line, " "))
+
+		default:
+			break lines
+		}
+		blank = false
+	}
+
+	// Blank lines before whatever follows the list belong to neither
+	if blank {
+		i = lastBlank + 1
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, item := range items {
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+		}
+		b.WriteString("<li>")
+		renderBlocks(b, item, depth+1, !loose)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// sameList reports whether m continues a list that started with first.
+func sameList(first, m marker) bool {
+	return first.ordered == m.ordered && first.char == m.char
+}
+
+// ------------------------------------------------------------
+// TABLES
+// ------------------------------------------------------------
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") {
+		return false
+	}
+	header, delim := splitRow(lines[i]), splitRow(lines[i+1])
+	if len(header) != len(delim) {
+		return false
+	}
+	for _, d := range delim {
+		if !delimRe.MatchString(strings.TrimSpace(d)) {
+			return false
+		}
+	}
+	return true
+}
+
+func renderTable(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	aligns := make([]string, len(header))
+	for j, d := range splitRow(lines[i+1]) {
+		d = strings.TrimSpace(d)
+		switch {
+		case strings.HasPrefix(d, ":") && strings.HasSuffix(d, ":"):
+			aligns[j] = "center"
+		case strings.HasSuffix(d, ":"):
+			aligns[j] = "right"
+		case strings.HasPrefix(d, ":"):
+			aligns[j] = "left"
+		}
+	}
+
+	writeRow := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for j := range header {
+			b.WriteString("<" + tag)
+			if aligns[j] != "" {
+				b.WriteString(` align="` + aligns[j] + `"`)
+			}
+			b.WriteString(">")
+			if j < len(cells) {
+				renderInline(b, strings.TrimSpace(cells[j]), 0)
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<table>\n<thead>\n")
+	writeRow(header, "th")
+	b.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" || startsBlock(lines[i]) {
+			break
+		}
+		writeRow(splitRow(lines[i]), "td")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// splitRow splits a table row on "|" (except "\|"), ignoring the
+// optional pipes at both ends.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	start := 0
+	for j := 0; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+		case '|':
+			cells = append(cells, line[start:j])
+			start = j + 1
+		}
+	}
+	return append(cells, line[start:])
+}
+
+func leadingSpaces(s string) int {
+	n := 0
+	for n < len(s) && s[n] == ' ' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,246 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Sanitize keeps only allowlisted tags and attributes of an HTML
+// fragment. Other tags are dropped (their text is kept, except for
+// elements like <script> whose content is dropped too), text is
+// re-escaped, URLs must be http(s), mailto or relative, and unclosed
+// tags are closed at the end.
+func Sanitize(s string) string {
+	var (
+		b    strings.Builder
+		open []string
+	)
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			writeText(&b, s)
+			break
+		}
+		writeText(&b, s[:lt])
+		s = s[lt:]
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s[4:], "-->")
+			if end < 0 {
+				break
+			}
+			s = s[4+end+3:]
+			continue
+		}
+
+		t, rest, ok := parseTag(s)
+		if !ok {
+			b.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = rest
+
+		switch {
+		case dropContent[t.name]:
+			if !t.closing {
+				s = skipElement(s, t.name)
+			}
+
+		case !allowedTags[t.name]:
+			// dropped, its content stays
+
+		case t.closing:
+			// Close everything opened since the matching tag
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == t.name {
+					for len(open) > k {
+						b.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+
+		default:
+			b.WriteString("<" + t.name)
+			for _, a := range t.attrs {
+				if check := allowedAttrs[t.name][a.name]; check != nil && check(a.value) {
+					b.WriteString(" " + a.name + `="` + html.EscapeString(a.value) + `"`)
+				}
+			}
+			if t.name == "a" {
+				b.WriteString(` rel="nofollow ugc noopener"`)
+			}
+			b.WriteString(">")
+			if !voidTags[t.name] {
+				open = append(open, t.name)
+			}
+		}
+	}
+
+	for k := len(open) - 1; k >= 0; k-- {
+		b.WriteString("</" + open[k] + ">")
+	}
+	return b.String()
+}
+
+var allowedTags = map[string]bool{
+	"p": true, "br": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true,
+	"pre": true, "code": true, "em": true, "strong": true, "del": true,
+	"a": true, "img": true,
+	"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// dropContent lists elements removed together with their content.
+var dropContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "textarea": true, "title": true, "svg": true, "math": true,
+}
+
+var (
+	languageRe = regexp.MustCompile(`^language-[A-Za-z0-9_+#.-]{1,32}$`)
+	numberRe   = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+var allowedAttrs = map[string]map[string]func(string) bool{
+	"a":    {"href": safeURL, "title": anyValue},
+	"img":  {"src": safeURL, "alt": anyValue, "title": anyValue},
+	"code": {"class": languageRe.MatchString},
+	"ol":   {"start": numberRe.MatchString},
+	"th":   {"align": isAlign},
+	"td":   {"align": isAlign},
+}
+
+func anyValue(string) bool { return true }
+
+func isAlign(v string) bool {
+	return v == "left" || v == "center" || v == "right"
+}
+
+// safeURL accepts http(s) and mailto URLs, and relative ones.
+func safeURL(u string) bool {
+	u = strings.TrimSpace(u)
+	if u == "" {
+		return false
+	}
+	for _, c := range u {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return true // no scheme
+	}
+	switch strings.ToLower(u[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// writeText escapes text, normalising any entities it already has.
+func writeText(b *strings.Builder, s string) {
+	b.WriteString(html.EscapeString(html.UnescapeString(s)))
+}
+
+type attr struct {
+	name, value string
+}
+
+type tag struct {
+	name    string
+	closing bool
+	attrs   []attr
+}
+
+// parseTag reads the tag at the start of s ("<name ...>" or "</name>").
+// ok is false if s does not start with a complete tag.
+func parseTag(s string) (t tag, rest string, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isAlnum(s[i]) && s[i] < 0x80) {
+		i++
+	}
+	if i == start || !isLetter(s[start]) {
+		return tag{}, s, false
+	}
+	t.name = strings.ToLower(s[start:i])
+
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '>':
+			return t, s[i+1:], true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '/':
+			i++
+		default:
+			// Attribute name
+			start := i
+			for i < len(s) && strings.IndexByte(" \t\n\r\f/>=", s[i]) < 0 {
+				i++
+			}
+			a := attr{name: strings.ToLower(s[start:i])}
+			for i < len(s) && strings.IndexByte(" \t\n\r\f", s[i]) >= 0 {
+				i++
+			}
+			if i < len(s) && s[i] == '=' {
+				i++
+				for i < len(s) && strings.IndexByte(" \t\n\r\f", s[i]) >= 0 {
+					i++
+				}
+				if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+					end := strings.IndexByte(s[i+1:], s[i])
+					if end < 0 {
+						return tag{}, s, false
+					}
+					a.value = s[i+1 : i+1+end]
+					i += end + 2
+				} else {
+					start := i
+					for i < len(s) && strings.IndexByte(" \t\n\r\f>", s[i]) < 0 {
+						i++
+					}
+					a.value = s[start:i]
+				}
+				a.value = html.UnescapeString(a.value)
+			}
+			t.attrs = append(t.attrs, a)
+		}
+	}
+	return tag{}, s, false
+}
+
+// skipElement returns what follows the closing tag of name, or "" if
+// it is never closed.
+func skipElement(s, name string) string {
+	closing := "</" + name
+	end := -1
+	for i := 0; i+len(closing) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(closing)], closing) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return ""
+	}
+	s = s[end:]
+	if gt := strings.IndexByte(s, '>'); gt >= 0 {
+		return s[gt+1:]
+	}
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -1,16 +1,29 @@
 package models
 
+import "html/template"
+
 // Post represents a forum post displayed across pages.
 type Post struct {
-	ID         int
-	UserID     int
-	Username   string
-	Title      string
-	Content    string
-	CreatedAt  string
-	Likes      int
-	Dislikes   int
-	Categories []Category
+	ID          int
+	UserID      int
+	Username    string
+	Title       string
+	Content     string        // Markdown source
+	ContentHTML template.HTML // rendered and sanitised
+	CreatedAt   string
+	EditedAt    string // "" if never edited
+	Deleted     bool   // tombstone: title and content are not shown
+	Likes       int
+	Dislikes    int
+	Categories  []Category
+	Images      []PostImage
+}
+
+// PostImage is a picture attached to a post.
+type PostImage struct {
+	URL           string
+	ThumbnailURL  string // URL itself if the image has no thumbnail
+	Width, Height int
 }
 
 // Comment represents a single comment under a post.
@@ -0,0 +1,122 @@
+package oauth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"forum/config"
+)
+
+var (
+	providers     []*Provider
+	providersOnce sync.Once
+)
+
+// Providers returns every provider that has a client ID configured,
+// in a stable order (GitHub, Google, generic OIDC).
+func Providers() []*Provider {
+	providersOnce.Do(func() {
+		providers = loadProviders()
+	})
+	return providers
+}
+
+// Get returns the configured provider with the given name, or nil.
+func Get(name string) *Provider {
+	for _, p := range Providers() {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// SetProviders replaces the configured providers.
+func SetProviders(ps []*Provider) {
+	providersOnce.Do(func() {})
+	providers = ps
+}
+
+// loadProviders reads FORUM_OAUTH_<NAME>_* settings. Every endpoint
+// can be overridden; the defaults are the providers' public endpoints.
+func loadProviders() []*Provider {
+	redirect := config.BaseURL() + "/oauth/callback"
+	var out []*Provider
+
+	if id := config.String("FORUM_OAUTH_GITHUB_CLIENT_ID", ""); id != "" {
+		out = append(out, &Provider{
+			Name:         "github",
+			DisplayName:  "GitHub",
+			Kind:         KindGitHub,
+			ClientID:     id,
+			ClientSecret: config.String("FORUM_OAUTH_GITHUB_CLIENT_SECRET", ""),
+			AuthURL:      config.String("FORUM_OAUTH_GITHUB_AUTH_URL", "https://github.com/login/oauth/authorize"),
+			TokenURL:     config.String("FORUM_OAUTH_GITHUB_TOKEN_URL", "https://github.com/login/oauth/access_token"),
+			UserInfoURL:  config.String("FORUM_OAUTH_GITHUB_USERINFO_URL", "https://api.github.com/user"),
+			EmailsURL:    config.String("FORUM_OAUTH_GITHUB_EMAILS_URL", "https://api.github.com/user/emails"),
+			Scopes:       []string{"read:user", "user:email"},
+			RedirectURL:  redirect,
+		})
+	}
+
+	if id := config.String("FORUM_OAUTH_GOOGLE_CLIENT_ID", ""); id != "" {
+		out = append(out, &Provider{
+			Name:         "google",
+			DisplayName:  "Google",
+			Kind:         KindOIDC,
+			ClientID:     id,
+			ClientSecret: config.String("FORUM_OAUTH_GOOGLE_CLIENT_SECRET", ""),
+			AuthURL:      config.String("FORUM_OAUTH_GOOGLE_AUTH_URL", "https://accounts.google.com/o/oauth2/v2/auth"),
+			TokenURL:     config.String("FORUM_OAUTH_GOOGLE_TOKEN_URL", "https://oauth2.googleapis.com/token"),
+			UserInfoURL:  config.String("FORUM_OAUTH_GOOGLE_USERINFO_URL", "https://openidconnect.googleapis.com/v1/userinfo"),
+			Scopes:       []string{"openid", "email", "profile"},
+			RedirectURL:  redirect,
+			// Google's ID tokens use either form
+			Issuers: issuers("FORUM_OAUTH_GOOGLE_ISSUER", "https://accounts.google.com", "accounts.google.com"),
+		})
+	}
+
+	if id := config.String("FORUM_OAUTH_OIDC_CLIENT_ID", ""); id != "" {
+		p := &Provider{
+			Name:         "oidc",
+			DisplayName:  config.String("FORUM_OAUTH_OIDC_NAME", "Single Sign-On"),
+			Kind:         KindOIDC,
+			ClientID:     id,
+			ClientSecret: config.String("FORUM_OAUTH_OIDC_CLIENT_SECRET", ""),
+			AuthURL:      config.String("FORUM_OAUTH_OIDC_AUTH_URL", ""),
+			TokenURL:     config.String("FORUM_OAUTH_OIDC_TOKEN_URL", ""),
+			UserInfoURL:  config.String("FORUM_OAUTH_OIDC_USERINFO_URL", ""),
+			Scopes:       []string{"openid", "email", "profile"},
+			RedirectURL:  redirect,
+			Issuers:      issuers("FORUM_OAUTH_OIDC_ISSUER"),
+		}
+
+		// Endpoints not set explicitly come from the issuer's discovery document
+		if issuer := config.String("FORUM_OAUTH_OIDC_ISSUER", ""); issuer != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := p.Discover(ctx, issuer); err != nil {
+				log.Println("OIDC discovery failed:", err)
+			}
+			cancel()
+		}
+
+		if p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" || len(p.Issuers) == 0 {
+			log.Println("OIDC provider is missing endpoints or its issuer — disabled")
+		} else {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// issuers returns the setting key as the only accepted issuer when it is
+// set, otherwise the defaults.
+func issuers(key string, defaults ...string) []string {
+	if iss := config.String(key, ""); iss != "" {
+		return []string{iss}
+	}
+	return defaults
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/oauth"
+)
+
+const stubClientID = "forum-client"
+
+// stubProvider is a stand-in OpenID Connect provider. /authorize hands
+// out a code at once, /token checks the PKCE verifier against the
+// challenge the code was issued for, and /userinfo reports the account.
+type stubProvider struct {
+	*httptest.Server
+
+	Subject       string
+	Email         string
+	EmailVerified bool
+
+	// Claims may change the ID token before it is issued; OmitIDToken
+	// leaves it out of the token response.
+	Claims      func(claims map[string]any)
+	OmitIDToken bool
+
+	mu         sync.Mutex
+	grants     map[string]url.Values // code → the /authorize query it answered
+	tokenCalls int
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+	sp := &stubProvider{grants: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", sp.authorize)
+	mux.HandleFunc("/token", sp.token)
+	mux.HandleFunc("/userinfo", sp.userinfo)
+	sp.Server = httptest.NewServer(mux)
+	t.Cleanup(sp.Close)
+	return sp
+}
+
+func (sp *stubProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code, _ := oauth.RandomString()
+	sp.mu.Lock()
+	sp.grants[code] = q
+	sp.mu.Unlock()
+
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (sp *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	sp.mu.Lock()
+	sp.tokenCalls++
+	grant, ok := sp.grants[r.FormValue("code")]
+	delete(sp.grants, r.FormValue("code"))
+	sp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || grant.Get("client_id") != r.FormValue("client_id") ||
+		grant.Get("redirect_uri") != r.FormValue("redirect_uri") ||
+		grant.Get("code_challenge_method") != "S256" ||
+		grant.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	resp := map[string]string{"access_token": "access-" + sp.Subject, "token_type": "Bearer"}
+	if !sp.OmitIDToken {
+		claims := map[string]any{
+			"iss":   sp.URL,
+			"sub":   sp.Subject,
+			"aud":   stubClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": grant.Get("nonce"),
+		}
+		if sp.Claims != nil {
+			sp.Claims(claims)
+		}
+		resp["id_token"] = idToken(claims)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (sp *stubProvider) userinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer access-"+sp.Subject {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"sub":            sp.Subject,
+		"email":          sp.Email,
+		"email_verified": sp.EmailVerified,
+	})
+}
+
+// TokenCalls returns how many times /token was called.
+func (sp *stubProvider) TokenCalls() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.tokenCalls
+}
+
+// idToken encodes claims as an unsigned JWT; the forum takes ID tokens
+// from the token endpoint and doesn't check their signature.
+func idToken(claims map[string]any) string {
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte("signature"))
+}
+
+// newOAuthServer serves the OAuth handlers with sp as the only provider,
+// named "stub", until the test ends.
+func newOAuthServer(t *testing.T, sp *stubProvider) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/start", auth.OAuthStartHandler)
+	mux.HandleFunc("/oauth/callback", auth.OAuthCallbackHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	oauth.SetProviders([]*oauth.Provider{{
+		Name:         "stub",
+		DisplayName:  "Stub",
+		Kind:         oauth.KindOIDC,
+		ClientID:     stubClientID,
+		ClientSecret: "stub-secret",
+		AuthURL:      sp.URL + "/authorize",
+		TokenURL:     sp.URL + "/token",
+		UserInfoURL:  sp.URL + "/userinfo",
+		Scopes:       []string{"openid", "email"},
+		RedirectURL:  srv.URL + "/oauth/callback",
+		Issuers:      []string{sp.URL},
+		HTTPClient:   sp.Client(),
+	}})
+	t.Cleanup(func() { oauth.SetProviders(nil) })
+	return srv
+}
+
+// get fetches url with client and returns the response, body closed.
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// startOAuth follows /oauth/start to the provider and returns the
+// callback URL the provider sends the browser back to.
+func startOAuth(t *testing.T, srv *httptest.Server, client *http.Client) *url.URL {
+	t.Helper()
+	resp := get(t, client, srv.URL+"/oauth/start?provider=stub")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("/oauth/start: status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	resp = get(t, client, resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("/authorize: status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+// oauthLogin runs the whole flow and returns the callback's status.
+func oauthLogin(t *testing.T, srv *httptest.Server, client *http.Client) int {
+	t.Helper()
+	return get(t, client, startOAuth(t, srv, client).String()).StatusCode
+}
+
+func identityOwner(t *testing.T, subject string) int {
+	t.Helper()
+	var userID int
+	err := database.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = 'stub' AND subject = ?", subject,
+	).Scan(&userID)
+	if err != nil {
+		return 0
+	}
+	return userID
+}
+
+func TestOAuthLoginCreatesAccount(t *testing.T) {
+	sp := newStubProvider(t)
+	sp.Subject, sp.Email, sp.EmailVerified = "stub-frank", "frank@example.com", true
+	srv := newOAuthServer(t, sp)
+
+	client := newClient(t)
+	if code := oauthLogin(t, srv, client); code != http.StatusSeeOther {
+		t.Fatalf("callback: status %d, want %d", code, http.StatusSeeOther)
+	}
+	userID := sessionUser(t, client, srv)
+	if userID == 0 {
+		t.Fatal("login created no session")
+	}
+	if owner := identityOwner(t, sp.Subject); owner != userID {
+		t.Errorf("identity belongs to user %d, want %d", owner, userID)
+	}
+
+	var (
+		email, password string
+		verified        bool
+	)
+	database.DB.QueryRow(
+		"SELECT email, password, email_verified FROM users WHERE id = ?", userID,
+	).Scan(&email, &password, &verified)
+	if email != sp.Email || password != "" || !verified {
+		t.Errorf("new account = %q password %q verified %v", email, password, verified)
+	}
+
+	// The next login finds the same account
+	again := newClient(t)
+	oauthLogin(t, srv, again)
+	if got := sessionUser(t, again, srv); got != userID {
+		t.Errorf("second login as user %d, want %d", got, userID)
+	}
+}
+
+func TestOAuthCallbackChecksState(t *testing.T) {
+	sp := newStubProvider(t)
+	sp.Subject, sp.Email, sp.EmailVerified = "stub-grace", "grace@example.com", true
+	srv := newOAuthServer(t, sp)
+
+	tests := []struct {
+		name     string
+		callback func(client *http.Client, u *url.URL) *http.Client
+	}{
+		{
+			name: "wrong state",
+			callback: func(client *http.Client, u *url.URL) *http.Client {
+				q := u.Query()
+				q.Set("state", "forged")
+				u.RawQuery = q.Encode()
+				return client
+			},
+		},
+		{
+			name: "no state",
+			callback: func(client *http.Client, u *url.URL) *http.Client {
+				q := u.Query()
+				q.Del("state")
+				u.RawQuery = q.Encode()
+				return client
+			},
+		},
+		{
+			// The callback opened in a browser that never started the flow
+			name: "another browser",
+			callback: func(*http.Client, *url.URL) *http.Client {
+				return newClient(t)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient(t)
+			u := startOAuth(t, srv, client)
+			client = tt.callback(client, u)
+
+			calls := sp.TokenCalls()
+			if code := get(t, client, u.String()).StatusCode; code != http.StatusOK {
+				t.Errorf("callback: status %d, want the login form (%d)", code, http.StatusOK)
+			}
+			if sp.TokenCalls() != calls {
+				t.Error("the code was exchanged without a matching state")
+			}
+			if got := sessionUser(t, client, srv); got != 0 {
+				t.Errorf("refused login created a session for user %d", got)
+			}
+		})
+	}
+	if owner := identityOwner(t, sp.Subject); owner != 0 {
+		t.Errorf("refused logins created an account (%d)", owner)
+	}
+}
+
+func TestOAuthCallbackChecksVerifier(t *testing.T) {
+	sp := newStubProvider(t)
+	sp.Subject, sp.Email, sp.EmailVerified = "stub-heidi", "heidi@example.com", true
+	srv := newOAuthServer(t, sp)
+
+	// A code issued to the victim's flow, replayed inside the attacker's:
+	// the state matches the attacker's cookie but the verifier doesn't
+	// match the challenge the code was issued for.
+	victim := startOAuth(t, srv, newClient(t))
+	attacker := newClient(t)
+	u := startOAuth(t, srv, attacker)
+	q := u.Query()
+	q.Set("code", victim.Query().Get("code"))
+	u.RawQuery = q.Encode()
+
+	if code := get(t, attacker, u.String()).StatusCode; code != http.StatusOK {
+		t.Errorf("callback: status %d, want the login form (%d)", code, http.StatusOK)
+	}
+	if sp.TokenCalls() != 1 {
+		t.Errorf("token endpoint called %d times, want 1", sp.TokenCalls())
+	}
+	if got := sessionUser(t, attacker, srv); got != 0 {
+		t.Errorf("code from another flow logged in as user %d", got)
+	}
+}
+
+func TestOAuthIDTokenRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(claims map[string]any)
+		omit   bool
+	}{
+		{name: "wrong nonce", claims: func(c map[string]any) { c["nonce"] = "replayed" }},
+		{name: "no nonce", claims: func(c map[string]any) { delete(c, "nonce") }},
+		{name: "wrong issuer", claims: func(c map[string]any) { c["iss"] = "https://evil.example" }},
+		{name: "wrong audience", claims: func(c map[string]any) { c["aud"] = "another-client" }},
+		{
+			name: "shared audience, other party",
+			claims: func(c map[string]any) {
+				c["aud"], c["azp"] = []string{"another-client", stubClientID}, "another-client"
+			},
+		},
+		{name: "expired", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "other subject", claims: func(c map[string]any) { c["sub"] = "stub-someone-else" }},
+		{name: "missing", omit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := newStubProvider(t)
+			sp.Subject, sp.Email, sp.EmailVerified = "stub-ivan", "ivan@example.com", true
+			sp.Claims, sp.OmitIDToken = tt.claims, tt.omit
+			srv := newOAuthServer(t, sp)
+
+			client := newClient(t)
+			if code := oauthLogin(t, srv, client); code != http.StatusOK {
+				t.Errorf("callback: status %d, want the login form (%d)", code, http.StatusOK)
+			}
+			if got := sessionUser(t, client, srv); got != 0 {
+				t.Errorf("refused login created a session for user %d", got)
+			}
+			if owner := identityOwner(t, sp.Subject); owner != 0 {
+				t.Errorf("refused login created an account (%d)", owner)
+			}
+		})
+	}
+
+	// Audiences may be a list when this forum is named in it and is the
+	// authorized party.
+	sp := newStubProvider(t)
+	sp.Subject, sp.Email, sp.EmailVerified = "stub-judy", "judy@example.com", true
+	sp.Claims = func(c map[string]any) { c["aud"], c["azp"] = []string{stubClientID, "another-client"}, stubClientID }
+	srv := newOAuthServer(t, sp)
+	client := newClient(t)
+	oauthLogin(t, srv, client)
+	if sessionUser(t, client, srv) == 0 {
+		t.Error("audience list naming this forum as azp was refused")
+	}
+}
+
+func TestOAuthLinksExistingAccount(t *testing.T) {
+	tests := []struct {
+		name             string
+		localVerified    bool
+		providerVerified bool
+		linked           bool
+	}{
+		{"both verified", true, true, true},
+		{"provider email unverified", true, false, false},
+		{"local email unverified", false, true, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := "linked" + string(rune('a'+i)) + "@example.com"
+			localID := newUser(t, email, "linked"+string(rune('a'+i)), "Zebra-Orbit-991", tt.localVerified)
+
+			sp := newStubProvider(t)
+			sp.Subject, sp.Email, sp.EmailVerified = "stub-"+email, email, tt.providerVerified
+			srv := newOAuthServer(t, sp)
+
+			client := newClient(t)
+			code := oauthLogin(t, srv, client)
+			got, owner := sessionUser(t, client, srv), identityOwner(t, sp.Subject)
+			if tt.linked {
+				if code != http.StatusSeeOther || got != localID || owner != localID {
+					t.Errorf("status %d, session user %d, identity owner %d; want %d, %d, %d",
+						code, got, owner, http.StatusSeeOther, localID, localID)
+				}
+				return
+			}
+			if code != http.StatusOK || got != 0 || owner != 0 {
+				t.Errorf("status %d, session user %d, identity owner %d; want the login form and no link",
+					code, got, owner)
+			}
+			var accounts int
+			database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&accounts)
+			if accounts != 1 {
+				t.Errorf("%d accounts for %s, want 1", accounts, email)
+			}
+		})
+	}
+}
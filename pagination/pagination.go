@@ -0,0 +1,196 @@
+// Package pagination implements keyset (cursor) pagination for listings
+// sorted by some key, newest or highest first, with the row id breaking
+// ties.
+//
+// A page is asked for with ?after=CURSOR (the rows following it) or
+// ?before=CURSOR (the rows preceding it). Cursors are opaque strings
+// naming the sort key and id of a row, so they stay valid while rows are
+// added or removed, and a page costs the same however deep it is.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"forum/config"
+)
+
+// ErrInvalidCursor is returned for cursors that were not made by Cursor.String.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// MaxPageSize bounds FORUM_PAGE_SIZE.
+const MaxPageSize = 100
+
+// PageSize is how many rows a page holds (FORUM_PAGE_SIZE, default 20).
+func PageSize() int {
+	n := config.Int("FORUM_PAGE_SIZE", 20)
+	if n < 1 {
+		return 1
+	}
+	if n > MaxPageSize {
+		return MaxPageSize
+	}
+	return n
+}
+
+// Cursor is the position of one row: its sort key and id.
+type Cursor struct {
+	Key any `json:"k"`
+	ID  int `json:"id"`
+}
+
+// NewCursor returns the cursor of a row, from the key and id scanned
+// from the database.
+func NewCursor(key any, id int) Cursor {
+	switch v := key.(type) {
+	case []byte:
+		key = string(v)
+	case time.Time:
+		// DATETIME columns come back parsed; compare them as stored
+		key = v.UTC().Format("2006-01-02 15:04:05")
+	}
+	return Cursor{Key: key, ID: id}
+}
+
+// String encodes the cursor for a URL or an API response.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor made by String.
+func ParseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	switch c.Key.(type) {
+	case string, float64:
+	default:
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Request is the page a listing was asked for.
+type Request struct {
+	After  *Cursor // rows after this one...
+	Before *Cursor // ...or before this one (first page if neither)
+	Limit  int
+
+	url *url.URL
+}
+
+// FromRequest reads the "after" or "before" parameter of r.
+func FromRequest(r *http.Request) (Request, error) {
+	req := Request{Limit: PageSize(), url: r.URL}
+	q := r.URL.Query()
+
+	for _, p := range []struct {
+		name string
+		dst  **Cursor
+	}{{"after", &req.After}, {"before", &req.Before}} {
+		if s := q.Get(p.name); s != "" {
+			c, err := ParseCursor(s)
+			if err != nil {
+				return Request{}, err
+			}
+			*p.dst = &c
+		}
+	}
+	if req.After != nil && req.Before != nil {
+		return Request{}, ErrInvalidCursor
+	}
+	return req, nil
+}
+
+// Keyset returns the SQL that selects the requested page of a listing
+// ordered by keyExpr and then idExpr, both descending: a condition to
+// AND into the WHERE clause ("1" if none), its arguments, and the ORDER
+// BY and LIMIT clauses. One row more than the page is fetched so Finish
+// can tell whether another page follows.
+func (req Request) Keyset(keyExpr, idExpr string) (cond string, args []any, orderLimit string) {
+	limit := " LIMIT " + strconv.Itoa(req.Limit+1)
+	switch {
+	case req.After != nil:
+		return "(" + keyExpr + ", " + idExpr + ") < (?, ?)", []any{req.After.Key, req.After.ID},
+			"ORDER BY " + keyExpr + " DESC, " + idExpr + " DESC" + limit
+	case req.Before != nil:
+		// Walk backwards from the cursor; Finish puts the rows back in order
+		return "(" + keyExpr + ", " + idExpr + ") > (?, ?)", []any{req.Before.Key, req.Before.ID},
+			"ORDER BY " + keyExpr + " ASC, " + idExpr + " ASC" + limit
+	}
+	return "1", nil, "ORDER BY " + keyExpr + " DESC, " + idExpr + " DESC" + limit
+}
+
+// Page is the navigation around one page of rows.
+type Page struct {
+	Next, Prev       string // cursors, "" on the last / first page
+	NextURL, PrevURL string // the same page's URL with the cursor swapped in
+}
+
+// Finish trims the rows fetched with Keyset to the page, in display
+// order, and works out the links to the neighbouring pages. cursor
+// returns the position of a row.
+func Finish[T any](req Request, rows []T, cursor func(T) Cursor) ([]T, Page) {
+	more := len(rows) > req.Limit
+	if more {
+		rows = rows[:req.Limit]
+	}
+	if req.Before != nil {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	var page Page
+	if len(rows) > 0 {
+		first, last := cursor(rows[0]), cursor(rows[len(rows)-1])
+		// Going forwards there is always something behind the cursor,
+		// and going backwards something ahead of it
+		if more || req.Before != nil {
+			page.Next = last.String()
+		}
+		if (more && req.Before != nil) || req.After != nil {
+			page.Prev = first.String()
+		}
+	}
+
+	if req.url != nil {
+		if page.Next != "" {
+			page.NextURL = link(req.url, "after", page.Next)
+		}
+		if page.Prev != "" {
+			page.PrevURL = link(req.url, "before", page.Prev)
+		}
+		if len(rows) == 0 && (req.After != nil || req.Before != nil) {
+			// Past either end (the rows were removed): back to the start
+			page.PrevURL = link(req.url, "", "")
+		}
+	}
+	return rows, page
+}
+
+// link returns u with its cursor replaced (or removed if param is ""),
+// keeping other parameters (category, sort order...).
+func link(u *url.URL, param, cursor string) string {
+	q := u.Query()
+	q.Del("after")
+	q.Del("before")
+	if param != "" {
+		q.Set(param, cursor)
+	}
+	if len(q) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + q.Encode()
+}
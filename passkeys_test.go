@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/webauthn"
+	"forum/webauthn/webauthntest"
+)
+
+// The relying party the handlers derive from the default FORUM_BASE_URL.
+const (
+	passkeyRPID   = "localhost"
+	passkeyOrigin = "http://localhost:8080"
+)
+
+func newPasskeyServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webauthn/register/begin", auth.PasskeyRegisterBeginHandler)
+	mux.HandleFunc("/webauthn/register/finish", auth.PasskeyRegisterFinishHandler)
+	mux.HandleFunc("/webauthn/login/begin", auth.PasskeyLoginBeginHandler)
+	mux.HandleFunc("/webauthn/login/finish", auth.PasskeyLoginFinishHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postJSON sends body as webauthn.js would and decodes the JSON answer.
+func postJSON(t *testing.T, client *http.Client, url string, body, out interface{}) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("POST %s: decoding answer: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type ceremonyOptions struct {
+	Challenge string `json:"challenge"`
+	RPID      string `json:"rpId"`
+	RP        struct {
+		ID string `json:"id"`
+	} `json:"rp"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+type ceremonyResult struct {
+	Redirect string `json:"redirect"`
+	Error    string `json:"error"`
+}
+
+// registerPasskey runs both registration steps for a logged-in client.
+func registerPasskey(t *testing.T, srv *httptest.Server, client *http.Client, userID int) *webauthntest.Authenticator {
+	t.Helper()
+	var opts ceremonyOptions
+	if code := postJSON(t, client, srv.URL+"/webauthn/register/begin", nil, &opts); code != http.StatusOK {
+		t.Fatalf("register/begin: status %d", code)
+	}
+	if opts.RP.ID != passkeyRPID {
+		t.Errorf("rp.id = %q, want %q", opts.RP.ID, passkeyRPID)
+	}
+	if want := webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(userID))); opts.User.ID != want {
+		t.Errorf("user.id = %q, want %q", opts.User.ID, want)
+	}
+
+	a, err := webauthntest.New(passkeyRPID, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData, attestation := a.Create(opts.Challenge)
+
+	var res ceremonyResult
+	code := postJSON(t, client, srv.URL+"/webauthn/register/finish", map[string]string{
+		"name":              "Test key",
+		"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+		"attestationObject": webauthn.Encoding.EncodeToString(attestation),
+	}, &res)
+	if code != http.StatusOK {
+		t.Fatalf("register/finish: status %d (%s)", code, res.Error)
+	}
+	return a
+}
+
+// passkeyLogin runs both login steps and returns the finish status. sign
+// may change the response before it is sent.
+func passkeyLogin(t *testing.T, srv *httptest.Server, client *http.Client, a *webauthntest.Authenticator,
+	userID int, sign func(body map[string]string)) int {
+	t.Helper()
+	var opts ceremonyOptions
+	if code := postJSON(t, client, srv.URL+"/webauthn/login/begin", nil, &opts); code != http.StatusOK {
+		t.Fatalf("login/begin: status %d", code)
+	}
+	if opts.RPID != passkeyRPID {
+		t.Errorf("rpId = %q, want %q", opts.RPID, passkeyRPID)
+	}
+
+	clientData, authData, sig, err := a.Get(opts.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]string{
+		"id":                webauthn.Encoding.EncodeToString(a.CredentialID),
+		"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+		"authenticatorData": webauthn.Encoding.EncodeToString(authData),
+		"signature":         webauthn.Encoding.EncodeToString(sig),
+		"userHandle":        webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(userID))),
+	}
+	if sign != nil {
+		sign(body)
+	}
+	return postJSON(t, client, srv.URL+"/webauthn/login/finish", body, nil)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	resetThrottle(t)
+	srv := newPasskeyServer(t)
+	userID := newUser(t, "passkey@example.com", "passkey", "Zebra-Orbit-991", true)
+
+	owner := newClient(t)
+	logIn(t, owner, srv, userID)
+	a := registerPasskey(t, srv, owner, userID)
+
+	var stored int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = ? AND credential_id = ?",
+		userID, webauthn.Encoding.EncodeToString(a.CredentialID),
+	).Scan(&stored)
+	if err != nil || stored != 1 {
+		t.Fatalf("stored credentials = %d (%v), want 1", stored, err)
+	}
+
+	// A new browser logs in with the passkey alone
+	browser := newClient(t)
+	if code := passkeyLogin(t, srv, browser, a, userID, nil); code != http.StatusOK {
+		t.Fatalf("login/finish: status %d", code)
+	}
+	if got := sessionUser(t, browser, srv); got != userID {
+		t.Errorf("logged in as user %d, want %d", got, userID)
+	}
+
+	var signCount uint32
+	database.DB.QueryRow(
+		"SELECT sign_count FROM webauthn_credentials WHERE user_id = ?", userID,
+	).Scan(&signCount)
+	if signCount != a.SignCount {
+		t.Errorf("stored sign count = %d, want %d", signCount, a.SignCount)
+	}
+}
+
+func TestPasskeyRegistrationNeedsLogin(t *testing.T) {
+	srv := newPasskeyServer(t)
+	if code := postJSON(t, newClient(t), srv.URL+"/webauthn/register/begin", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("register/begin without a session: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestPasskeyRegistrationChallengeIsSingleUse(t *testing.T) {
+	srv := newPasskeyServer(t)
+	userID := newUser(t, "passkey-replay@example.com", "passkeyreplay", "Zebra-Orbit-991", true)
+	client := newClient(t)
+	logIn(t, client, srv, userID)
+
+	var opts ceremonyOptions
+	postJSON(t, client, srv.URL+"/webauthn/register/begin", nil, &opts)
+	a, err := webauthntest.New(passkeyRPID, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData, attestation := a.Create(opts.Challenge)
+	body := map[string]string{
+		"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+		"attestationObject": webauthn.Encoding.EncodeToString(attestation),
+	}
+
+	if code := postJSON(t, client, srv.URL+"/webauthn/register/finish", body, nil); code != http.StatusOK {
+		t.Fatalf("first register/finish: status %d", code)
+	}
+	if code := postJSON(t, client, srv.URL+"/webauthn/register/finish", body, nil); code != http.StatusBadRequest {
+		t.Errorf("replayed register/finish: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestPasskeyLoginRejected(t *testing.T) {
+	srv := newPasskeyServer(t)
+	userID := newUser(t, "passkey-reject@example.com", "passkeyreject", "Zebra-Orbit-991", true)
+	otherID := newUser(t, "passkey-other@example.com", "passkeyother", "Zebra-Orbit-991", true)
+	owner := newClient(t)
+	logIn(t, owner, srv, userID)
+	a := registerPasskey(t, srv, owner, userID)
+
+	tests := []struct {
+		name   string
+		before func(a *webauthntest.Authenticator)
+		sign   func(body map[string]string)
+		want   int
+	}{
+		{
+			name:   "wrong origin",
+			before: func(a *webauthntest.Authenticator) { a.Origin = "http://evil.example" },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong relying party",
+			before: func(a *webauthntest.Authenticator) { a.RPID = "evil.example" },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "bad signature",
+			sign: func(body map[string]string) {
+				sig, _ := webauthn.Encoding.DecodeString(body["signature"])
+				sig[len(sig)-1] ^= 0x01
+				body["signature"] = webauthn.Encoding.EncodeToString(sig)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:   "counter went backwards",
+			before: func(a *webauthntest.Authenticator) { a.SignCount = 0 },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "another user's handle",
+			sign: func(body map[string]string) {
+				body["userHandle"] = webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(otherID)))
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown credential",
+			sign: func(body map[string]string) {
+				body["id"] = webauthn.Encoding.EncodeToString([]byte("no such credential"))
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetThrottle(t)
+			// One good login first, so the stored counter is above zero
+			if code := passkeyLogin(t, srv, newClient(t), a, userID, nil); code != http.StatusOK {
+				t.Fatalf("good login: status %d", code)
+			}
+
+			bad := *a
+			if tt.before != nil {
+				tt.before(&bad)
+			}
+			browser := newClient(t)
+			if code := passkeyLogin(t, srv, browser, &bad, userID, tt.sign); code != tt.want {
+				t.Errorf("login/finish: status %d, want %d", code, tt.want)
+			}
+			if got := sessionUser(t, browser, srv); got != 0 {
+				t.Errorf("refused login created a session for user %d", got)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginNeedsOwnChallenge(t *testing.T) {
+	resetThrottle(t)
+	srv := newPasskeyServer(t)
+	userID := newUser(t, "passkey-steal@example.com", "passkeysteal", "Zebra-Orbit-991", true)
+	owner := newClient(t)
+	logIn(t, owner, srv, userID)
+	a := registerPasskey(t, srv, owner, userID)
+
+	// A challenge issued to one browser, answered from another
+	var opts ceremonyOptions
+	postJSON(t, newClient(t), srv.URL+"/webauthn/login/begin", nil, &opts)
+	clientData, authData, sig, err := a.Get(opts.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := postJSON(t, newClient(t), srv.URL+"/webauthn/login/finish", map[string]string{
+		"id":                webauthn.Encoding.EncodeToString(a.CredentialID),
+		"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+		"authenticatorData": webauthn.Encoding.EncodeToString(authData),
+		"signature":         webauthn.Encoding.EncodeToString(sig),
+	}, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("login/finish without this browser's challenge: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
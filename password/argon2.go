@@ -0,0 +1,93 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes passwords with Argon2id (RFC 9106):
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+func NewArgon2id(time, memory uint32, threads uint8) *Argon2id {
+	return &Argon2id{Time: time, Memory: memory, Threads: threads, SaltLen: 16, KeyLen: 32}
+}
+
+var b64 = base64.RawStdEncoding
+
+func (a *Argon2id) ID() string { return "argon2id" }
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// argonParams is a parsed Argon2id PHC string.
+type argonParams struct {
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2id(encoded string) (*argonParams, error) {
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownFormat
+	}
+
+	p := &argonParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	var err error
+	if p.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	if p.key, err = b64.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	return p, nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (a *Argon2id) Current(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	return p.memory == a.Memory && p.time == a.Time && p.threads == a.Threads &&
+		uint32(len(p.key)) == a.KeyLen && len(p.salt) == a.SaltLen
+}
@@ -0,0 +1,35 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes passwords with bcrypt. Its modular crypt format
+// ($2a$<cost>$<salt+hash>) is already self-describing.
+type Bcrypt struct {
+	Cost int
+}
+
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{Cost: cost}
+}
+
+func (b *Bcrypt) ID() string { return "2a" }
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hashed), err
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == b.Cost
+}
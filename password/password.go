@@ -0,0 +1,90 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"forum/config"
+)
+
+// Hasher produces and checks one kind of self-describing password hash.
+// Hashes use the PHC string format ($id$params$salt$hash), so the
+// algorithm and its cost are read back from the stored value itself.
+type Hasher interface {
+	// ID is the PHC identifier, e.g. "argon2id" or "2a".
+	ID() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Current reports whether encoded was made with this hasher's
+	// present settings (false → rehash on next login).
+	Current(encoded string) bool
+}
+
+var (
+	defaultHasher Hasher
+	defaultOnce   sync.Once
+)
+
+// Default returns the hasher used for new hashes (FORUM_PASSWORD_HASHER).
+func Default() Hasher {
+	defaultOnce.Do(func() {
+		switch config.String("FORUM_PASSWORD_HASHER", "argon2id") {
+		case "bcrypt":
+			defaultHasher = NewBcrypt(config.Int("FORUM_BCRYPT_COST", 10))
+		default:
+			defaultHasher = NewArgon2id(
+				uint32(config.Int("FORUM_ARGON2_TIME", 3)),
+				uint32(config.Int("FORUM_ARGON2_MEMORY_KIB", 64*1024)),
+				uint8(config.Int("FORUM_ARGON2_THREADS", 2)),
+			)
+		}
+	})
+	return defaultHasher
+}
+
+// hasherFor picks the hasher that understands an encoded hash.
+func hasherFor(encoded string) (Hasher, error) {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return nil, ErrUnknownFormat
+	}
+
+	switch parts[1] {
+	case "argon2id":
+		return NewArgon2id(0, 0, 0), nil
+	case "2a", "2b", "2y":
+		return NewBcrypt(0), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+var ErrUnknownFormat = errors.New("password: unknown hash format")
+
+// Hash hashes a password with the default hasher.
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify checks password against a stored hash of any supported kind.
+// needsRehash is true when the hash should be replaced by Hash(password)
+// (older algorithm or weaker settings than the current default).
+func Verify(password, encoded string) (ok, needsRehash bool, err error) {
+	if encoded == "" {
+		// Accounts without a password (e.g. external login only)
+		return false, false, nil
+	}
+
+	h, err := hasherFor(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	ok, err = h.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	d := Default()
+	return true, d.ID() != h.ID() || !d.Current(encoded), nil
+}
@@ -0,0 +1,48 @@
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"forum/config"
+)
+
+// maxLength bounds the work a single login can cause.
+const maxLength = 256
+
+//go:embed common_passwords.txt
+var commonList string
+
+var common = func() map[string]bool {
+	m := map[string]bool{}
+	for _, line := range strings.Split(commonList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			m[strings.ToLower(line)] = true
+		}
+	}
+	return m
+}()
+
+// MinLength is the shortest password accepted (FORUM_PASSWORD_MIN_LENGTH).
+func MinLength() int {
+	return config.Int("FORUM_PASSWORD_MIN_LENGTH", 8)
+}
+
+// Validate checks a new password against the password policy and
+// returns a user-facing error if it is not acceptable.
+func Validate(password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < MinLength() {
+		return fmt.Errorf("Password must be at least %d characters long.", MinLength())
+	}
+	if n > maxLength {
+		return fmt.Errorf("Password must be at most %d characters long.", maxLength)
+	}
+	if common[strings.ToLower(password)] {
+		return errors.New("That password is too common. Please choose another one.")
+	}
+	return nil
+}
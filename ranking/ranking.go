@@ -0,0 +1,38 @@
+// Package ranking scores posts for the "hot" and "controversial" feeds.
+// Scores only change when a post is voted on, so they are stored with
+// the post (see database.RefreshPostScores) and feeds sort by column.
+package ranking
+
+import (
+	"math"
+	"time"
+)
+
+// hotEpoch and hotDecay set how fast posts cool down: a post needs about
+// ten times the net votes to rank with one hotDecay seconds (12.5h) newer.
+// The epoch only keeps the numbers small.
+const (
+	hotEpoch = 1704067200 // 2024-01-01
+	hotDecay = 45000
+)
+
+// Hot ranks a post by its net votes (on a log scale) plus its age, so
+// the order of two posts never changes unless somebody votes.
+func Hot(up, down int, created time.Time) float64 {
+	score := float64(up - down)
+	order := math.Log10(1 + math.Abs(score))
+	if score < 0 {
+		order = -order
+	}
+	return order + float64(created.Unix()-hotEpoch)/hotDecay
+}
+
+// Controversy is high for posts with many votes split evenly between
+// likes and dislikes, and 0 for posts without votes on both sides.
+func Controversy(up, down int) float64 {
+	if up <= 0 || down <= 0 {
+		return 0
+	}
+	balance := float64(min(up, down)) / float64(max(up, down))
+	return math.Pow(float64(up+down), balance)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+)
+
+func newSettingsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/settings", auth.SettingsHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// requestEmailChange submits the email form and reports whether a
+// confirmation link for email was issued.
+func requestEmailChange(t *testing.T, srv *httptest.Server, client *http.Client, userID int, email, password string) bool {
+	t.Helper()
+	form := url.Values{"action": {"email"}, "email": {email}}
+	if password != "" {
+		form.Set("current_password", password)
+	}
+	resp, err := client.PostForm(srv.URL+"/settings", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /settings: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var issued int
+	database.DB.QueryRow(
+		"SELECT COUNT(*) FROM email_verifications WHERE user_id = ? AND email = ?", userID, email,
+	).Scan(&issued)
+	return issued > 0
+}
+
+func TestEmailChangeNeedsPassword(t *testing.T) {
+	resetThrottle(t)
+	srv := newSettingsServer(t)
+	userID := newUser(t, "mover@example.com", "mover", "Zebra-Orbit-991", true)
+	client := newClient(t)
+	logIn(t, client, srv, userID)
+
+	// A stolen session alone can't send the account's email elsewhere
+	if requestEmailChange(t, srv, client, userID, "thief@example.com", "") {
+		t.Error("email change without the password was accepted")
+	}
+	if requestEmailChange(t, srv, client, userID, "thief@example.com", "wrong-password") {
+		t.Error("email change with a wrong password was accepted")
+	}
+	if !requestEmailChange(t, srv, client, userID, "mover-new@example.com", "Zebra-Orbit-991") {
+		t.Error("email change with the password was refused")
+	}
+}
+
+func TestEmailChangeNeedsFreshLoginWithoutPassword(t *testing.T) {
+	resetThrottle(t)
+	srv := newSettingsServer(t)
+	userID := newUser(t, "nopass-mover@example.com", "nopassmover", "", true)
+	client := newClient(t)
+	logIn(t, client, srv, userID)
+
+	// A session older than the re-authentication window is refused...
+	old := time.Now().Add(-time.Hour)
+	if _, err := database.DB.Exec("UPDATE sessions SET created_at = ? WHERE user_id = ?", old, userID); err != nil {
+		t.Fatal(err)
+	}
+	if requestEmailChange(t, srv, client, userID, "nopass-thief@example.com", "") {
+		t.Error("email change from an old session was accepted")
+	}
+
+	// ...a fresh login is enough
+	fresh := newClient(t)
+	logIn(t, fresh, srv, userID)
+	if !requestEmailChange(t, srv, fresh, userID, "nopass-new@example.com", "") {
+		t.Error("email change right after logging in was refused")
+	}
+}
+
+func TestUsernameChange(t *testing.T) {
+	srv := newSettingsServer(t)
+	userID := newUser(t, "renamer@example.com", "renamer", "Zebra-Orbit-991", true)
+	newUser(t, "taken@example.com", "taken", "Zebra-Orbit-991", true)
+	client := newClient(t)
+	logIn(t, client, srv, userID)
+
+	for _, tt := range []struct{ username, want string }{
+		{"taken", "renamer"},
+		{"renamed", "renamed"},
+	} {
+		resp, err := client.PostForm(srv.URL+"/settings", url.Values{"action": {"username"}, "username": {tt.username}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		var username string
+		database.DB.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+		if username != tt.want {
+			t.Errorf("after asking for %q: username %q, want %q", tt.username, username, tt.want)
+		}
+	}
+}
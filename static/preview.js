@@ -0,0 +1,34 @@
+// Markdown preview: a button with data-preview="<element id>" sends its
+// form to /preview and shows the rendered (already sanitised) HTML in
+// that element. The form's csrf_token field goes along with it.
+(function () {
+    "use strict";
+
+    function preview(button) {
+        var form = button.form;
+        var target = document.getElementById(button.getAttribute("data-preview"));
+        if (!form || !target) return;
+
+        fetch("/preview", {
+            method: "POST",
+            credentials: "same-origin",
+            body: new URLSearchParams(new FormData(form))
+        }).then(function (res) {
+            return res.text().then(function (text) {
+                if (!res.ok) throw new Error(text.trim() || "Request failed.");
+                return text;
+            });
+        }).then(function (html) {
+            target.innerHTML = html || "<p><em>Nothing to preview.</em></p>";
+        }).catch(function (err) {
+            target.textContent = "Preview failed: " + err.message;
+        });
+    }
+
+    document.addEventListener("click", function (e) {
+        var button = e.target.closest("button[data-preview]");
+        if (!button) return;
+        e.preventDefault();
+        preview(button);
+    });
+})();
@@ -0,0 +1,104 @@
+// Passkey ceremonies: fetch options from the server, hand them to the
+// browser's WebAuthn API, post the authenticator's answer back.
+// Binary values travel as base64url strings in both directions.
+(function () {
+    "use strict";
+
+    function toBytes(s) {
+        s = s.replace(/-/g, "+").replace(/_/g, "/");
+        while (s.length % 4) s += "=";
+        var bin = atob(s);
+        var out = new Uint8Array(bin.length);
+        for (var i = 0; i < bin.length; i++) out[i] = bin.charCodeAt(i);
+        return out.buffer;
+    }
+
+    function toBase64URL(buf) {
+        var bytes = new Uint8Array(buf), bin = "";
+        for (var i = 0; i < bytes.length; i++) bin += String.fromCharCode(bytes[i]);
+        return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    function csrfToken() {
+        var meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : "";
+    }
+
+    function post(url, body) {
+        return fetch(url, {
+            method: "POST",
+            credentials: "same-origin",
+            headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken() },
+            body: JSON.stringify(body || {})
+        }).then(function (res) {
+            return res.json().then(function (data) {
+                if (!res.ok) throw new Error(data.error || "Request failed.");
+                return data;
+            });
+        });
+    }
+
+    function showError(err) {
+        var el = document.getElementById("passkey-error");
+        if (!el) return;
+        // The user closing the browser dialog is not worth a red message
+        el.textContent = err.name === "NotAllowedError" ? "Cancelled." : err.message;
+    }
+
+    function register(name) {
+        return post("/webauthn/register/begin").then(function (opts) {
+            opts.challenge = toBytes(opts.challenge);
+            opts.user.id = toBytes(opts.user.id);
+            opts.excludeCredentials = (opts.excludeCredentials || []).map(function (c) {
+                return { type: c.type, id: toBytes(c.id) };
+            });
+            return navigator.credentials.create({ publicKey: opts });
+        }).then(function (cred) {
+            return post("/webauthn/register/finish", {
+                name: name,
+                clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+                attestationObject: toBase64URL(cred.response.attestationObject)
+            });
+        });
+    }
+
+    function login() {
+        return post("/webauthn/login/begin").then(function (opts) {
+            opts.challenge = toBytes(opts.challenge);
+            return navigator.credentials.get({ publicKey: opts });
+        }).then(function (cred) {
+            return post("/webauthn/login/finish", {
+                id: cred.id,
+                clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+                authenticatorData: toBase64URL(cred.response.authenticatorData),
+                signature: toBase64URL(cred.response.signature),
+                userHandle: cred.response.userHandle ? toBase64URL(cred.response.userHandle) : ""
+            });
+        });
+    }
+
+    function follow(data) {
+        window.location.href = data.redirect || "/";
+    }
+
+    document.addEventListener("DOMContentLoaded", function () {
+        var supported = !!window.PublicKeyCredential;
+
+        var loginButton = document.getElementById("passkey-login");
+        if (loginButton) {
+            loginButton.hidden = !supported;
+            loginButton.addEventListener("click", function () {
+                login().then(follow, showError);
+            });
+        }
+
+        var form = document.getElementById("passkey-register");
+        if (form) {
+            form.hidden = !supported;
+            form.addEventListener("submit", function (e) {
+                e.preventDefault();
+                register(form.elements.name.value).then(follow, showError);
+            });
+        }
+    });
+})();
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"forum/database"
+	auth "forum/handlers"
+	"forum/totp"
+)
+
+func newTwoFactorServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", auth.LoginHandler)
+	mux.HandleFunc("/login/2fa", auth.TwoFactorLoginHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// enableTOTP turns two-factor login on for userID and returns its secret.
+func enableTOTP(t *testing.T, userID int) string {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.DB.Exec(
+		"INSERT INTO totp_secrets (user_id, secret, enabled) VALUES (?, ?, 1)", userID, secret,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// wrongCode returns a code no authenticator window accepts right now.
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	valid := map[string]bool{}
+	step := totp.Step(time.Now())
+	for s := step - 2; s <= step+2; s++ {
+		code, err := totp.Code(secret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid[code] = true
+	}
+	for _, c := range []string{"000000", "111111", "222222"} {
+		if !valid[c] {
+			return c
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+// postCode submits the second login step and returns the response status.
+func postCode(t *testing.T, srv *httptest.Server, client *http.Client, code string) int {
+	t.Helper()
+	resp, err := client.PostForm(srv.URL+"/login/2fa", url.Values{"code": {code}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	resetThrottle(t)
+	srv := newTwoFactorServer(t)
+	userID := newUser(t, "totp@example.com", "totp", "Zebra-Orbit-991", true)
+	secret := enableTOTP(t, userID)
+
+	client := newClient(t)
+	if code := postLogin(t, srv, client, "totp@example.com", "Zebra-Orbit-991"); code != http.StatusSeeOther {
+		t.Fatalf("password step: status %d, want %d", code, http.StatusSeeOther)
+	}
+	if got := sessionUser(t, client, srv); got != 0 {
+		t.Fatalf("password alone logged in as user %d", got)
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := postCode(t, srv, client, code); status != http.StatusSeeOther {
+		t.Fatalf("code step: status %d, want %d", status, http.StatusSeeOther)
+	}
+	if got := sessionUser(t, client, srv); got != userID {
+		t.Errorf("logged in as user %d, want %d", got, userID)
+	}
+}
+
+func TestTwoFactorCodesLockOutPasswordHolder(t *testing.T) {
+	resetThrottle(t)
+	srv := newTwoFactorServer(t)
+	userID := newUser(t, "totp-guess@example.com", "totpguess", "Zebra-Orbit-991", true)
+	secret := enableTOTP(t, userID)
+	bad := wrongCode(t, secret)
+
+	// Each login starts a new challenge with its own attempt counter;
+	// the failures still add up against the account.
+	var client *http.Client
+	for login := 0; login < 3; login++ {
+		client = newClient(t)
+		if code := postLogin(t, srv, client, "totp-guess@example.com", "Zebra-Orbit-991"); code != http.StatusSeeOther {
+			t.Fatalf("login %d: password step status %d, want %d", login+1, code, http.StatusSeeOther)
+		}
+		for guess := 0; guess < 2; guess++ {
+			if status := postCode(t, srv, client, bad); status != http.StatusOK {
+				t.Fatalf("login %d: wrong code status %d, want %d", login+1, status, http.StatusOK)
+			}
+		}
+	}
+
+	// Locked: even the right code is refused now
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := postCode(t, srv, client, code); status != http.StatusTooManyRequests {
+		t.Errorf("right code after six wrong ones: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+	if got := sessionUser(t, client, srv); got != 0 {
+		t.Errorf("locked-out login created a session for user %d", got)
+	}
+
+	// And the password can't open a new challenge to guess from
+	again := newClient(t)
+	if code := postLogin(t, srv, again, "totp-guess@example.com", "Zebra-Orbit-991"); code != http.StatusTooManyRequests {
+		t.Errorf("password after the lockout: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
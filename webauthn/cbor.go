@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) decoder, enough for attestation objects and
+// COSE keys: integers, byte/text strings, arrays, maps, tags and the
+// simple values false/true/null. Indefinite lengths and floats are not
+// used by authenticators and are rejected.
+//
+// Decoded values are int64, []byte, string, []interface{},
+// map[interface{}]interface{} (keys are int64 or string), bool or nil.
+
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// maxDepth bounds nesting so hostile input can't exhaust the stack.
+const maxDepth = 16
+
+// decodeCBOR decodes one data item from b and returns it with the bytes
+// that follow it.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth || len(b) == 0 {
+		return nil, nil, errCBOR
+	}
+
+	major := b[0] >> 5
+	n, rest, err := decodeArgument(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // unsigned integer
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(n), rest, nil
+
+	case 1: // negative integer: -1 - n
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(n), rest, nil
+
+	case 2, 3: // byte string, text string
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		data := rest[:n]
+		if major == 3 {
+			return string(data), rest[n:], nil
+		}
+		return append([]byte(nil), data...), rest[n:], nil
+
+	case 4: // array
+		if n > uint64(len(rest)) { // every item takes at least one byte
+			return nil, nil, errCBOR
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+
+	case 5: // map
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			if k, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if v, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, rest, nil
+
+	case 6: // tag: the tagged item is all we need
+		return decodeItem(rest, depth+1)
+
+	default: // 7: simple values
+		switch b[0] & 0x1f {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22, 23:
+			return nil, rest, nil
+		}
+		return nil, nil, errCBOR
+	}
+}
+
+// decodeArgument reads the length/value argument that follows the
+// initial byte of every CBOR item.
+func decodeArgument(b []byte) (uint64, []byte, error) {
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24 && len(b) >= 1:
+		return uint64(b[0]), b[1:], nil
+	case info == 25 && len(b) >= 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26 && len(b) >= 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27 && len(b) >= 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, errCBOR
+}
@@ -0,0 +1,129 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) the forum accepts, in order of preference.
+const (
+	AlgES256 = -7   // ECDSA P-256 with SHA-256
+	AlgEdDSA = -8   // Ed25519
+	AlgRS256 = -257 // RSASSA-PKCS1-v1_5 with SHA-256
+)
+
+// Algorithms is sent to the browser as pubKeyCredParams.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1 // EC2 / OKP
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1 // RSA
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+var ErrBadSignature = errors.New("webauthn: signature does not verify")
+
+// PublicKey is a credential public key decoded from its COSE_Key encoding.
+type PublicKey struct {
+	Alg int
+	key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key, as stored for a credential.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: COSE key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: bad P-256 key")
+		}
+		// Rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &PublicKey{Alg: AlgES256, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: bad Ed25519 key")
+		}
+		return &PublicKey{Alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: bad RSA key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &PublicKey{Alg: AlgRS256, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exp,
+		}}, nil
+	}
+
+	return nil, errors.New("webauthn: unsupported key type or algorithm")
+}
+
+// Verify checks sig over data.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Package webauthn implements the server side of the WebAuthn
+// registration and authentication ceremonies (passkeys) for the forum.
+//
+// Only what the forum needs is supported: attestation is requested as
+// "none" and the attestation statement is not checked, so a credential is
+// trusted because the logged-in user registered it, not because of who
+// made the authenticator.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	FlagAttested     = 0x40
+	FlagExtensions   = 0x80
+)
+
+var (
+	ErrChallenge = errors.New("webauthn: challenge does not match")
+	ErrOrigin    = errors.New("webauthn: origin does not match")
+	ErrRPID      = errors.New("webauthn: relying party ID does not match")
+	ErrPresence  = errors.New("webauthn: user presence flag not set")
+	// ErrCloned means the signature counter went backwards: the
+	// authenticator may have been copied.
+	ErrCloned = errors.New("webauthn: signature counter did not increase")
+)
+
+// Encoding is how binary values travel between server and browser.
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty describes the forum to authenticators.
+type RelyingParty struct {
+	ID     string // e.g. "forum.example.com"
+	Name   string // shown by the browser
+	Origin string // e.g. "https://forum.example.com"
+}
+
+// NewChallenge returns a random base64url challenge.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Encoding.EncodeToString(b), nil
+}
+
+// Credential is what registration yields and the forum stores.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key, see ParsePublicKey
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Assertion is the outcome of a successful login ceremony.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// AuthenticatorData is the parsed authData structure.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Only present when FlagAttested is set (registration)
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// ParseAuthenticatorData decodes authData as laid out in the WebAuthn
+// spec: rpIdHash (32) | flags (1) | signCount (4) | [attested data] | [extensions].
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	ad := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if ad.Flags&FlagAttested != 0 {
+		// aaguid (16) | credentialIdLength (2) | credentialId | credentialPublicKey
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		ad.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, errors.New("webauthn: bad credential ID length")
+		}
+		ad.CredentialID = rest[:n]
+		rest = rest[n:]
+
+		// The key is a CBOR item of unknown length
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		ad.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.Flags&FlagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return ad, nil
+}
+
+// clientData is the JSON the browser signs over.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("webauthn: bad client data: %w", err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("webauthn: client data type %q, want %q", cd.Type, typ)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return ErrChallenge
+	}
+	if cd.Origin != rp.Origin {
+		return ErrOrigin
+	}
+	return nil
+}
+
+func (rp *RelyingParty) checkAuthData(ad *AuthenticatorData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, want[:]) {
+		return ErrRPID
+	}
+	if ad.Flags&FlagUserPresent == 0 {
+		return ErrPresence
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create()
+// for the given challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	raw, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authData")
+	}
+
+	ad, err := ParseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthData(ad); err != nil {
+		return nil, err
+	}
+	if ad.Flags&FlagAttested == 0 {
+		return nil, errors.New("webauthn: no attested credential data")
+	}
+
+	// Reject keys we could never verify a login with
+	if _, err := ParsePublicKey(ad.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:           append([]byte(nil), ad.CredentialID...),
+		PublicKey:    append([]byte(nil), ad.PublicKey...),
+		SignCount:    ad.SignCount,
+		UserVerified: ad.Flags&FlagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get() for
+// the given challenge against a stored credential.
+func (rp *RelyingParty) VerifyAssertion(challenge string, publicKey []byte, storedCount uint32,
+	clientDataJSON, authData, signature []byte) (*Assertion, error) {
+
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	ad, err := ParseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthData(ad); err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers authData || SHA-256(clientDataJSON)
+	clientHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientHash[:]...)
+	if err := key.Verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators without a counter always send 0
+	if (ad.SignCount != 0 || storedCount != 0) && ad.SignCount <= storedCount {
+		return nil, ErrCloned
+	}
+
+	return &Assertion{
+		SignCount:    ad.SignCount,
+		UserVerified: ad.Flags&FlagUserVerified != 0,
+	}, nil
+}
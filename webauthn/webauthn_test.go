@@ -0,0 +1,191 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"forum/webauthn"
+	"forum/webauthn/webauthntest"
+)
+
+var rp = &webauthn.RelyingParty{
+	ID:     "forum.example",
+	Name:   "Forum",
+	Origin: "https://forum.example",
+}
+
+// register runs a successful registration and returns the authenticator
+// and the credential the forum would store.
+func register(t *testing.T) (*webauthntest.Authenticator, *webauthn.Credential) {
+	t.Helper()
+	a, err := webauthntest.New(rp.ID, rp.Origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData, attestation := a.Create(challenge)
+	cred, err := rp.VerifyRegistration(challenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return a, cred
+}
+
+func TestRegistration(t *testing.T) {
+	a, cred := register(t)
+
+	if string(cred.ID) != string(a.CredentialID) {
+		t.Errorf("credential ID = %x, want %x", cred.ID, a.CredentialID)
+	}
+	if string(cred.PublicKey) != string(a.PublicKey()) {
+		t.Error("stored public key differs from the authenticator's")
+	}
+	if !cred.UserVerified {
+		t.Error("UserVerified = false, want true")
+	}
+	if _, err := webauthn.ParsePublicKey(cred.PublicKey); err != nil {
+		t.Errorf("ParsePublicKey: %v", err)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(a *webauthntest.Authenticator, challenge *string)
+		want   error
+	}{
+		{"wrong challenge", func(a *webauthntest.Authenticator, c *string) { *c = "c29tZXRoaW5nIGVsc2U" }, webauthn.ErrChallenge},
+		{"wrong origin", func(a *webauthntest.Authenticator, c *string) { a.Origin = "https://forum.example.evil" }, webauthn.ErrOrigin},
+		{"wrong rpIdHash", func(a *webauthntest.Authenticator, c *string) { a.RPID = "evil.example" }, webauthn.ErrRPID},
+		{"no user presence", func(a *webauthntest.Authenticator, c *string) { a.Flags = 0 }, webauthn.ErrPresence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := webauthntest.New(rp.ID, rp.Origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge, _ := webauthn.NewChallenge()
+			signed := challenge
+			tt.change(a, &signed)
+
+			clientData, attestation := a.Create(signed)
+			_, err = rp.VerifyRegistration(challenge, clientData, attestation)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyRegistration error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	a, cred := register(t)
+	stored := cred.SignCount
+
+	// Two logins in a row, each raising the counter
+	for i := 0; i < 2; i++ {
+		challenge, _ := webauthn.NewChallenge()
+		clientData, authData, sig, err := a.Get(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rp.VerifyAssertion(challenge, cred.PublicKey, stored, clientData, authData, sig)
+		if err != nil {
+			t.Fatalf("VerifyAssertion #%d: %v", i+1, err)
+		}
+		if got.SignCount != a.SignCount || !got.UserVerified {
+			t.Errorf("assertion = %+v, want count %d and user verified", got, a.SignCount)
+		}
+		stored = got.SignCount
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// before alters the authenticator or the challenge it signs,
+		// after the signature or the counter the forum has stored.
+		before func(a *webauthntest.Authenticator, challenge *string)
+		after  func(sig []byte, stored *uint32)
+		want   error
+	}{
+		{
+			name:   "wrong challenge",
+			before: func(a *webauthntest.Authenticator, c *string) { *c = "c29tZXRoaW5nIGVsc2U" },
+			want:   webauthn.ErrChallenge,
+		},
+		{
+			name:   "wrong origin",
+			before: func(a *webauthntest.Authenticator, c *string) { a.Origin = "https://forum.example.evil" },
+			want:   webauthn.ErrOrigin,
+		},
+		{
+			name:   "wrong rpIdHash",
+			before: func(a *webauthntest.Authenticator, c *string) { a.RPID = "evil.example" },
+			want:   webauthn.ErrRPID,
+		},
+		{
+			name:   "no user presence",
+			before: func(a *webauthntest.Authenticator, c *string) { a.Flags = webauthn.FlagUserVerified },
+			want:   webauthn.ErrPresence,
+		},
+		{
+			name:  "bad signature",
+			after: func(sig []byte, stored *uint32) { sig[len(sig)-1] ^= 0x01 },
+			want:  webauthn.ErrBadSignature,
+		},
+		{
+			name:  "counter went backwards",
+			after: func(sig []byte, stored *uint32) { *stored = 50 },
+			want:  webauthn.ErrCloned,
+		},
+		{
+			name:  "counter did not move",
+			after: func(sig []byte, stored *uint32) { *stored = 1 },
+			want:  webauthn.ErrCloned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, cred := register(t)
+			stored := cred.SignCount
+
+			challenge, _ := webauthn.NewChallenge()
+			signed := challenge
+			if tt.before != nil {
+				tt.before(a, &signed)
+			}
+			clientData, authData, sig, err := a.Get(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.after != nil {
+				tt.after(sig, &stored)
+			}
+
+			_, err = rp.VerifyAssertion(challenge, cred.PublicKey, stored, clientData, authData, sig)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyAssertion error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionWithoutCounter(t *testing.T) {
+	// Authenticators that don't count always send 0, which is allowed
+	// only while the stored count is 0 too.
+	a, cred := register(t)
+	a.SignCount = ^uint32(0) // Get wraps it back to 0
+
+	challenge, _ := webauthn.NewChallenge()
+	clientData, authData, sig, err := a.Get(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyAssertion(challenge, cred.PublicKey, 0, clientData, authData, sig); err != nil {
+		t.Errorf("VerifyAssertion with a zero counter: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Package webauthntest provides a software authenticator for testing the
+// relying-party side of WebAuthn, the way net/http/httptest provides a
+// test server: it answers the forum's challenges like a browser and a
+// security key would, with an ECDSA P-256 key held in memory.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"forum/webauthn"
+)
+
+// Authenticator is one passkey. Its fields may be changed between
+// ceremonies to produce responses a relying party must refuse.
+type Authenticator struct {
+	RPID      string // hashed into the authenticator data
+	Origin    string // put in the client data, as the browser would
+	Flags     byte   // authenticator data flags; New sets UP and UV
+	SignCount uint32 // incremented before every assertion
+
+	CredentialID []byte
+	key          *ecdsa.PrivateKey
+}
+
+// New returns an authenticator with a fresh key and credential ID.
+func New(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		Flags:        webauthn.FlagUserPresent | webauthn.FlagUserVerified,
+		CredentialID: id,
+		key:          key,
+	}, nil
+}
+
+// PublicKey returns the credential's public key as a COSE_Key.
+func (a *Authenticator) PublicKey() []byte {
+	return cborMap(
+		cborInt(1), cborInt(2), // kty: EC2
+		cborInt(3), cborInt(webauthn.AlgES256), // alg
+		cborInt(-1), cborInt(1), // crv: P-256
+		cborInt(-2), cborBytes(a.key.X.FillBytes(make([]byte, 32))),
+		cborInt(-3), cborBytes(a.key.Y.FillBytes(make([]byte, 32))),
+	)
+}
+
+// ClientData returns the clientDataJSON of a ceremony ("webauthn.create"
+// or "webauthn.get").
+func (a *Authenticator) ClientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    a.Origin,
+	})
+	return b
+}
+
+// AuthenticatorData returns authData with the current flags and counter,
+// carrying the credential when attested is true.
+func (a *Authenticator) AuthenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags := a.Flags
+	if attested {
+		flags |= webauthn.FlagAttested
+	}
+	b := append(rpIDHash[:], flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+	if attested {
+		b = append(b, make([]byte, 16)...) // AAGUID: none
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.CredentialID)))
+		b = append(b, a.CredentialID...)
+		b = append(b, a.PublicKey()...)
+	}
+	return b
+}
+
+// Create answers navigator.credentials.create() with "none" attestation.
+func (a *Authenticator) Create(challenge string) (clientDataJSON, attestationObject []byte) {
+	attestationObject = cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.AuthenticatorData(true)),
+	)
+	return a.ClientData("webauthn.create", challenge), attestationObject
+}
+
+// Get answers navigator.credentials.get(), counting one more use.
+func (a *Authenticator) Get(challenge string) (clientDataJSON, authData, signature []byte, err error) {
+	a.SignCount++
+	clientDataJSON = a.ClientData("webauthn.get", challenge)
+	authData = a.AuthenticatorData(false)
+	signature, err = a.Sign(authData, clientDataJSON)
+	return clientDataJSON, authData, signature, err
+}
+
+// Sign signs authData || SHA-256(clientDataJSON), as assertions are.
+func (a *Authenticator) Sign(authData, clientDataJSON []byte) ([]byte, error) {
+	clientHash := sha256.Sum256(clientDataJSON)
+	sum := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, a.key, sum[:])
+}
+
+// ------------------------------------------------------------
+// CBOR
+// ------------------------------------------------------------
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, uint64(len(b))), b...) }
+
+func cborText(s string) []byte { return append(cborHead(3, uint64(len(s))), s...) }
+
+// cborMap encodes alternating keys and values.
+func cborMap(items ...[]byte) []byte {
+	b := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}